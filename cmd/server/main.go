@@ -1,32 +1,117 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"restapi/internal/config"
 	"restapi/internal/db/migration"
+	"restapi/internal/db/seed"
 	"restapi/internal/logger"
 	"restapi/internal/server"
 
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
 	app := cli.NewApp()
 	app.Name = "Go Blog API"
 	app.Description = "Implementing back-end services for blog application"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			EnvVars: []string{"APP_CONFIG"},
+			Usage:   "config file, defaults to ./.<APP_ENV>/config.yml",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		err := config.Load(c.String("config"))
+		if err != nil {
+			return err
+		}
+
+		// config print must work on an invalid config to help fixing it and
+		// secrets produces the values that make it valid
+		switch c.Args().First() {
+		case "config", "secrets":
+			return nil
+		}
+
+		err = config.Cfg().Validate()
+		if err != nil {
+			return err
+		}
+
+		logger.Init()
+		return nil
+	}
 
 	app.Commands = []*cli.Command{
 		{
 			Name:        "migrations",
-			Description: "migrations looks at the currently active migration version and will migrate all the way up (applying all up migrations)",
+			Description: "migrations creates the configured schemas when missing and will migrate all the way up (applying all up migrations)",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "schema",
+					Usage: "schema to migrate, can be repeated (defaults to DATABASE_SCHEMA and DATABASE_SCHEMAS)",
+				},
+			},
 			Action: func(c *cli.Context) error {
-				return migration.Up()
+				return migration.Up(c.StringSlice("schema")...)
 			},
 		},
 		{
 			Name:        "drop",
 			Description: "drop deletes everything in the database",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "schema",
+					Usage: "schema to drop, can be repeated (defaults to DATABASE_SCHEMA and DATABASE_SCHEMAS)",
+				},
+			},
 			Action: func(c *cli.Context) error {
-				return migration.Drop()
+				return migration.Drop(c.StringSlice("schema")...)
+			},
+		},
+		{
+			Name:        "seed",
+			Description: "seed upserts the fixtures of an environment and optionally creates the first administrator",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "env",
+					Value: "development",
+					Usage: "environment whose fixtures are loaded from ./.<env>/fixtures",
+				},
+				&cli.StringFlag{
+					Name:  "fixtures",
+					Usage: "fixture directory, overrides --env",
+				},
+				&cli.StringFlag{
+					Name:  "admin",
+					Usage: "username of the administrator to create",
+				},
+				&cli.BoolFlag{
+					Name:  "admin-password-stdin",
+					Usage: "read the administrator password from stdin instead of SEED_ADMIN_PASSWORD",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				dir := c.String("fixtures")
+				if dir == "" {
+					dir = seed.Dir(c.String("env"))
+				}
+
+				var admin *seed.Admin
+				if c.String("admin") != "" {
+					password, err := adminPassword(c.Bool("admin-password-stdin"))
+					if err != nil {
+						return err
+					}
+					admin = &seed.Admin{Username: c.String("admin"), Password: password}
+				}
+
+				return seed.Run(dir, admin)
 			},
 		},
 		{
@@ -47,6 +132,70 @@ func main() {
 				return server.Start()
 			},
 		},
+		userCommand(),
+		serviceAccountCommand(),
+		oauthClientCommand(),
+		mockIdPCommand(),
+		mockLDAPCommand(),
+		{
+			Name:        "secrets",
+			Description: "secrets produces encrypted values for the config",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "encrypt",
+					Usage: "encrypt a value read from stdin with the master key and print its enc: form",
+					Action: func(c *cli.Context) error {
+						key, err := config.MasterKey()
+						if err != nil {
+							return err
+						}
+
+						plain, err := readPassword("Value: ")
+						if err != nil {
+							return err
+						}
+
+						value, err := config.Encrypt(plain, key)
+						if err != nil {
+							return err
+						}
+
+						fmt.Println(value)
+						return nil
+					},
+				},
+				{
+					Name:  "keygen",
+					Usage: "print a new random master key for " + config.MasterKeyEnv,
+					Action: func(c *cli.Context) error {
+						key, err := config.NewMasterKey()
+						if err != nil {
+							return err
+						}
+
+						fmt.Println(key)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:        "config",
+			Description: "config inspects the loaded configuration",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "print",
+					Usage: "print the effective configuration with secrets masked",
+					Action: func(c *cli.Context) error {
+						err := yaml.NewEncoder(os.Stdout).Encode(config.Cfg().Masked())
+						if err != nil {
+							return err
+						}
+						return config.Cfg().Validate()
+					},
+				},
+			},
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -54,3 +203,15 @@ func main() {
 		logger.Log().Fatal().Err(err).Msg("failed to run server")
 	}
 }
+
+func adminPassword(stdin bool) (string, error) {
+	if !stdin {
+		password := os.Getenv("SEED_ADMIN_PASSWORD")
+		if password == "" {
+			return "", errors.New("SEED_ADMIN_PASSWORD is empty, set it or use --admin-password-stdin")
+		}
+		return password, nil
+	}
+
+	return readLine()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/token"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/urfave/cli/v2"
+)
+
+// mockCode is an authorization code of the mock identity provider.
+type mockCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+// mockIdP is a minimal OpenID Connect provider that logs in one fixed user
+// without asking, to try the external login locally.
+type mockIdP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	claims       jwt.MapClaims
+	signer       *token.IDTokenSigner
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+func mockIdPCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "mock-idp",
+		Description: "mock-idp serves an OpenID Connect provider which logs in one fixed user, for development only",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "addr", Value: ":9999", Usage: "address to listen on"},
+			&cli.StringFlag{Name: "issuer", Value: "http://localhost:9999", Usage: "issuer url, as configured in OIDC_PROVIDERS"},
+			&cli.StringFlag{Name: "client-id", Value: "restapi", Usage: "client id expected at the token endpoint"},
+			&cli.StringFlag{Name: "client-secret", Value: "secret", Usage: "client secret expected at the token endpoint"},
+			&cli.StringFlag{Name: "subject", Value: "mock-user-1", Usage: "sub claim of the user"},
+			&cli.StringFlag{Name: "username", Value: "mockuser", Usage: "preferred_username claim of the user"},
+			&cli.StringFlag{Name: "email", Value: "mockuser@example.com", Usage: "email claim of the user"},
+			&cli.StringFlag{Name: "role-claim", Value: "roles", Usage: "claim holding the groups"},
+			&cli.StringSliceFlag{Name: "group", Value: cli.NewStringSlice("users"), Usage: "group of the user, repeatable"},
+		},
+		Action: func(c *cli.Context) error {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				return err
+			}
+
+			idp := &mockIdP{
+				issuer:       c.String("issuer"),
+				clientID:     c.String("client-id"),
+				clientSecret: c.String("client-secret"),
+				claims: jwt.MapClaims{
+					"sub":                  c.String("subject"),
+					"preferred_username":   c.String("username"),
+					"email":                c.String("email"),
+					c.String("role-claim"): c.StringSlice("group"),
+				},
+				signer: token.NewIDTokenSigner(key),
+				codes:  map[string]mockCode{},
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+			mux.HandleFunc("/authorize", idp.authorize)
+			mux.HandleFunc("/token", idp.token)
+			mux.HandleFunc("/jwks", idp.jwks)
+
+			fmt.Printf("mock identity provider %s listening on %s\n", idp.issuer, c.String("addr"))
+			return http.ListenAndServe(c.String("addr"), mux)
+		},
+	}
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.issuer,
+		"authorization_endpoint":                idp.issuer + "/authorize",
+		"token_endpoint":                        idp.issuer + "/token",
+		"jwks_uri":                              idp.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodRS256.Alg()},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize approves every request of the configured client.
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("client_id") != idp.clientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "expected response_type=code, the configured client_id and a S256 code_challenge", http.StatusBadRequest)
+		return
+	}
+
+	code := oidc.RandomString(32)
+	idp.mu.Lock()
+	idp.codes[code] = mockCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: redirectURI.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	idp.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != idp.clientID || secret != idp.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok || time.Now().After(code.expiresAt) || code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.Challenge(r.PostForm.Get("code_verifier")) != code.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   idp.issuer,
+		"aud":   code.clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": code.nonce,
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	idToken, err := idp.signer.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": oidc.RandomString(32),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, idp.signer.JWKS())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
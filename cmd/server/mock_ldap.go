@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"restapi/internal/security/ldap"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func mockLDAPCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "mock-ldap",
+		Description: "mock-ldap serves an in-memory LDAP directory, for development only",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "addr", Value: ":3389", Usage: "address to listen on"},
+			&cli.StringFlag{Name: "base-dn", Value: "dc=example,dc=com", Usage: "suffix of the directory"},
+			&cli.StringFlag{Name: "bind-password", Value: "admin", Usage: "password of cn=admin,<base-dn>, the LDAP_BIND_DN"},
+			&cli.StringSliceFlag{
+				Name:  "user",
+				Value: cli.NewStringSlice("alice:alicepass:admins", "bobby:bobbypass:staff"),
+				Usage: "user as uid:password:group|group, repeatable",
+			},
+			&cli.BoolFlag{Name: "start-tls", Usage: "answer StartTLS with a self-signed certificate"},
+		},
+		Action: func(c *cli.Context) error {
+			base := c.String("base-dn")
+			server := &ldap.Server{
+				Passwords: map[string]string{strings.ToLower("cn=admin," + base): c.String("bind-password")},
+			}
+
+			for _, spec := range c.StringSlice("user") {
+				parts := strings.SplitN(spec, ":", 3)
+				if len(parts) < 2 || parts[0] == "" {
+					return fmt.Errorf("user %q must be uid:password:group|group", spec)
+				}
+
+				entry := &ldap.Entry{
+					DN: fmt.Sprintf("uid=%s,ou=people,%s", parts[0], base),
+					Attributes: map[string][]string{
+						"objectClass": {"inetOrgPerson"},
+						"uid":         {parts[0]},
+						"mail":        {parts[0] + "@example.com"},
+					},
+				}
+				if len(parts) == 3 && parts[2] != "" {
+					for _, group := range strings.Split(parts[2], "|") {
+						entry.Attributes["memberOf"] = append(entry.Attributes["memberOf"], fmt.Sprintf("cn=%s,ou=groups,%s", group, base))
+					}
+				}
+
+				server.Entries = append(server.Entries, entry)
+				server.Passwords[strings.ToLower(entry.DN)] = parts[1]
+			}
+
+			if c.Bool("start-tls") {
+				cert, err := selfSignedCertificate()
+				if err != nil {
+					return err
+				}
+				server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+			}
+
+			l, err := net.Listen("tcp", c.String("addr"))
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("mock ldap directory %s listening on %s\n", base, l.Addr())
+			return server.Serve(l)
+		},
+	}
+}
+
+// selfSignedCertificate returns a certificate for localhost, clients need
+// LDAP_INSECURE_SKIP_VERIFY to accept it.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
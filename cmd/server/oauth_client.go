@@ -0,0 +1,101 @@
+package main
+
+import (
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/app/service"
+	"restapi/internal/security/token"
+	"restapi/internal/validation"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+func newOAuthService(t *userTools) service.OAuthService {
+	return service.NewOAuthService(
+		repository.NewOAuthClientRepo(t.pg),
+		t.userRepo,
+		repository.NewAuthRepo(t.rds),
+		t.authService,
+		service.NewAuditService(repository.NewAuditRepo(t.pg)),
+		token.NewToken(),
+	)
+}
+
+func oauthClientCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "oauth-client",
+		Description: "oauth-client manages the applications using the authorization code grant",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Value:   "table",
+				Usage:   "output format, table or json",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "create",
+				Usage:     "register a client, the secret of confidential clients is printed once",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "redirect-uri", Required: true, Usage: "redirect uri of the client, repeatable"},
+					&cli.StringSliceFlag{Name: "post-logout-redirect-uri", Usage: "where logout may send the browser back to, repeatable"},
+					&cli.StringSliceFlag{Name: "scope", Value: cli.NewStringSlice(model.ScopeUserRead), Usage: "scope the client may request, repeatable"},
+					&cli.BoolFlag{Name: "public", Usage: "client without secret, e.g. a SPA or a mobile app"},
+				},
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					req := model.OAuthClientCreateRequest{
+						Name:                   c.Args().First(),
+						RedirectURIs:           c.StringSlice("redirect-uri"),
+						PostLogoutRedirectURIs: c.StringSlice("post-logout-redirect-uri"),
+						Scopes:                 c.StringSlice("scope"),
+						Public:                 c.Bool("public"),
+					}
+					err := validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := newOAuthService(t).CreateClient(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printOAuthClients(c, res)
+				}),
+			},
+			{
+				Name:  "list",
+				Usage: "list clients",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					res, err := newOAuthService(t).ListClients(c.Context)
+					if err != nil {
+						return err
+					}
+
+					return printOAuthClients(c, res...)
+				}),
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a client and the consents granted to it",
+				ArgsUsage: "<client id>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					return newOAuthService(t).DeleteClient(c.Context, c.Args().First())
+				}),
+			},
+		},
+	}
+}
+
+func printOAuthClients(c *cli.Context, clients ...*model.OAuthClientResponse) error {
+	rows := make([][]string, len(clients))
+	for i, o := range clients {
+		rows[i] = []string{strconv.Itoa(int(o.ID)), o.Name, o.ClientID, o.ClientSecret, strings.Join(o.RedirectURIs, " "), strings.Join(o.Scopes, " "), strconv.FormatBool(o.Public)}
+	}
+
+	return render(c, clients, []string{"ID", "NAME", "CLIENT ID", "CLIENT SECRET", "REDIRECT URIS", "SCOPES", "PUBLIC"}, rows)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/app/service"
+	"restapi/internal/security/token"
+	"restapi/internal/validation"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+func newServiceAccountService(t *userTools) service.ServiceAccountService {
+	return service.NewServiceAccountService(
+		repository.NewServiceAccountRepo(t.pg),
+		repository.NewAuthRepo(t.rds),
+		service.NewAuditService(repository.NewAuditRepo(t.pg)),
+		token.NewToken(),
+	)
+}
+
+func serviceAccountCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "service-account",
+		Description: "service-account manages the machine clients of the client_credentials grant",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Value:   "table",
+				Usage:   "output format, table or json",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "create",
+				Usage:     "create a service account, the client secret is printed once",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "role", Value: "user", Usage: "role of the tokens issued to the account"},
+					&cli.StringSliceFlag{Name: "scope", Usage: "scope the account may request, user:read or user:write, repeatable"},
+				},
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					req := model.ServiceAccountCreateRequest{
+						Name:   c.Args().First(),
+						Role:   c.String("role"),
+						Scopes: c.StringSlice("scope"),
+					}
+					err := validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := newServiceAccountService(t).Create(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printServiceAccounts(c, res)
+				}),
+			},
+			{
+				Name:  "list",
+				Usage: "list service accounts",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					res, err := newServiceAccountService(t).List(c.Context)
+					if err != nil {
+						return err
+					}
+
+					return printServiceAccounts(c, res...)
+				}),
+			},
+			{
+				Name:      "disable",
+				Usage:     "stop issuing tokens to a service account",
+				ArgsUsage: "<name>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					res, err := newServiceAccountService(t).Disable(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					return printServiceAccounts(c, res)
+				}),
+			},
+			{
+				Name:      "rotate-secret",
+				Usage:     "replace the client secret, the new one is printed once",
+				ArgsUsage: "<name>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					res, err := newServiceAccountService(t).RotateSecret(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					return printServiceAccounts(c, res)
+				}),
+			},
+		},
+	}
+}
+
+func printServiceAccounts(c *cli.Context, accounts ...*model.ServiceAccountResponse) error {
+	rows := make([][]string, len(accounts))
+	for i, a := range accounts {
+		rows[i] = []string{strconv.Itoa(int(a.ID)), a.Name, a.ClientID, a.ClientSecret, a.Role, strings.Join(a.Scopes, " "), strconv.FormatBool(a.Disabled)}
+	}
+
+	return render(c, accounts, []string{"ID", "NAME", "CLIENT ID", "CLIENT SECRET", "ROLE", "SCOPES", "DISABLED"}, rows)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/db/postgres"
+	"restapi/internal/db/redis"
+	"restapi/internal/security/token"
+	"restapi/internal/validation"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+	"gorm.io/gorm"
+)
+
+// userTools wires the services the same way server.NewRouter does so the
+// commands go through the same business rules as the HTTP API.
+type userTools struct {
+	pg          postgres.Client
+	rds         redis.Client
+	userRepo    repository.UserRepo
+	userService service.UserService
+	authService service.AuthService
+}
+
+func newUserTools() (*userTools, error) {
+	pg, err := postgres.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	rds, err := redis.NewClient()
+	if err != nil {
+		pg.Close()
+		return nil, err
+	}
+
+	userRepo := repository.NewUserRepo(pg, rds)
+	authRepo := repository.NewAuthRepo(rds)
+	customRepo := repository.NewCustom(pg)
+
+	return &userTools{
+		pg:          pg,
+		rds:         rds,
+		userRepo:    userRepo,
+		userService: service.NewUserService(userRepo, customRepo, repository.NewPasswordHistoryRepo(pg)),
+		authService: service.NewAuthService(userRepo, authRepo, token.NewToken(), service.NewAuthenticatorChain(
+			userRepo,
+			service.NewLocalAuthenticator(userRepo),
+			service.NewLDAPAuthenticator(userRepo),
+		), repository.NewWebAuthnCredentialRepo(pg)),
+	}, nil
+}
+
+func (t *userTools) Close() {
+	t.rds.Close()
+	t.pg.Close()
+}
+
+// lookup resolves a numeric id or a username to a user id.
+func (t *userTools) lookup(ctx context.Context, arg string) (uint, error) {
+	if arg == "" {
+		return 0, errors.New("user id or username is required")
+	}
+
+	if id, err := strconv.ParseUint(arg, 10, 64); err == nil {
+		return uint(id), nil
+	}
+
+	user, err := t.userRepo.GetByUsername(ctx, arg)
+	if err == gorm.ErrRecordNotFound {
+		return 0, constant.ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	return user.ID, nil
+}
+
+func userCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "user",
+		Description: "user manages accounts without going through the HTTP API",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Value:   "table",
+				Usage:   "output format, table or json",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "create",
+				Usage:     "create an account, the password is prompted",
+				ArgsUsage: "<username>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "role", Value: "user", Usage: "role of the new account"},
+					&cli.StringFlag{Name: "email", Usage: "email the magic links of the account are sent to"},
+				},
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					password, repassword, err := promptNewPassword()
+					if err != nil {
+						return err
+					}
+
+					req := model.UserCreateRequest{
+						Username:   c.Args().First(),
+						Password:   password,
+						RePassword: repassword,
+						Email:      c.String("email"),
+					}
+					err = validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					req.UserRole = c.String("role")
+					res, err := t.userService.Create(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:  "list",
+				Usage: "list accounts",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "search", Usage: "search on id, username and role"},
+					&cli.IntFlag{Name: "start", Usage: "offset of the first account"},
+					&cli.IntFlag{Name: "length", Value: 50, Usage: "number of accounts"},
+				},
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					res, err := t.userService.List(c.Context, model.RequestDataTable{
+						Search: c.String("search"),
+						Start:  c.Int("start"),
+						Length: c.Int("length"),
+					})
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res.Data...)
+				}),
+			},
+			{
+				Name:      "reset-password",
+				Usage:     "set a new password without knowing the old one",
+				ArgsUsage: "<id|username>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					password, repassword, err := promptNewPassword()
+					if err != nil {
+						return err
+					}
+
+					req := model.UserPasswordResetRequest{
+						ID:            id,
+						NewPassword:   password,
+						ReNewPassword: repassword,
+					}
+					err = validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := t.userService.ResetPassword(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:      "set-role",
+				Usage:     "change the role of an account and end its sessions",
+				ArgsUsage: "<id|username> <role>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					req := model.UserRoleUpdateRequest{ID: id, Role: c.Args().Get(1)}
+					err = validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := t.userService.SetRole(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					// the sessions carry the previous role
+					err = t.authService.LogoutAll(c.Context, id)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:      "set-email",
+				Usage:     "change the email the magic links of an account are sent to, without an email it is removed",
+				ArgsUsage: "<id|username> [email]",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					user, err := t.userService.Get(c.Context, id)
+					if err != nil {
+						return err
+					}
+
+					req := model.UserUpdateRequest{ID: id, Username: user.Username, Email: c.Args().Get(1)}
+					err = validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := t.userService.Update(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:      "set-auth-source",
+				Usage:     "check the password of an account with local or ldap only, without a source AUTH_BACKENDS is tried in order",
+				ArgsUsage: "<id|username> [local|ldap]",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					req := model.UserAuthSourceUpdateRequest{ID: id, AuthSource: c.Args().Get(1)}
+					err = validation.Struct(req)
+					if err != nil {
+						return err
+					}
+
+					res, err := t.userService.SetAuthSource(c.Context, req)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:      "disable",
+				Usage:     "disable an account and end its sessions",
+				ArgsUsage: "<id|username>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					res, err := t.userService.Disable(c.Context, id)
+					if err != nil {
+						return err
+					}
+
+					err = t.authService.LogoutAll(c.Context, id)
+					if err != nil {
+						return err
+					}
+
+					return printUsers(c, res)
+				}),
+			},
+			{
+				Name:      "sessions",
+				Usage:     "list the active sessions of an account",
+				ArgsUsage: "<id|username>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					res, err := t.authService.Sessions(c.Context, id)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, len(res))
+					for i, s := range res {
+						rows[i] = []string{s.TokenUuid, strconv.Itoa(int(s.UserId)), s.Username, strconv.FormatInt(s.ExpiresIn, 10)}
+					}
+
+					return render(c, res, []string{"TOKEN", "USER ID", "USERNAME", "EXPIRES IN (S)"}, rows)
+				}),
+			},
+			{
+				Name:      "link-identity",
+				Usage:     "let an account log in with an identity provider, subject is its sub claim there",
+				ArgsUsage: "<id|username> <provider> <subject>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					provider, subject := c.Args().Get(1), c.Args().Get(2)
+					if provider == "" || subject == "" {
+						return errors.New("provider and subject are required")
+					}
+
+					externalLoginService := service.NewExternalLoginService(
+						t.userRepo,
+						repository.NewUserIdentityRepo(t.pg),
+						repository.NewAuthRepo(t.rds),
+						t.authService,
+						service.NewAuditService(repository.NewAuditRepo(t.pg)),
+					)
+					return externalLoginService.Link(c.Context, id, provider, subject)
+				}),
+			},
+			{
+				Name:      "logout-all",
+				Usage:     "revoke every session of an account",
+				ArgsUsage: "<id|username>",
+				Action: withUserTools(func(c *cli.Context, t *userTools) error {
+					id, err := t.lookup(c.Context, c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					return t.authService.LogoutAll(c.Context, id)
+				}),
+			},
+		},
+	}
+}
+
+func withUserTools(action func(*cli.Context, *userTools) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		t, err := newUserTools()
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+
+		return action(c, t)
+	}
+}
+
+func printUsers(c *cli.Context, users ...*model.UserResponse) error {
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = []string{strconv.Itoa(int(u.ID)), u.Username, u.Role, u.Email, strconv.FormatBool(u.Disabled)}
+	}
+
+	return render(c, users, []string{"ID", "USERNAME", "ROLE", "EMAIL", "DISABLED"}, rows)
+}
+
+// render writes v as indented JSON when --output json is set, otherwise the
+// rows as an aligned table.
+func render(c *cli.Context, v interface{}, header []string, rows [][]string) error {
+	switch c.String("output") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("output"))
+	}
+}
+
+func promptNewPassword() (string, string, error) {
+	password, err := readPassword("New password: ")
+	if err != nil {
+		return "", "", err
+	}
+
+	repassword, err := readPassword("Retype new password: ")
+	if err != nil {
+		return "", "", err
+	}
+
+	return password, repassword, nil
+}
+
+// readPassword prompts without echo on a terminal and falls back to reading
+// one line so passwords can be piped in scripts.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine()
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+var stdin = bufio.NewScanner(os.Stdin)
+
+func readLine() (string, error) {
+	if !stdin.Scan() {
+		if err := stdin.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no input on stdin")
+	}
+
+	return stdin.Text(), nil
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/validation"
+	"restapi/internal/web"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenHandler interface {
+	Create(c *gin.Context)
+	List(c *gin.Context)
+	Revoke(c *gin.Context)
+}
+
+type accessTokenHandler struct {
+	tokenService service.AccessTokenService
+}
+
+func NewAccessTokenHandler(tokenService service.AccessTokenService) AccessTokenHandler {
+	return &accessTokenHandler{tokenService}
+}
+
+func (h *accessTokenHandler) Create(c *gin.Context) {
+	req := model.AccessTokenCreateRequest{UserID: c.MustGet("user_id").(uint)}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	if scopes, ok := c.Get("scopes"); ok {
+		req.GrantedScopes, _ = scopes.([]string)
+	}
+
+	res, err := h.tokenService.Create(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusCreated, "create access token is success, copy the token now, it is not shown again", res)
+}
+
+func (h *accessTokenHandler) List(c *gin.Context) {
+	res, err := h.tokenService.List(c.Request.Context(), c.MustGet("user_id").(uint))
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "success get list access tokens", res)
+}
+
+func (h *accessTokenHandler) Revoke(c *gin.Context) {
+	id, err := web.GetUrlQueryInt64(c, "id")
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	err = h.tokenService.Revoke(c.Request.Context(), c.MustGet("user_id").(uint), uint(id))
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "revoke access token is success", nil)
+}
@@ -32,41 +32,41 @@ func (h *authHandler) Login(c *gin.Context) {
 	var req model.AuthRequest
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, constant.ErrRequestBody)
 		return
 	}
 
-	err = validation.Struct(req)
+	err = validation.Request(c, req)
 	if err != nil {
-		web.MarshalError(c, http.StatusConflict, err.Error(), nil)
+		web.MarshalError(c, err)
 		return
 	}
 
-	if ok, m := validation.CheckCaptchaSolver(req.ValueSolution, sessions.Default(c)); !ok {
-		web.MarshalError(c, http.StatusBadRequest, m, "captcha")
+	err = validation.CheckCaptchaSolver(req.ValueSolution, sessions.Default(c))
+	if err != nil {
+		web.MarshalError(c, err)
 		return
 	}
 
-	res, err := h.authService.Login(req)
+	res, err := h.authService.Login(c.Request.Context(), req)
 	if err != nil {
-		switch err {
-		case constant.ErrUserNameNotRegistered, constant.ErrWrongPassword:
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-		}
-
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
+	if res.PasskeyRequired {
+		web.MarshalPayload(c, http.StatusOK, "password accepted, confirm the login with a passkey", res)
+		return
+	}
+
 	web.MarshalPayload(c, http.StatusOK, "login successfully", res)
 }
 
 func (h *authHandler) Refresh(c *gin.Context) {
 	t, err := h.tk.ExtractTokenMetadata(c.Request)
 	if err != nil {
-		web.MarshalError(c, http.StatusInternalServerError, "failed to refresh token", nil)
+		web.MarshalError(c, constant.ErrUnauthenticated)
 		c.Abort()
 		return
 	}
@@ -76,10 +76,12 @@ func (h *authHandler) Refresh(c *gin.Context) {
 		Username:  t.Username,
 		UserId:    t.UserId,
 		Role:      t.Role,
+		ClientID:  t.ClientID,
+		Actor:     t.Actor,
 	}
-	res, err := h.authService.Refresh(req)
+	res, err := h.authService.Refresh(c.Request.Context(), req)
 	if err != nil {
-		web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
@@ -90,13 +92,13 @@ func (h *authHandler) Refresh(c *gin.Context) {
 func (h *authHandler) Logout(c *gin.Context) {
 	metadata, err := h.tk.ExtractTokenMetadata(c.Request)
 	if err != nil {
-		web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
+		web.MarshalError(c, constant.ErrUnauthenticated)
 		c.Abort()
 		return
 	} else if metadata != nil {
-		err = h.authService.Logout(metadata)
+		err = h.authService.Logout(c.Request.Context(), metadata)
 		if err != nil {
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
+			web.MarshalError(c, err)
 			c.Abort()
 			return
 		}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/web"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionLoginState binds an external login to the browser that started it.
+const sessionLoginState = "oidc_login_state"
+
+type ExternalLoginHandler interface {
+	Start(c *gin.Context)
+	Callback(c *gin.Context)
+}
+
+type externalLoginHandler struct {
+	externalLoginService service.ExternalLoginService
+}
+
+func NewExternalLoginHandler(externalLoginService service.ExternalLoginService) ExternalLoginHandler {
+	return &externalLoginHandler{externalLoginService}
+}
+
+// Start sends the browser to the identity provider of the path.
+func (h *externalLoginHandler) Start(c *gin.Context) {
+	var req model.ExternalLoginRequest
+	if c.ShouldBindUri(&req) != nil || c.ShouldBindQuery(&req) != nil {
+		web.MarshalError(c, constant.ErrUrlQueryParameter)
+		return
+	}
+
+	redirect, state, err := h.externalLoginService.Start(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(sessionLoginState, state)
+	err = session.Save()
+	if err != nil {
+		web.MarshalError(c, err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// Callback is the redirect uri registered at the provider. With a return_to
+// the tokens, or the error, are sent there in the fragment so they reach
+// neither the logs nor the Referer, otherwise they are answered as JSON.
+func (h *externalLoginHandler) Callback(c *gin.Context) {
+	var req model.ExternalLoginCallbackRequest
+	if c.ShouldBindUri(&req) != nil || c.ShouldBindQuery(&req) != nil {
+		web.MarshalError(c, constant.ErrUrlQueryParameter)
+		return
+	}
+
+	session := sessions.Default(c)
+	req.SessionState, _ = session.Get(sessionLoginState).(string)
+	req.IP = c.ClientIP()
+	session.Delete(sessionLoginState)
+	_ = session.Save()
+
+	res, err := h.externalLoginService.Callback(c.Request.Context(), req)
+	if res == nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	answerLogin(c, res.ReturnTo, res.AuthResponse, err)
+}
+
+// answerLogin answers the end of a browser login, in the fragment of
+// returnTo when there is one.
+func answerLogin(c *gin.Context, returnTo string, res *model.AuthResponse, err error) {
+	if returnTo == "" {
+		if err != nil {
+			web.MarshalError(c, err)
+			c.Abort()
+			return
+		}
+
+		web.MarshalPayload(c, http.StatusOK, "login successfully", res)
+		return
+	}
+
+	fragment := url.Values{}
+	if err != nil {
+		code, description := "server_error", constant.ErrServer.Message
+		if appErr, ok := err.(*constant.Error); ok && appErr != constant.ErrServer {
+			code, description = appErr.Code, appErr.Message
+		}
+		fragment.Set("error", code)
+		fragment.Set("error_description", description)
+	} else if res.PasskeyRequired {
+		// the page finishes the login at /api/login/passkey
+		fragment.Set("passkey_required", "true")
+		fragment.Set("login_token", res.LoginToken)
+	} else {
+		fragment.Set("access_token", res.AccessToken)
+		fragment.Set("refresh_token", res.RefreshToken)
+		fragment.Set("password_expired", strconv.FormatBool(res.PasswordExpired))
+	}
+
+	u, _ := url.Parse(returnTo)
+	u.Fragment = ""
+	c.Redirect(http.StatusFound, u.String()+"#"+fragment.Encode())
+}
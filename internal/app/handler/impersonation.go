@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/validation"
+	"restapi/internal/web"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImpersonationHandler interface {
+	Impersonate(c *gin.Context)
+	Stop(c *gin.Context)
+}
+
+type impersonationHandler struct {
+	impersonationService service.ImpersonationService
+}
+
+func NewImpersonationHandler(impersonationService service.ImpersonationService) ImpersonationHandler {
+	return &impersonationHandler{impersonationService}
+}
+
+func (h *impersonationHandler) Impersonate(c *gin.Context) {
+	id, err := web.GetUrlQueryInt64(c, "id")
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	req := model.ImpersonateRequest{
+		ActorID:       c.MustGet("user_id").(uint),
+		ActorUsername: c.MustGet("username").(string),
+		UserID:        uint(id),
+		IP:            c.ClientIP(),
+	}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	res, err := h.impersonationService.Impersonate(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusCreated, "impersonation token created, every request made with it is audited", res)
+}
+
+func (h *impersonationHandler) Stop(c *gin.Context) {
+	req := model.StopImpersonationRequest{
+		ActorID: c.MustGet("user_id").(uint),
+		IP:      c.ClientIP(),
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	err = h.impersonationService.Stop(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "impersonation stopped", nil)
+}
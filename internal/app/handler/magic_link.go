@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/security/oidc"
+	"restapi/internal/validation"
+	"restapi/internal/web"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionDevice is a random id of the browser, a magic link only works in
+// the browser it was requested from.
+const sessionDevice = "magic_link_device"
+
+type MagicLinkHandler interface {
+	Send(c *gin.Context)
+	Callback(c *gin.Context)
+}
+
+type magicLinkHandler struct {
+	magicLinkService service.MagicLinkService
+}
+
+func NewMagicLinkHandler(magicLinkService service.MagicLinkService) MagicLinkHandler {
+	return &magicLinkHandler{magicLinkService}
+}
+
+func (h *magicLinkHandler) Send(c *gin.Context) {
+	var req model.MagicLinkRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	session := sessions.Default(c)
+	err = validation.CheckCaptchaSolver(req.ValueSolution, session)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	device, ok := session.Get(sessionDevice).(string)
+	if !ok {
+		device = oidc.RandomString(32)
+		session.Set(sessionDevice, device)
+		err = session.Save()
+		if err != nil {
+			web.MarshalError(c, err)
+			c.Abort()
+			return
+		}
+	}
+	req.Fingerprint = fingerprint(c, device)
+	req.IP = c.ClientIP()
+
+	err = h.magicLinkService.Send(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusAccepted, "if the account can log in with a link, one was sent to its email", nil)
+}
+
+// Callback is the link of the mail, it answers like the callback of an
+// external login.
+func (h *magicLinkHandler) Callback(c *gin.Context) {
+	var req model.MagicLinkCallbackRequest
+	if c.ShouldBindQuery(&req) != nil {
+		web.MarshalError(c, constant.ErrUrlQueryParameter)
+		return
+	}
+
+	device, _ := sessions.Default(c).Get(sessionDevice).(string)
+	req.Fingerprint = fingerprint(c, device)
+	req.IP = c.ClientIP()
+
+	res, err := h.magicLinkService.Login(c.Request.Context(), req)
+	if res == nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	answerLogin(c, res.ReturnTo, res.AuthResponse, err)
+}
+
+// fingerprint combines the device id of the session with the user agent, a
+// copied session cookie alone is not enough.
+func fingerprint(c *gin.Context, device string) string {
+	sum := sha256.Sum256([]byte(device + "\x00" + c.Request.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/web"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// keys of the browser session shared by the login and consent pages
+const (
+	sessionUserID   = "oauth_user_id"
+	sessionUsername = "oauth_username"
+	sessionAuthTime = "oauth_auth_time"
+	// sessionFreshLogin is set by the login page so the max_age of the
+	// request it was shown for does not ask for a login again
+	sessionFreshLogin = "oauth_fresh_login"
+	sessionCSRF       = "oauth_csrf"
+)
+
+// scopeDescriptions are shown on the consent page.
+var scopeDescriptions = map[string]string{
+	model.ScopeUserRead:  "Read your profile and the list of users",
+	model.ScopeUserWrite: "Change users and passwords on your behalf",
+	model.ScopeOpenID:    "Know who you are",
+	model.ScopeProfile:   "Read your username and role",
+}
+
+type OAuthHandler interface {
+	Authorize(c *gin.Context)
+	Consent(c *gin.Context)
+	Login(c *gin.Context)
+	Token(c *gin.Context)
+	Revoke(c *gin.Context)
+	Introspect(c *gin.Context)
+}
+
+type oauthHandler struct {
+	oauthService   service.OAuthService
+	accountService service.ServiceAccountService
+	authService    service.AuthService
+}
+
+func NewOAuthHandler(oauthService service.OAuthService, accountService service.ServiceAccountService, authService service.AuthService) OAuthHandler {
+	return &oauthHandler{oauthService, accountService, authService}
+}
+
+// Authorize starts the authorization code flow. It shows the login page
+// without a browser session, the consent page when the user has not granted
+// the scopes yet, and redirects with a code otherwise.
+func (h *oauthHandler) Authorize(c *gin.Context) {
+	var req model.AuthorizeRequest
+	_ = c.ShouldBindQuery(&req)
+
+	client, scopes, err := h.oauthService.CheckAuthorize(c.Request.Context(), req)
+	if err != nil {
+		h.authorizeError(c, client, req, err)
+		return
+	}
+
+	session := sessions.Default(c)
+	userID, ok := session.Get(sessionUserID).(uint)
+	authTime, _ := session.Get(sessionAuthTime).(int64)
+	fresh, _ := session.Get(sessionFreshLogin).(bool)
+	if fresh {
+		session.Delete(sessionFreshLogin)
+		_ = session.Save()
+	}
+	if maxAge, set := req.MaxAgeSeconds(); set && !fresh && time.Now().Unix()-authTime > maxAge {
+		ok = false
+	}
+	if !ok {
+		loginPage(c, session, client, c.Request.URL.RawQuery, http.StatusOK, "", "")
+		return
+	}
+	req.AuthTime = authTime
+
+	needsConsent, err := h.oauthService.NeedsConsent(c.Request.Context(), userID, client.ClientID, scopes)
+	if err != nil {
+		h.authorizeError(c, client, req, err)
+		return
+	}
+
+	if !needsConsent {
+		code, err := h.oauthService.Authorize(c.Request.Context(), client, req, scopes, userID, true)
+		if err != nil {
+			h.authorizeError(c, client, req, err)
+			return
+		}
+
+		redirect(c, req, url.Values{"code": {code}})
+		return
+	}
+
+	descriptions := make([]string, len(scopes))
+	for i, scope := range scopes {
+		descriptions[i] = scopeDescriptions[scope]
+		if descriptions[i] == "" {
+			descriptions[i] = scope
+		}
+	}
+	web.MarshalHTML(c, http.StatusOK, "consent.html", gin.H{
+		"Title":     "Authorize " + client.Name,
+		"Client":    client.Name,
+		"Username":  session.Get(sessionUsername),
+		"Scopes":    descriptions,
+		"CSRFToken": csrfToken(session),
+		"Params": map[string]string{
+			"response_type":         req.ResponseType,
+			"client_id":             req.ClientID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 req.Scope,
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+			"nonce":                 req.Nonce,
+			"max_age":               req.MaxAge,
+		},
+	})
+}
+
+// Consent receives the decision posted by the consent page.
+func (h *oauthHandler) Consent(c *gin.Context) {
+	var req model.AuthorizeRequest
+	_ = c.ShouldBind(&req)
+
+	session := sessions.Default(c)
+	userID, ok := session.Get(sessionUserID).(uint)
+	if !ok || !validCSRF(session, c.PostForm("csrf_token")) {
+		web.MarshalHTMLError(c, constant.ErrForbidden)
+		return
+	}
+	req.AuthTime, _ = session.Get(sessionAuthTime).(int64)
+
+	client, scopes, err := h.oauthService.CheckAuthorize(c.Request.Context(), req)
+	if err != nil {
+		h.authorizeError(c, client, req, err)
+		return
+	}
+
+	code, err := h.oauthService.Authorize(c.Request.Context(), client, req, scopes, userID, c.PostForm("decision") == "approve")
+	if err != nil {
+		h.authorizeError(c, client, req, err)
+		return
+	}
+
+	redirect(c, req, url.Values{"code": {code}})
+}
+
+// Login receives the login page and goes back to the authorization
+// request it was shown for.
+func (h *oauthHandler) Login(c *gin.Context) {
+	session := sessions.Default(c)
+	if !validCSRF(session, c.PostForm("csrf_token")) {
+		web.MarshalHTMLError(c, constant.ErrForbidden)
+		return
+	}
+
+	query, err := url.ParseQuery(c.PostForm("query"))
+	if err != nil {
+		web.MarshalHTMLError(c, constant.ErrInvalidRequest)
+		return
+	}
+
+	// the request is checked again so the page cannot be used without a
+	// registered client
+	req := model.AuthorizeRequest{ClientID: query.Get("client_id"), RedirectURI: query.Get("redirect_uri")}
+	client, _, err := h.oauthService.CheckAuthorize(c.Request.Context(), req)
+	if client == nil {
+		web.MarshalHTMLError(c, err)
+		return
+	}
+
+	user, err := h.authService.Authenticate(c.Request.Context(), c.PostForm("username"), c.PostForm("password"))
+	if err != nil {
+		message := "Username or password is incorrect."
+		if err == constant.ErrServer {
+			message = constant.ErrServer.Message
+		}
+
+		loginPage(c, session, client, query.Encode(), http.StatusUnauthorized, c.PostForm("username"), message)
+		return
+	}
+
+	session.Set(sessionUserID, user.ID)
+	session.Set(sessionUsername, user.Username)
+	session.Set(sessionAuthTime, time.Now().Unix())
+	session.Set(sessionFreshLogin, true)
+	err = session.Save()
+	if err != nil {
+		web.MarshalHTMLError(c, err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/oauth/authorize?"+query.Encode())
+}
+
+// Token is the token endpoint, the client authenticates with HTTP Basic or
+// with client_id and client_secret in the form.
+func (h *oauthHandler) Token(c *gin.Context) {
+	var req model.OAuthTokenRequest
+	err := c.ShouldBind(&req)
+	if err != nil {
+		web.MarshalOAuthError(c, constant.ErrInvalidRequest)
+		c.Abort()
+		return
+	}
+
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+	req.IP = c.ClientIP()
+
+	var res *model.OAuthTokenResponse
+	if req.GrantType == service.GrantClientCredentials {
+		res, err = h.accountService.IssueToken(c.Request.Context(), req)
+	} else {
+		res, err = h.oauthService.Token(c.Request.Context(), req)
+	}
+	if err != nil {
+		web.MarshalOAuthError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, res)
+}
+
+// Revoke is the revocation endpoint of RFC 7009.
+func (h *oauthHandler) Revoke(c *gin.Context) {
+	req, ok := bindTokenAction(c)
+	if !ok {
+		return
+	}
+
+	err := h.oauthService.Revoke(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalOAuthError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Introspect is the introspection endpoint of RFC 7662.
+func (h *oauthHandler) Introspect(c *gin.Context) {
+	req, ok := bindTokenAction(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.oauthService.Introspect(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalOAuthError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, res)
+}
+
+// authorizeError redirects err to the client, or shows it when the client
+// or its redirect uri cannot be trusted.
+func (h *oauthHandler) authorizeError(c *gin.Context, client *model.OAuthClient, req model.AuthorizeRequest, err error) {
+	if client == nil {
+		web.MarshalHTMLError(c, err)
+		return
+	}
+
+	code, description := "server_error", constant.ErrServer.Message
+	if appErr, ok := err.(*constant.Error); ok && appErr != constant.ErrServer {
+		code, description = appErr.Code, appErr.Message
+	}
+
+	redirect(c, req, url.Values{"error": {code}, "error_description": {description}})
+}
+
+func loginPage(c *gin.Context, session sessions.Session, client *model.OAuthClient, query string, status int, username, message string) {
+	web.MarshalHTML(c, status, "login.html", gin.H{
+		"Title":     "Sign in",
+		"Client":    client.Name,
+		"CSRFToken": csrfToken(session),
+		"Query":     query,
+		"Username":  username,
+		"Error":     message,
+	})
+}
+
+// redirect sends the browser back to the client with params and the state
+// of the request.
+func redirect(c *gin.Context, req model.AuthorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		web.MarshalHTMLError(c, constant.ErrInvalidRedirectURI)
+		return
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, u.String())
+}
+
+func bindTokenAction(c *gin.Context) (model.OAuthTokenActionRequest, bool) {
+	var req model.OAuthTokenActionRequest
+	err := c.ShouldBind(&req)
+	if err != nil || req.Token == "" {
+		web.MarshalOAuthError(c, constant.ErrInvalidRequest)
+		c.Abort()
+		return req, false
+	}
+
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+	req.IP = c.ClientIP()
+
+	return req, true
+}
+
+// csrfToken returns the anti-CSRF token of the session, creating it on
+// first use.
+func csrfToken(session sessions.Session) string {
+	if token, ok := session.Get(sessionCSRF).(string); ok {
+		return token
+	}
+
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	session.Set(sessionCSRF, token)
+	_ = session.Save()
+
+	return token
+}
+
+func validCSRF(session sessions.Session, token string) bool {
+	expected, ok := session.Get(sessionCSRF).(string)
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
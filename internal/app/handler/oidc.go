@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/config"
+	"restapi/internal/security/token"
+	"restapi/internal/web"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler interface {
+	Discovery(c *gin.Context)
+	JWKS(c *gin.Context)
+	UserInfo(c *gin.Context)
+	Logout(c *gin.Context)
+}
+
+type oidcHandler struct {
+	oauthService service.OAuthService
+}
+
+func NewOIDCHandler(oauthService service.OAuthService) OIDCHandler {
+	return &oidcHandler{oauthService}
+}
+
+// Discovery serves /.well-known/openid-configuration.
+func (h *oidcHandler) Discovery(c *gin.Context) {
+	issuer := config.Cfg().OIDCIssuerURL()
+
+	c.JSON(http.StatusOK, model.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		EndSessionEndpoint:                issuer + "/oauth/logout",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ScopesSupported:                   []string{model.ScopeOpenID, model.ScopeProfile, model.ScopeUserRead, model.ScopeUserWrite},
+		ResponseTypesSupported:            []string{service.ResponseTypeCode},
+		GrantTypesSupported:               []string{service.GrantAuthorizationCode, service.GrantRefreshToken, service.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{service.PKCEMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "sid", "at_hash", "preferred_username", "roles"},
+	})
+}
+
+// JWKS serves the public key id_tokens are signed with.
+func (h *oidcHandler) JWKS(c *gin.Context) {
+	signer, err := token.CurrentSigner()
+	if err != nil {
+		web.MarshalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, signer.JWKS())
+}
+
+// UserInfo answers the claims of the user of the access token, behind the
+// authentication middleware and the openid scope.
+func (h *oidcHandler) UserInfo(c *gin.Context) {
+	var scopes []string
+	if v, ok := c.Get("scopes"); ok {
+		scopes, _ = v.([]string)
+	}
+
+	res, err := h.oauthService.UserInfo(c.Request.Context(), c.MustGet("user_id").(uint), scopes)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// Logout is the end_session_endpoint of RP-initiated logout. It always ends
+// the browser session of the login page.
+func (h *oidcHandler) Logout(c *gin.Context) {
+	var req model.LogoutRequest
+	_ = c.ShouldBind(&req)
+
+	redirect, err := h.oauthService.Logout(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalHTMLError(c, err)
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Delete(sessionUserID)
+	session.Delete(sessionUsername)
+	session.Delete(sessionAuthTime)
+	err = session.Save()
+	if err != nil {
+		web.MarshalHTMLError(c, err)
+		return
+	}
+
+	if redirect == "" {
+		web.MarshalHTML(c, http.StatusOK, "logged_out.html", gin.H{"Title": "Signed out"})
+		return
+	}
+
+	u, _ := url.Parse(redirect)
+	if req.State != "" {
+		query := u.Query()
+		query.Set("state", req.State)
+		u.RawQuery = query.Encode()
+	}
+	c.Redirect(http.StatusFound, u.String())
+}
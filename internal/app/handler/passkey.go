@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"net/http"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/validation"
+	"restapi/internal/web"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasskeyHandler interface {
+	LoginOptions(c *gin.Context)
+	Login(c *gin.Context)
+	ReauthOptions(c *gin.Context)
+	Reauth(c *gin.Context)
+	RegisterOptions(c *gin.Context)
+	Register(c *gin.Context)
+	List(c *gin.Context)
+	Delete(c *gin.Context)
+}
+
+type passkeyHandler struct {
+	passkeyService service.PasskeyService
+	authService    service.AuthService
+}
+
+func NewPasskeyHandler(passkeyService service.PasskeyService, authService service.AuthService) PasskeyHandler {
+	return &passkeyHandler{passkeyService, authService}
+}
+
+// LoginOptions answers the publicKey options of navigator.credentials.get.
+func (h *passkeyHandler) LoginOptions(c *gin.Context) {
+	var req model.PasskeyLoginOptionsRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		return
+	}
+
+	res, err := h.authService.PasskeyOptions(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "success get passkey login options", res)
+}
+
+func (h *passkeyHandler) Login(c *gin.Context) {
+	var req model.PasskeyLoginRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		return
+	}
+
+	res, err := h.authService.PasskeyLogin(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "login successfully", res)
+}
+
+// ReauthOptions answers the publicKey options of navigator.credentials.get
+// confirming the user with one of its passkeys.
+func (h *passkeyHandler) ReauthOptions(c *gin.Context) {
+	res, err := h.authService.ReauthOptions(c.Request.Context(), c.MustGet("user_id").(uint))
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "success get passkey reauth options", res)
+}
+
+func (h *passkeyHandler) Reauth(c *gin.Context) {
+	req := model.PasskeyReauthRequest{
+		UserID:   c.MustGet("user_id").(uint),
+		Username: c.MustGet("username").(string),
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	res, err := h.authService.Reauthenticate(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "reauthentication is success", res)
+}
+
+// RegisterOptions answers the publicKey options of
+// navigator.credentials.create.
+func (h *passkeyHandler) RegisterOptions(c *gin.Context) {
+	var req model.PasskeyReauthTokenRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	res, err := h.passkeyService.RegisterOptions(c.Request.Context(), c.MustGet("user_id").(uint), req.ReauthToken)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "success get passkey registration options", res)
+}
+
+func (h *passkeyHandler) Register(c *gin.Context) {
+	req := model.PasskeyRegisterRequest{UserID: c.MustGet("user_id").(uint), IP: c.ClientIP()}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	res, err := h.passkeyService.Register(c.Request.Context(), req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusCreated, "register passkey is success", res)
+}
+
+func (h *passkeyHandler) List(c *gin.Context) {
+	res, err := h.passkeyService.List(c.Request.Context(), c.MustGet("user_id").(uint))
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "success get list passkeys", res)
+}
+
+func (h *passkeyHandler) Delete(c *gin.Context) {
+	id, err := web.GetUrlQueryInt64(c, "id")
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	var req model.PasskeyReauthTokenRequest
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		web.MarshalError(c, constant.ErrRequestBody)
+		c.Abort()
+		return
+	}
+
+	err = validation.Request(c, req)
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	err = h.passkeyService.Delete(c.Request.Context(), c.MustGet("user_id").(uint), uint(id), req.ReauthToken, c.ClientIP())
+	if err != nil {
+		web.MarshalError(c, err)
+		c.Abort()
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "delete passkey is success", nil)
+}
@@ -39,31 +39,24 @@ func (h *userHandler) Create(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, constant.ErrUnauthorized.Error(), nil)
+		web.MarshalError(c, constant.ErrRequestBody)
 		c.Abort()
 		return
 	}
 
-	err = validation.Struct(req)
+	err = validation.Request(c, req)
 	if err != nil {
-		web.MarshalError(c, http.StatusConflict, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
 	req.UserRole = role
-	res, err := h.userService.Create(req)
+	res, err := h.userService.Create(c.Request.Context(), req)
 	if err != nil {
-		switch err {
-		case constant.ErrEmailRegistered:
-			web.MarshalError(c, http.StatusConflict, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "create user is successfully", res)
@@ -72,23 +65,16 @@ func (h *userHandler) Create(c *gin.Context) {
 func (h *userHandler) Get(c *gin.Context) {
 	id, err := web.GetUrlQueryInt64(c, "id")
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
-	res, err := h.userService.Get(uint(id))
+	res, err := h.userService.Get(c.Request.Context(), uint(id))
 	if err != nil {
-		switch err {
-		case constant.ErrUserNotFound:
-			web.MarshalError(c, http.StatusNotFound, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "get data is success", res)
@@ -97,19 +83,12 @@ func (h *userHandler) Get(c *gin.Context) {
 func (h *userHandler) GetByToken(c *gin.Context) {
 	id := c.MustGet("user_id").(uint)
 
-	res, err := h.userService.Get(id)
+	res, err := h.userService.Get(c.Request.Context(), id)
 	fmt.Println("error get user:", err)
 	if err != nil {
-		switch err {
-		case constant.ErrUserNotFound:
-			web.MarshalError(c, http.StatusNotFound, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "get data is success", res)
@@ -120,14 +99,14 @@ func (h *userHandler) List(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, "please check your data", nil)
+		web.MarshalError(c, constant.ErrRequestBody)
 		c.Abort()
 		return
 	}
 
-	res, err := h.userService.List(req)
+	res, err := h.userService.List(c.Request.Context(), req)
 	if err != nil {
-		web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
@@ -138,7 +117,7 @@ func (h *userHandler) List(c *gin.Context) {
 func (h *userHandler) Update(c *gin.Context) {
 	id, err := web.GetUrlQueryInt64(c, "id")
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
@@ -146,38 +125,23 @@ func (h *userHandler) Update(c *gin.Context) {
 	req := model.UserUpdateRequest{ID: uint(id)}
 	err = c.ShouldBindJSON(&req)
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, constant.ErrRequestBody)
 		c.Abort()
 		return
 	}
 
-	err = validation.Struct(req)
+	err = validation.Request(c, req)
 	if err != nil {
-		web.MarshalError(c, http.StatusConflict, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
-	res, err := h.userService.Update(req)
+	res, err := h.userService.Update(c.Request.Context(), req)
 	if err != nil {
-		switch err {
-		case constant.ErrUnauthorized:
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
-			c.Abort()
-			return
-		case constant.ErrEmailRegistered:
-			web.MarshalError(c, http.StatusConflict, err.Error(), nil)
-			c.Abort()
-			return
-		case constant.ErrUserNotFound:
-			web.MarshalError(c, http.StatusNotFound, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "update user is success", res)
@@ -186,7 +150,7 @@ func (h *userHandler) Update(c *gin.Context) {
 func (h *userHandler) UpdatePassword(c *gin.Context) {
 	id, err := web.GetUrlQueryInt64(c, "id")
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
@@ -194,34 +158,23 @@ func (h *userHandler) UpdatePassword(c *gin.Context) {
 	req := model.UserPasswordUpdateRequest{ID: uint(id)}
 	err = c.ShouldBindJSON(&req)
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, constant.ErrRequestBody)
 		c.Abort()
 		return
 	}
 
-	err = validation.Struct(req)
+	err = validation.Request(c, req)
 	if err != nil {
-		web.MarshalError(c, http.StatusConflict, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
-	res, err := h.userService.UpdatePassword(req)
+	res, err := h.userService.UpdatePassword(c.Request.Context(), req)
 	if err != nil {
-		switch err {
-		case constant.ErrUnauthorized, constant.ErrWrongPassword:
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
-			c.Abort()
-			return
-		case constant.ErrUserNotFound:
-			web.MarshalError(c, http.StatusNotFound, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "update password user is success", res)
@@ -230,27 +183,16 @@ func (h *userHandler) UpdatePassword(c *gin.Context) {
 func (h *userHandler) Delete(c *gin.Context) {
 	id, err := web.GetUrlQueryInt64(c, "id")
 	if err != nil {
-		web.MarshalError(c, http.StatusBadRequest, err.Error(), nil)
+		web.MarshalError(c, err)
 		c.Abort()
 		return
 	}
 
-	err = h.userService.Delete(uint(id))
+	err = h.userService.Delete(c.Request.Context(), uint(id))
 	if err != nil {
-		switch err {
-		case constant.ErrUnauthorized:
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
-			c.Abort()
-			return
-		case constant.ErrUserNotFound:
-			web.MarshalError(c, http.StatusNotFound, err.Error(), nil)
-			c.Abort()
-			return
-		default:
-			web.MarshalError(c, http.StatusInternalServerError, err.Error(), nil)
-			c.Abort()
-			return
-		}
+		web.MarshalError(c, err)
+		c.Abort()
+		return
 	}
 
 	web.MarshalPayload(c, http.StatusOK, "delete user is success", nil)
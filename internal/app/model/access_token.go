@@ -0,0 +1,84 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix starts every personal access token so it can be told
+// apart from a JWT.
+const AccessTokenPrefix = "rpat_"
+
+// scopes of personal access tokens, sessions have all of them
+const (
+	ScopeUserRead  = "user:read"
+	ScopeUserWrite = "user:write"
+	ScopeTokens    = "tokens"
+)
+
+// AccessToken is a personal access token. Only the SHA-256 of the token is
+// kept, LookupID is the public part used to find it.
+type AccessToken struct {
+	CreatedAt  time.Time  `gorm:"column:create_on"`
+	UpdatedAt  time.Time  `gorm:"column:change_on"`
+	ID         uint       `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	UserID     uint       `gorm:"NOT NULL;index;column:user_id"`
+	Name       string     `gorm:"type:varchar(50);NOT NULL"`
+	LookupID   string     `gorm:"type:varchar(16);NOT NULL;UNIQUE;column:lookup_id"`
+	Hash       string     `gorm:"type:varchar(64);NOT NULL"`
+	Scopes     string     `gorm:"type:varchar(255)"`
+	ExpiresAt  *time.Time `gorm:"column:expire_on"`
+	LastUsedAt *time.Time `gorm:"column:last_used_on"`
+	LastUsedIP string     `gorm:"type:varchar(45);column:last_used_ip"`
+}
+
+func (t *AccessToken) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".access_tokens"
+}
+
+func (t *AccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(t.Scopes, ",")
+}
+
+// Expired reports whether the token can no longer be used at now.
+func (t *AccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type AccessTokenCreateRequest struct {
+	UserID        uint     `json:"-"`
+	Name          string   `json:"name" validate:"required,max=50"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=user:read user:write tokens"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+	// GrantedScopes are the scopes of the caller, a token cannot get more.
+	GrantedScopes []string `json:"-"`
+}
+
+type AccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Token is only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func NewAccessTokenResponse(payload *AccessToken) *AccessTokenResponse {
+	return &AccessTokenResponse{
+		ID:         payload.ID,
+		Name:       payload.Name,
+		Scopes:     payload.ScopeList(),
+		ExpiresAt:  payload.ExpiresAt,
+		LastUsedAt: payload.LastUsedAt,
+		LastUsedIP: payload.LastUsedIP,
+		CreatedAt:  payload.CreatedAt,
+	}
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"time"
+)
+
+// audit actor types
+const (
+	ActorUser           = "user"
+	ActorServiceAccount = "service_account"
+)
+
+// AuditEvent records who did what, from where and whether it worked.
+type AuditEvent struct {
+	CreatedAt time.Time `gorm:"column:create_on;index"`
+	ID        uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	Action    string    `gorm:"type:varchar(50);NOT NULL;index"`
+	ActorType string    `gorm:"type:varchar(20);column:actor_type"`
+	ActorID   string    `gorm:"type:varchar(64);column:actor_id;index"`
+	Target    string    `gorm:"type:varchar(255)"`
+	IP        string    `gorm:"type:varchar(45);column:ip"`
+	RequestID string    `gorm:"type:varchar(128);column:request_id"`
+	Success   bool      `gorm:"column:success"`
+	Detail    string    `gorm:"type:text"`
+}
+
+func (e *AuditEvent) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".audit_events"
+}
@@ -3,7 +3,7 @@ package model
 import "github.com/gin-contrib/sessions"
 
 type AuthRequest struct {
-	Username      string           `json:"username" validate:"required,alpha,min=4,max=10"`
+	Username      string           `json:"username" validate:"required,login_username"`
 	Password      string           `json:"password" validate:"required,min=8"`
 	ValueSolution string           `json:"value_solution"`
 	ForceLogin    bool             `json:"force_login"`
@@ -13,6 +13,12 @@ type AuthRequest struct {
 type AuthResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// PasswordExpired tokens only allow changing the password.
+	PasswordExpired bool `json:"password_expired"`
+	// PasskeyRequired replaces the tokens when the user has a passkey, the
+	// login ends at /api/login/passkey with LoginToken.
+	PasskeyRequired bool   `json:"passkey_required,omitempty"`
+	LoginToken      string `json:"login_token,omitempty"`
 }
 
 type TokenDetails struct {
@@ -25,8 +31,33 @@ type TokenDetails struct {
 }
 
 type AccessDetails struct {
-	TokenUuid string
-	UserId    uint
-	Username  string
-	Role      string
+	TokenUuid       string
+	UserId          uint
+	Username        string
+	Role            string
+	PasswordExpired bool
+	// Scopes limit what an access token can do, nil for sessions which can
+	// do everything.
+	Scopes []string
+	// ClientID is set for the tokens of service accounts and OAuth clients.
+	ClientID string
+	// RefreshUuid is only set when a refresh token is parsed.
+	RefreshUuid string
+	ExpiresAt   int64
+	// Actor is the admin behind an impersonation token, nil otherwise.
+	Actor *Actor
+}
+
+// Actor is the act claim of an impersonation token, the user really making
+// the requests.
+type Actor struct {
+	UserId   uint
+	Username string
+}
+
+type SessionResponse struct {
+	TokenUuid string `json:"token_uuid"`
+	UserId    uint   `json:"user_id"`
+	Username  string `json:"username"`
+	ExpiresIn int64  `json:"expires_in"`
 }
@@ -0,0 +1,29 @@
+package model
+
+// ImpersonateRequest asks for a token acting as UserID on behalf of the
+// admin ActorID, Reason is kept in the audit trail.
+type ImpersonateRequest struct {
+	ActorID       uint   `json:"-"`
+	ActorUsername string `json:"-"`
+	UserID        uint   `json:"-"`
+	Reason        string `json:"reason" validate:"required,max=255"`
+	IP            string `json:"-"`
+}
+
+// StopImpersonationRequest asks to revoke Token, an impersonation token,
+// on behalf of the admin ActorID.
+type StopImpersonationRequest struct {
+	ActorID uint   `json:"-"`
+	Token   string `json:"token" validate:"required"`
+	IP      string `json:"-"`
+}
+
+// ImpersonationResponse carries an access token without refresh token, it
+// ends after ExpiresIn seconds.
+type ImpersonationResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	UserID      uint   `json:"user_id"`
+	Username    string `json:"username"`
+}
@@ -0,0 +1,33 @@
+package model
+
+// MagicLinkRequest asks for a login link sent to the email of the user.
+type MagicLinkRequest struct {
+	Username      string `json:"username" validate:"required,login_username"`
+	ValueSolution string `json:"value_solution"`
+	// ReturnTo is the page of an allowed origin the tokens are sent to in
+	// the fragment, without it the link answers with JSON.
+	ReturnTo   string `json:"return_to"`
+	ForceLogin bool   `json:"force_login"`
+	// Fingerprint identifies the browser asking, only it can use the link.
+	Fingerprint string `json:"-"`
+	IP          string `json:"-"`
+}
+
+// MagicLinkCallbackRequest is the link opened from the mail.
+type MagicLinkCallbackRequest struct {
+	Token       string `form:"token"`
+	Fingerprint string `form:"-"`
+	IP          string `form:"-"`
+}
+
+// MagicLink is kept in Redis until the link is used or expires.
+type MagicLink struct {
+	UserID     uint   `json:"user_id"`
+	ReturnTo   string `json:"return_to"`
+	ForceLogin bool   `json:"force_login"`
+}
+
+type MagicLinkResponse struct {
+	*AuthResponse
+	ReturnTo string `json:"-"`
+}
@@ -0,0 +1,257 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenID Connect scopes, only granted to OAuth clients
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// OAuthClient is an application using the authorization code grant. Public
+// clients, SPAs and mobile apps, have no secret and rely on PKCE alone.
+type OAuthClient struct {
+	CreatedAt    time.Time `gorm:"column:create_on"`
+	UpdatedAt    time.Time `gorm:"column:change_on"`
+	ID           uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	Name         string    `gorm:"type:varchar(50);NOT NULL"`
+	ClientID     string    `gorm:"type:varchar(64);NOT NULL;UNIQUE;column:client_id"`
+	SecretHash   string    `gorm:"type:varchar(64);column:secret_hash"`
+	RedirectURIs string    `gorm:"type:text;NOT NULL;column:redirect_uris"`
+	// PostLogoutRedirectURIs are where RP-initiated logout may send the
+	// browser back to.
+	PostLogoutRedirectURIs string `gorm:"type:text;column:post_logout_redirect_uris"`
+	Scopes                 string `gorm:"type:varchar(255)"`
+	IsPublic               bool   `gorm:"column:is_public"`
+}
+
+func (c *OAuthClient) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".oauth_clients"
+}
+
+func (c *OAuthClient) ScopeList() []string {
+	if c.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(c.Scopes, ",")
+}
+
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c *OAuthClient) PostLogoutRedirectURIList() []string {
+	return strings.Fields(c.PostLogoutRedirectURIs)
+}
+
+// AllowsRedirect reports whether uri is registered, redirect URIs are
+// compared exactly.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	return containsURI(c.RedirectURIList(), uri)
+}
+
+func (c *OAuthClient) AllowsPostLogoutRedirect(uri string) bool {
+	return containsURI(c.PostLogoutRedirectURIList(), uri)
+}
+
+func containsURI(registered []string, uri string) bool {
+	for _, r := range registered {
+		if r == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OAuthConsent records the scopes a user granted to a client so the consent
+// page is only shown again when more scopes are requested.
+type OAuthConsent struct {
+	CreatedAt time.Time `gorm:"column:create_on"`
+	UpdatedAt time.Time `gorm:"column:change_on"`
+	ID        uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	UserID    uint      `gorm:"NOT NULL;uniqueIndex:idx_oauth_consent;column:user_id"`
+	ClientID  string    `gorm:"type:varchar(64);NOT NULL;uniqueIndex:idx_oauth_consent;column:client_id"`
+	Scopes    string    `gorm:"type:varchar(255)"`
+}
+
+func (c *OAuthConsent) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".oauth_consents"
+}
+
+func (c *OAuthConsent) ScopeList() []string {
+	if c.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(c.Scopes, ",")
+}
+
+type OAuthClientCreateRequest struct {
+	Name                   string   `json:"name" validate:"required,max=50"`
+	RedirectURIs           []string `json:"redirect_uris" validate:"required,min=1,dive,url"`
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris" validate:"dive,url"`
+	Scopes                 []string `json:"scopes" validate:"required,min=1,dive,oneof=user:read user:write openid profile"`
+	Public                 bool     `json:"public"`
+}
+
+type OAuthClientResponse struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	ClientID     string    `json:"client_id"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+	// ClientSecret is only returned when the secret is generated.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func NewOAuthClientResponse(payload *OAuthClient) *OAuthClientResponse {
+	return &OAuthClientResponse{
+		ID:           payload.ID,
+		Name:         payload.Name,
+		ClientID:     payload.ClientID,
+		RedirectURIs: payload.RedirectURIList(),
+		Scopes:       payload.ScopeList(),
+		Public:       payload.IsPublic,
+		CreatedAt:    payload.CreatedAt,
+
+		PostLogoutRedirectURIs: payload.PostLogoutRedirectURIList(),
+	}
+}
+
+// AuthorizeRequest is an authorization request, RFC 6749 section 4.1.1
+// with the PKCE parameters of RFC 7636 and the nonce and max_age of OpenID
+// Connect.
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+	MaxAge              string `form:"max_age"`
+	// AuthTime is when the user logged in, in unix seconds.
+	AuthTime int64 `form:"-"`
+}
+
+// MaxAgeSeconds returns max_age, ok is false when it is missing or not a
+// number of seconds.
+func (r AuthorizeRequest) MaxAgeSeconds() (int64, bool) {
+	maxAge, err := strconv.ParseInt(r.MaxAge, 10, 64)
+	if err != nil || maxAge < 0 {
+		return 0, false
+	}
+
+	return maxAge, true
+}
+
+// AuthorizationCode is what a code stands for until it is exchanged, it is
+// kept in Redis.
+type AuthorizationCode struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	UserID              uint   `json:"user_id"`
+	Username            string `json:"username"`
+	Role                string `json:"user_role"`
+	Scope               string `json:"scope"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Nonce               string `json:"nonce,omitempty"`
+	AuthTime            int64  `json:"auth_time"`
+}
+
+// OAuthTokenRequest is a token request of any grant, RFC 6749 sections
+// 4.1.3, 4.4.2 and 6, with the PKCE code_verifier.
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Scope        string `form:"scope"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	IP           string `form:"-"`
+}
+
+// OAuthTokenResponse is the token response of RFC 6749 section 5.1.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken is returned when the openid scope is granted.
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// OAuthTokenActionRequest is a revocation, RFC 7009, or introspection,
+// RFC 7662, request.
+type OAuthTokenActionRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+	IP            string `form:"-"`
+}
+
+// IntrospectionResponse is the response of RFC 7662 section 2.2, only
+// Active is set for inactive tokens.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
+
+// UserInfoResponse carries the standard claims of a user, the profile
+// claims are only set with the profile scope.
+type UserInfoResponse struct {
+	Sub               string   `json:"sub"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+}
+
+// LogoutRequest is an RP-initiated logout request of OpenID Connect.
+type LogoutRequest struct {
+	IDTokenHint           string `form:"id_token_hint"`
+	ClientID              string `form:"client_id"`
+	PostLogoutRedirectURI string `form:"post_logout_redirect_uri"`
+	State                 string `form:"state"`
+}
+
+// OpenIDConfiguration is the discovery document of OpenID Connect
+// Discovery section 3.
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"time"
+)
+
+// PasswordHistory keeps the hash of every password a user had, so the
+// last PASSWORD_HISTORY of them cannot be reused.
+type PasswordHistory struct {
+	CreatedAt time.Time `gorm:"column:create_on"`
+	ID        uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	UserID    uint      `gorm:"NOT NULL;index;column:user_id"`
+	Password  string    `gorm:"type:varchar(255);NOT NULL"`
+}
+
+func (h *PasswordHistory) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".password_history"
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"time"
+)
+
+type Role struct {
+	CreatedAt   time.Time `gorm:"column:create_on"`
+	UpdatedAt   time.Time `gorm:"column:change_on"`
+	ID          uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	Name        string    `gorm:"type:varchar(5);NOT NULL;UNIQUE"`
+	Description string    `gorm:"type:varchar(255)"`
+}
+
+func (r *Role) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".roles"
+}
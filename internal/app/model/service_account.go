@@ -0,0 +1,64 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"strings"
+	"time"
+)
+
+// ServiceAccount is a machine client using the client_credentials grant.
+// Only the SHA-256 of its secret is kept. Its tokens carry no user, so it
+// cannot have the tokens scope of the routes acting on a user's account.
+type ServiceAccount struct {
+	CreatedAt  time.Time `gorm:"column:create_on"`
+	UpdatedAt  time.Time `gorm:"column:change_on"`
+	ID         uint      `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	Name       string    `gorm:"type:varchar(50);NOT NULL;UNIQUE"`
+	ClientID   string    `gorm:"type:varchar(64);NOT NULL;UNIQUE;column:client_id"`
+	SecretHash string    `gorm:"type:varchar(64);NOT NULL;column:secret_hash"`
+	Role       string    `gorm:"type:varchar(5)"`
+	Scopes     string    `gorm:"type:varchar(255)"`
+	IsDisabled bool      `gorm:"column:is_disabled"`
+}
+
+func (s *ServiceAccount) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".service_accounts"
+}
+
+func (s *ServiceAccount) ScopeList() []string {
+	if s.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(s.Scopes, ",")
+}
+
+type ServiceAccountCreateRequest struct {
+	Name   string   `json:"name" validate:"required,max=50"`
+	Role   string   `json:"role" validate:"required,alpha,max=5"`
+	Scopes []string `json:"scopes" validate:"dive,oneof=user:read user:write"`
+}
+
+type ServiceAccountResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	ClientID  string    `json:"client_id"`
+	Role      string    `json:"role"`
+	Scopes    []string  `json:"scopes"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	// ClientSecret is only returned when the secret is generated.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func NewServiceAccountResponse(payload *ServiceAccount) *ServiceAccountResponse {
+	return &ServiceAccountResponse{
+		ID:        payload.ID,
+		Name:      payload.Name,
+		ClientID:  payload.ClientID,
+		Role:      payload.Role,
+		Scopes:    payload.ScopeList(),
+		Disabled:  payload.IsDisabled,
+		CreatedAt: payload.CreatedAt,
+	}
+}
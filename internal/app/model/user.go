@@ -8,16 +8,35 @@ import (
 )
 
 type User struct {
-	CreatedAt time.Time      `gorm:"column:create_on"`
-	UpdatedAt time.Time      `gorm:"column:change_on"`
-	DeletedAt gorm.DeletedAt `gorm:"index"`
-	No        int64          `json:"no" datatable:"-" gorm:"-"`
-	ID        uint           `gorm:"primaryKey;index;NOT NULL;column:id;autoIncrement"`
-	Username  string         `gorm:"type:varchar(20);NOT NULL;UNIQUE;index"`
-	Password  string         `gorm:"type:varchar(255)"`
-	Role      string         `gorm:"type:varchar(5)"`
-	IsLogin   bool           `gorm:"column:is_login"`
-	TokenUuid string         `gorm:"column:token_uuid"`
+	CreatedAt  time.Time      `gorm:"column:create_on"`
+	UpdatedAt  time.Time      `gorm:"column:change_on"`
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	No         int64          `json:"no" datatable:"-" gorm:"-"`
+	ID         uint           `gorm:"primaryKey;index;NOT NULL;column:id;autoIncrement"`
+	Username   string         `gorm:"type:varchar(20);NOT NULL;UNIQUE;index"`
+	Password   string         `gorm:"type:varchar(255)"`
+	Role       string         `gorm:"type:varchar(5)"`
+	IsLogin    bool           `gorm:"column:is_login"`
+	TokenUuid  string         `gorm:"column:token_uuid"`
+	IsDisabled bool           `json:"is_disabled" gorm:"column:is_disabled"`
+	// PasswordChangedAt is nil for accounts created before it was tracked,
+	// their password age counts from CreatedAt.
+	PasswordChangedAt *time.Time `json:"password_change_on" gorm:"column:password_change_on"`
+	// Email receives the magic links of the user. It is synced from the
+	// directory for LDAP users, the admins set the unique one of the others.
+	Email string `json:"email" gorm:"type:varchar(255);column:email"`
+	// AuthSource is the backend checking the password, local or ldap. An
+	// empty one tries AUTH_BACKENDS in order.
+	AuthSource string `json:"auth_source" gorm:"type:varchar(20);column:auth_source"`
+}
+
+// PasswordAge returns when the password was last set.
+func (u *User) PasswordAge() time.Time {
+	if u.PasswordChangedAt != nil {
+		return *u.PasswordChangedAt
+	}
+
+	return u.CreatedAt
 }
 
 func (u *User) TableName() string {
@@ -26,9 +45,10 @@ func (u *User) TableName() string {
 }
 
 type UserCreateRequest struct {
-	Username   string `json:"username" validate:"required,alpha,min=4,max=10"`
-	Password   string `json:"password" validate:"required,min=8"`
-	RePassword string `json:"repassword" validate:"required,max=20,min=8,eqfield=Password"`
+	Username   string `json:"username" validate:"required,username"`
+	Password   string `json:"password" validate:"required,password"`
+	RePassword string `json:"repassword" validate:"required,eqfield=Password"`
+	Email      string `json:"email" validate:"omitempty,email,max=255"`
 	UserRole   string
 }
 
@@ -38,7 +58,8 @@ type UserGetRequest struct {
 
 type UserUpdateRequest struct {
 	ID                uint   `json:"-"`
-	Username          string `json:"username" validate:"required,alpha,min=4,max=10"`
+	Username          string `json:"username" validate:"required,username"`
+	Email             string `json:"email" validate:"omitempty,email,max=255"`
 	LoketID           string `json:"loket_id"`
 	LoketPembayaranID string `json:"loket_pembayaran_id"`
 	IsLogin           bool   `json:"is_login"`
@@ -47,8 +68,26 @@ type UserUpdateRequest struct {
 type UserPasswordUpdateRequest struct {
 	ID            uint   `json:"-"`
 	OldPassword   string `json:"old_password" validate:"required,min=8"`
-	NewPassword   string `json:"new_password" validate:"required,min=8"`
-	ReNewPassword string `json:"renew_password" validate:"required,max=20,min=8,eqfield=NewPassword"`
+	NewPassword   string `json:"new_password" validate:"required,password"`
+	ReNewPassword string `json:"renew_password" validate:"required,eqfield=NewPassword"`
+}
+
+type UserPasswordResetRequest struct {
+	ID            uint   `json:"-"`
+	NewPassword   string `json:"new_password" validate:"required,password"`
+	ReNewPassword string `json:"renew_password" validate:"required,eqfield=NewPassword"`
+}
+
+type UserRoleUpdateRequest struct {
+	ID   uint   `json:"-"`
+	Role string `json:"role" validate:"required,alpha,max=5"`
+}
+
+// UserAuthSourceUpdateRequest binds a user to a password backend, an empty
+// source tries AUTH_BACKENDS in order.
+type UserAuthSourceUpdateRequest struct {
+	ID         uint   `json:"-"`
+	AuthSource string `json:"auth_source" validate:"omitempty,oneof=local ldap"`
 }
 
 type UserDeleteRequest struct {
@@ -60,6 +99,8 @@ type UserResponse struct {
 	ID       uint   `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"roles"`
+	Email    string `json:"email,omitempty" datatable:"-"`
+	Disabled bool   `json:"disabled" datatable:"-"`
 }
 
 type UserList struct {
@@ -73,6 +114,8 @@ func NewUserResponse(payload *User) *UserResponse {
 		ID:       payload.ID,
 		Username: payload.Username,
 		Role:     payload.Role,
+		Email:    payload.Email,
+		Disabled: payload.IsDisabled,
 	}
 }
 
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"time"
+)
+
+// UserIdentity links a user to its account at an upstream identity
+// provider, the subject is unique per provider.
+type UserIdentity struct {
+	CreatedAt   time.Time  `gorm:"column:create_on"`
+	UpdatedAt   time.Time  `gorm:"column:change_on"`
+	ID          uint       `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	UserID      uint       `gorm:"NOT NULL;index;column:user_id"`
+	Provider    string     `gorm:"type:varchar(50);NOT NULL;uniqueIndex:idx_user_identity;column:provider"`
+	Subject     string     `gorm:"type:varchar(255);NOT NULL;uniqueIndex:idx_user_identity;column:subject"`
+	Email       string     `gorm:"type:varchar(255);column:email"`
+	LastLoginAt *time.Time `gorm:"column:last_login_on"`
+}
+
+func (i *UserIdentity) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".user_identities"
+}
+
+// ExternalLoginRequest starts a login at the provider of the path.
+type ExternalLoginRequest struct {
+	Provider string `uri:"provider"`
+	// ReturnTo is the page of an allowed origin the tokens are sent to in
+	// the fragment, without it the callback answers with JSON.
+	ReturnTo   string `form:"return_to"`
+	ForceLogin bool   `form:"force_login"`
+}
+
+// ExternalLoginCallbackRequest is the redirect of the provider back to us.
+type ExternalLoginCallbackRequest struct {
+	Provider         string `uri:"provider"`
+	Code             string `form:"code"`
+	State            string `form:"state"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+	// SessionState is the state saved in the browser session when the login
+	// started, so a callback cannot be replayed in another browser.
+	SessionState string `form:"-"`
+	IP           string `form:"-"`
+}
+
+// ExternalLoginState is kept in Redis between the redirect to the provider
+// and its callback.
+type ExternalLoginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	ReturnTo     string `json:"return_to"`
+	ForceLogin   bool   `json:"force_login"`
+}
+
+type ExternalLoginResponse struct {
+	*AuthResponse
+	ReturnTo string `json:"-"`
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"restapi/internal/config"
+	"restapi/internal/security/webauthn"
+	"time"
+)
+
+// WebAuthnCredential is a passkey of a user. CredentialID is the base64url
+// id chosen by the authenticator, PublicKey its COSE_Key.
+type WebAuthnCredential struct {
+	CreatedAt    time.Time  `gorm:"column:create_on"`
+	UpdatedAt    time.Time  `gorm:"column:change_on"`
+	ID           uint       `gorm:"primaryKey;NOT NULL;column:id;autoIncrement"`
+	UserID       uint       `gorm:"NOT NULL;index;column:user_id"`
+	Name         string     `gorm:"type:varchar(50);NOT NULL"`
+	CredentialID string     `gorm:"type:varchar(1400);NOT NULL;UNIQUE;column:credential_id"`
+	PublicKey    []byte     `gorm:"type:bytea;NOT NULL;column:public_key"`
+	SignCount    int64      `gorm:"NOT NULL;default:0;column:sign_count"`
+	AAGUID       string     `gorm:"type:varchar(32);column:aaguid"`
+	Transports   string     `gorm:"type:varchar(255)"`
+	Discoverable bool       `gorm:"column:discoverable"`
+	BackedUp     bool       `gorm:"column:backed_up"`
+	LastUsedAt   *time.Time `gorm:"column:last_used_on"`
+}
+
+func (c *WebAuthnCredential) TableName() string {
+	return config.Cfg().DatabaseSchemaUser + ".webauthn_credentials"
+}
+
+// webauthn ceremonies of a WebAuthnChallenge
+const (
+	CeremonyRegister = "register"
+	CeremonyLogin    = "login"
+	CeremonyReauth   = "reauth"
+)
+
+// WebAuthnChallenge is kept in Redis under its challenge until the
+// ceremony answering it ends.
+type WebAuthnChallenge struct {
+	Ceremony string `json:"ceremony"`
+	// UserID is 0 for a login with a discoverable credential.
+	UserID uint `json:"user_id"`
+	// LoginToken is the pending password login a second factor completes.
+	LoginToken string `json:"login_token,omitempty"`
+}
+
+// PendingLogin is a password login waiting for its passkey.
+type PendingLogin struct {
+	UserID          uint `json:"user_id"`
+	ForceLogin      bool `json:"force_login"`
+	PasswordExpired bool `json:"password_expired"`
+}
+
+// PasskeyReauthRequest confirms the user of a session with its Password or,
+// for users with a passkey, with the Credential answering ReauthOptions.
+type PasskeyReauthRequest struct {
+	UserID     uint                        `json:"-"`
+	Username   string                      `json:"-"`
+	Password   string                      `json:"password"`
+	Credential *webauthn.AssertionResponse `json:"credential"`
+}
+
+// PasskeyReauthResponse carries the single use token enrolling or deleting
+// a passkey asks for.
+type PasskeyReauthResponse struct {
+	ReauthToken string `json:"reauth_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// PasskeyReauthTokenRequest is the body of the requests changing the
+// passkeys of a user.
+type PasskeyReauthTokenRequest struct {
+	ReauthToken string `json:"reauth_token" validate:"required"`
+}
+
+type PasskeyRegisterRequest struct {
+	UserID     uint                         `json:"-"`
+	Name       string                       `json:"name" validate:"required,max=50"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+	IP         string                       `json:"-"`
+}
+
+// PasskeyLoginOptionsRequest starts a passkey login: with LoginToken as the
+// second factor of a password login, with Username for the passkeys of
+// that user, with neither for a discoverable passkey.
+type PasskeyLoginOptionsRequest struct {
+	Username   string `json:"username" validate:"omitempty,login_username"`
+	LoginToken string `json:"login_token"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential"`
+	ForceLogin bool                       `json:"force_login"`
+}
+
+type PasskeyResponse struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Discoverable bool       `json:"discoverable"`
+	BackedUp     bool       `json:"backed_up"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func NewPasskeyResponse(payload *WebAuthnCredential) *PasskeyResponse {
+	return &PasskeyResponse{
+		ID:           payload.ID,
+		Name:         payload.Name,
+		Discoverable: payload.Discoverable,
+		BackedUp:     payload.BackedUp,
+		LastUsedAt:   payload.LastUsedAt,
+		CreatedAt:    payload.CreatedAt,
+	}
+}
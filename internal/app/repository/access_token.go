@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AccessTokenRepo interface {
+	Create(ctx context.Context, token *model.AccessToken) error
+	GetByLookupID(ctx context.Context, lookupID string) (*model.AccessToken, error)
+	ListByUser(ctx context.Context, userID uint) ([]*model.AccessToken, error)
+	Delete(ctx context.Context, userID, id uint) error
+	Touch(ctx context.Context, id uint, at time.Time, ip string) error
+}
+
+type accessTokenRepo struct {
+	pg postgres.Client
+}
+
+func NewAccessTokenRepo(pg postgres.Client) AccessTokenRepo {
+	return &accessTokenRepo{pg}
+}
+
+func (r *accessTokenRepo) Create(ctx context.Context, token *model.AccessToken) error {
+	return r.pg.Conn().WithContext(ctx).Create(token).Error
+}
+
+func (r *accessTokenRepo) GetByLookupID(ctx context.Context, lookupID string) (*model.AccessToken, error) {
+	token := new(model.AccessToken)
+	err := r.pg.Conn().WithContext(ctx).Where("lookup_id = ?", lookupID).First(token).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (r *accessTokenRepo) ListByUser(ctx context.Context, userID uint) ([]*model.AccessToken, error) {
+	tokens := make([]*model.AccessToken, 0)
+	err := r.pg.Conn().WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete returns gorm.ErrRecordNotFound when the user has no such token.
+func (r *accessTokenRepo) Delete(ctx context.Context, userID, id uint) error {
+	res := r.pg.Conn().WithContext(ctx).Where("user_id = ?", userID).Delete(&model.AccessToken{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *accessTokenRepo) Touch(ctx context.Context, id uint, at time.Time, ip string) error {
+	return r.pg.Conn().WithContext(ctx).Model(&model.AccessToken{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_on": at,
+			"last_used_ip": ip,
+		}).Error
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+)
+
+type AuditRepo interface {
+	Create(ctx context.Context, event *model.AuditEvent) error
+}
+
+type auditRepo struct {
+	pg postgres.Client
+}
+
+func NewAuditRepo(pg postgres.Client) AuditRepo {
+	return &auditRepo{pg}
+}
+
+func (r *auditRepo) Create(ctx context.Context, event *model.AuditEvent) error {
+	return r.pg.Conn().WithContext(ctx).Create(event).Error
+}
@@ -8,13 +8,43 @@ import (
 	"restapi/internal/app/model"
 	"restapi/internal/db/redis"
 	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	codePrefix  = "oauth:code:"
+	statePrefix = "oidc:state:"
+	// a magic link is stored under the hash of its token and the fingerprint
+	// of the browser it was requested in, the user key holds its last link
+	magicLinkPrefix     = "magic:link:"
+	magicLinkUserPrefix = "magic:user:"
+	magicLinkSentPrefix = "magic:sent:"
+	challengePrefix     = "webauthn:challenge:"
+	pendingLoginPrefix  = "webauthn:login:"
+	reauthPrefix        = "webauthn:reauth:"
 )
 
 type AuthRepo interface {
-	CreateAuth(map[string]interface{}, *model.TokenDetails) error
-	FetchAuth(tokenUuid string) (map[string]interface{}, error)
-	DeleteRefresh(string) error
-	DeleteTokens(*model.AccessDetails) error
+	CreateAuth(context.Context, map[string]interface{}, *model.TokenDetails) error
+	FetchAuth(ctx context.Context, tokenUuid string) (map[string]interface{}, error)
+	DeleteRefresh(context.Context, string) error
+	DeleteTokens(context.Context, *model.AccessDetails) error
+	TTL(ctx context.Context, tokenUuid string) (time.Duration, error)
+	CreateCode(ctx context.Context, code string, data *model.AuthorizationCode, ttl time.Duration) error
+	TakeCode(ctx context.Context, code string) (*model.AuthorizationCode, error)
+	CreateState(ctx context.Context, state string, data *model.ExternalLoginState, ttl time.Duration) error
+	TakeState(ctx context.Context, state string) (*model.ExternalLoginState, error)
+	CreateMagicLink(ctx context.Context, key string, data *model.MagicLink, ttl time.Duration) error
+	TakeMagicLink(ctx context.Context, key string) (*model.MagicLink, error)
+	MarkMagicLinkSent(ctx context.Context, userID uint, interval time.Duration) (bool, error)
+	CreateChallenge(ctx context.Context, challenge string, data *model.WebAuthnChallenge, ttl time.Duration) error
+	TakeChallenge(ctx context.Context, challenge string) (*model.WebAuthnChallenge, error)
+	CreatePendingLogin(ctx context.Context, loginToken string, data *model.PendingLogin, ttl time.Duration) error
+	GetPendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error)
+	TakePendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error)
+	CreateReauth(ctx context.Context, reauthToken string, userID uint, ttl time.Duration) error
+	TakeReauth(ctx context.Context, reauthToken string) (uint, error)
 }
 
 type authRepo struct {
@@ -25,19 +55,24 @@ func NewAuthRepo(redisClient redis.Client) AuthRepo {
 	return &authRepo{redisClient}
 }
 
-func (r *authRepo) CreateAuth(authD map[string]interface{}, td *model.TokenDetails) error {
+// CreateAuth stores the access token of td and its refresh token, when td
+// has one.
+func (r *authRepo) CreateAuth(ctx context.Context, authD map[string]interface{}, td *model.TokenDetails) error {
 	at := time.Unix(td.AtExpires, 0)
 	rt := time.Unix(td.RtExpires, 0)
 	now := time.Now()
 
 	b, _ := json.Marshal(authD)
-	atCreated, err := r.redisClient.Conn().Set(context.Background(), td.TokenUuid, b, at.Sub(now)).Result()
+	atCreated, err := r.redisClient.Conn().Set(ctx, td.TokenUuid, b, at.Sub(now)).Result()
 	if err != nil {
 		return err
 	}
-	rtCreated, err := r.redisClient.Conn().Set(context.Background(), td.RefreshUuid, b, rt.Sub(now)).Result()
-	if err != nil {
-		return err
+	rtCreated := "OK"
+	if td.RefreshUuid != "" {
+		rtCreated, err = r.redisClient.Conn().Set(ctx, td.RefreshUuid, b, rt.Sub(now)).Result()
+		if err != nil {
+			return err
+		}
 	}
 
 	if atCreated == "0" || rtCreated == "0" {
@@ -46,8 +81,8 @@ func (r *authRepo) CreateAuth(authD map[string]interface{}, td *model.TokenDetai
 	return nil
 }
 
-func (r *authRepo) FetchAuth(tokenUuid string) (map[string]interface{}, error) {
-	authD, err := r.redisClient.Conn().Get(context.Background(), tokenUuid).Result()
+func (r *authRepo) FetchAuth(ctx context.Context, tokenUuid string) (map[string]interface{}, error) {
+	authD, err := r.redisClient.Conn().Get(ctx, tokenUuid).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -59,15 +94,15 @@ func (r *authRepo) FetchAuth(tokenUuid string) (map[string]interface{}, error) {
 	return data, nil
 }
 
-func (r *authRepo) DeleteTokens(authD *model.AccessDetails) error {
+func (r *authRepo) DeleteTokens(ctx context.Context, authD *model.AccessDetails) error {
 	refreshUuid := fmt.Sprintf("%s++%v%s", authD.TokenUuid, authD.UserId, authD.Username)
 	//delete access token
-	_, err := r.redisClient.Conn().Del(context.Background(), authD.TokenUuid).Result()
+	_, err := r.redisClient.Conn().Del(ctx, authD.TokenUuid).Result()
 	if err != nil {
 		return err
 	}
 
-	_, err = r.redisClient.Conn().Del(context.Background(), refreshUuid).Result()
+	_, err = r.redisClient.Conn().Del(ctx, refreshUuid).Result()
 	if err != nil {
 		return err
 	}
@@ -75,10 +110,170 @@ func (r *authRepo) DeleteTokens(authD *model.AccessDetails) error {
 	return nil
 }
 
-func (r *authRepo) DeleteRefresh(refreshUuid string) error {
-	deleted, err := r.redisClient.Conn().Del(context.Background(), refreshUuid).Result()
+func (r *authRepo) DeleteRefresh(ctx context.Context, refreshUuid string) error {
+	deleted, err := r.redisClient.Conn().Del(ctx, refreshUuid).Result()
 	if err != nil || deleted == 0 {
 		return err
 	}
 	return nil
 }
+
+func (r *authRepo) TTL(ctx context.Context, tokenUuid string) (time.Duration, error) {
+	return r.redisClient.Conn().TTL(ctx, tokenUuid).Result()
+}
+
+func (r *authRepo) CreateCode(ctx context.Context, code string, data *model.AuthorizationCode, ttl time.Duration) error {
+	b, _ := json.Marshal(data)
+	return r.redisClient.Conn().Set(ctx, codePrefix+code, b, ttl).Err()
+}
+
+// TakeCode returns the data of code and deletes it in the same
+// transaction, a code can only be exchanged once.
+func (r *authRepo) TakeCode(ctx context.Context, code string) (*model.AuthorizationCode, error) {
+	data := new(model.AuthorizationCode)
+	err := r.take(ctx, codePrefix+code, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *authRepo) CreateState(ctx context.Context, state string, data *model.ExternalLoginState, ttl time.Duration) error {
+	b, _ := json.Marshal(data)
+	return r.redisClient.Conn().Set(ctx, statePrefix+state, b, ttl).Err()
+}
+
+// TakeState returns the login started with state, a callback can only be
+// used once.
+func (r *authRepo) TakeState(ctx context.Context, state string) (*model.ExternalLoginState, error) {
+	data := new(model.ExternalLoginState)
+	err := r.take(ctx, statePrefix+state, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// CreateMagicLink stores the link of a user and deletes its previous one,
+// only the last link sent works.
+func (r *authRepo) CreateMagicLink(ctx context.Context, key string, data *model.MagicLink, ttl time.Duration) error {
+	userKey := fmt.Sprintf("%s%d", magicLinkUserPrefix, data.UserID)
+	previous, err := r.redisClient.Conn().Get(ctx, userKey).Result()
+	if err != nil && err != goredis.Nil {
+		return err
+	}
+
+	b, _ := json.Marshal(data)
+	_, err = r.redisClient.Conn().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		if previous != "" {
+			pipe.Del(ctx, magicLinkPrefix+previous)
+		}
+		pipe.Set(ctx, magicLinkPrefix+key, b, ttl)
+		pipe.Set(ctx, userKey, key, ttl)
+		return nil
+	})
+
+	return err
+}
+
+// TakeMagicLink returns the link of key and deletes it, a link can only be
+// used once.
+func (r *authRepo) TakeMagicLink(ctx context.Context, key string) (*model.MagicLink, error) {
+	data := new(model.MagicLink)
+	err := r.take(ctx, magicLinkPrefix+key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.redisClient.Conn().Del(ctx, fmt.Sprintf("%s%d", magicLinkUserPrefix, data.UserID))
+	return data, nil
+}
+
+// MarkMagicLinkSent reports false when a link was already sent to the user
+// within interval.
+func (r *authRepo) MarkMagicLinkSent(ctx context.Context, userID uint, interval time.Duration) (bool, error) {
+	return r.redisClient.Conn().SetNX(ctx, fmt.Sprintf("%s%d", magicLinkSentPrefix, userID), 1, interval).Result()
+}
+
+func (r *authRepo) CreateChallenge(ctx context.Context, challenge string, data *model.WebAuthnChallenge, ttl time.Duration) error {
+	b, _ := json.Marshal(data)
+	return r.redisClient.Conn().Set(ctx, challengePrefix+challenge, b, ttl).Err()
+}
+
+// TakeChallenge returns the ceremony of challenge, a challenge can only be
+// answered once.
+func (r *authRepo) TakeChallenge(ctx context.Context, challenge string) (*model.WebAuthnChallenge, error) {
+	data := new(model.WebAuthnChallenge)
+	err := r.take(ctx, challengePrefix+challenge, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *authRepo) CreatePendingLogin(ctx context.Context, loginToken string, data *model.PendingLogin, ttl time.Duration) error {
+	b, _ := json.Marshal(data)
+	return r.redisClient.Conn().Set(ctx, pendingLoginPrefix+loginToken, b, ttl).Err()
+}
+
+// GetPendingLogin returns the login of loginToken without ending it, the
+// passkey may fail and be tried again.
+func (r *authRepo) GetPendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error) {
+	b, err := r.redisClient.Conn().Get(ctx, pendingLoginPrefix+loginToken).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	data := new(model.PendingLogin)
+	err = json.Unmarshal(b, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *authRepo) TakePendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error) {
+	data := new(model.PendingLogin)
+	err := r.take(ctx, pendingLoginPrefix+loginToken, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// CreateReauth stores the user a reauth token was given to.
+func (r *authRepo) CreateReauth(ctx context.Context, reauthToken string, userID uint, ttl time.Duration) error {
+	b, _ := json.Marshal(userID)
+	return r.redisClient.Conn().Set(ctx, reauthPrefix+reauthToken, b, ttl).Err()
+}
+
+func (r *authRepo) TakeReauth(ctx context.Context, reauthToken string) (uint, error) {
+	var userID uint
+	err := r.take(ctx, reauthPrefix+reauthToken, &userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// take decodes the JSON of key into v and deletes key in the same
+// transaction.
+func (r *authRepo) take(ctx context.Context, key string, v interface{}) error {
+	var get *goredis.StringCmd
+	_, err := r.redisClient.Conn().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(get.Val()), v)
+}
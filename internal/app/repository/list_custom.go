@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -13,7 +14,7 @@ import (
 )
 
 type CustomRepo interface {
-	List(req model.RequestDataTable, dataStruct interface{}, rawQuwey string) (*model.ResultDataTable, error)
+	List(ctx context.Context, req model.RequestDataTable, dataStruct interface{}, rawQuwey string) (*model.ResultDataTable, error)
 }
 
 func NewCustom(postgres postgres.Client) CustomRepo {
@@ -24,8 +25,8 @@ type listCustomRepo struct {
 	postgres postgres.Client
 }
 
-func (r *listCustomRepo) List(req model.RequestDataTable, dataStruct interface{}, rawQuery string) (*model.ResultDataTable, error) {
-	query := r.postgres.Conn().Table(rawQuery)
+func (r *listCustomRepo) List(ctx context.Context, req model.RequestDataTable, dataStruct interface{}, rawQuery string) (*model.ResultDataTable, error) {
+	query := r.postgres.Conn().WithContext(ctx).Table(rawQuery)
 
 	for f, v := range req.Additional {
 		inputProcessNew(query, f, v)
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OAuthClientRepo interface {
+	Create(ctx context.Context, client *model.OAuthClient) error
+	GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error)
+	List(ctx context.Context) ([]*model.OAuthClient, error)
+	Delete(ctx context.Context, clientID string) error
+	GetConsent(ctx context.Context, userID uint, clientID string) (*model.OAuthConsent, error)
+	SaveConsent(ctx context.Context, consent *model.OAuthConsent) error
+}
+
+type oauthClientRepo struct {
+	pg postgres.Client
+}
+
+func NewOAuthClientRepo(pg postgres.Client) OAuthClientRepo {
+	return &oauthClientRepo{pg}
+}
+
+func (r *oauthClientRepo) Create(ctx context.Context, client *model.OAuthClient) error {
+	return r.pg.Conn().WithContext(ctx).Create(client).Error
+}
+
+func (r *oauthClientRepo) GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	client := new(model.OAuthClient)
+	err := r.pg.Conn().WithContext(ctx).Where("client_id = ?", clientID).First(client).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (r *oauthClientRepo) List(ctx context.Context) ([]*model.OAuthClient, error) {
+	clients := make([]*model.OAuthClient, 0)
+	err := r.pg.Conn().WithContext(ctx).Order("id").Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// Delete removes the client and the consents granted to it.
+func (r *oauthClientRepo) Delete(ctx context.Context, clientID string) error {
+	return r.pg.Conn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("client_id = ?", clientID).Delete(&model.OAuthClient{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("client_id = ?", clientID).Delete(&model.OAuthConsent{}).Error
+	})
+}
+
+func (r *oauthClientRepo) GetConsent(ctx context.Context, userID uint, clientID string) (*model.OAuthConsent, error) {
+	consent := new(model.OAuthConsent)
+	err := r.pg.Conn().WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).First(consent).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return consent, nil
+}
+
+// SaveConsent replaces the scopes of an existing consent.
+func (r *oauthClientRepo) SaveConsent(ctx context.Context, consent *model.OAuthConsent) error {
+	return r.pg.Conn().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "change_on"}),
+	}).Create(consent).Error
+}
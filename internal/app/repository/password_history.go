@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+)
+
+type PasswordHistoryRepo interface {
+	Create(ctx context.Context, userID uint, hash string) error
+	Recent(ctx context.Context, userID uint, n int) ([]string, error)
+	Prune(ctx context.Context, userID uint, keep int) error
+}
+
+type passwordHistoryRepo struct {
+	pg postgres.Client
+}
+
+func NewPasswordHistoryRepo(pg postgres.Client) PasswordHistoryRepo {
+	return &passwordHistoryRepo{pg}
+}
+
+func (r *passwordHistoryRepo) Create(ctx context.Context, userID uint, hash string) error {
+	return r.pg.Conn().WithContext(ctx).Create(&model.PasswordHistory{
+		UserID:   userID,
+		Password: hash,
+	}).Error
+}
+
+// Recent returns the hashes of the last n passwords of the user, newest
+// first.
+func (r *passwordHistoryRepo) Recent(ctx context.Context, userID uint, n int) ([]string, error) {
+	var hashes []string
+	err := r.pg.Conn().WithContext(ctx).Model(&model.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(n).
+		Pluck("password", &hashes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// Prune deletes all but the last keep passwords of the user.
+func (r *passwordHistoryRepo) Prune(ctx context.Context, userID uint, keep int) error {
+	db := r.pg.Conn().WithContext(ctx)
+	kept := db.Model(&model.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(keep)
+
+	return db.Where("user_id = ? AND id NOT IN (?)", userID, kept).Delete(&model.PasswordHistory{}).Error
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+)
+
+type ServiceAccountRepo interface {
+	Create(ctx context.Context, account *model.ServiceAccount) error
+	GetByClientID(ctx context.Context, clientID string) (*model.ServiceAccount, error)
+	GetByName(ctx context.Context, name string) (*model.ServiceAccount, error)
+	List(ctx context.Context) ([]*model.ServiceAccount, error)
+	Update(ctx context.Context, account *model.ServiceAccount) error
+}
+
+type serviceAccountRepo struct {
+	pg postgres.Client
+}
+
+func NewServiceAccountRepo(pg postgres.Client) ServiceAccountRepo {
+	return &serviceAccountRepo{pg}
+}
+
+func (r *serviceAccountRepo) Create(ctx context.Context, account *model.ServiceAccount) error {
+	return r.pg.Conn().WithContext(ctx).Create(account).Error
+}
+
+func (r *serviceAccountRepo) GetByClientID(ctx context.Context, clientID string) (*model.ServiceAccount, error) {
+	account := new(model.ServiceAccount)
+	err := r.pg.Conn().WithContext(ctx).Where("client_id = ?", clientID).First(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (r *serviceAccountRepo) GetByName(ctx context.Context, name string) (*model.ServiceAccount, error) {
+	account := new(model.ServiceAccount)
+	err := r.pg.Conn().WithContext(ctx).Where("name = ?", name).First(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (r *serviceAccountRepo) List(ctx context.Context) ([]*model.ServiceAccount, error) {
+	accounts := make([]*model.ServiceAccount, 0)
+	err := r.pg.Conn().WithContext(ctx).Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func (r *serviceAccountRepo) Update(ctx context.Context, account *model.ServiceAccount) error {
+	return r.pg.Conn().WithContext(ctx).Model(&model.ServiceAccount{}).Where("id = ?", account.ID).
+		Updates(map[string]interface{}{
+			"secret_hash": account.SecretHash,
+			"role":        account.Role,
+			"scopes":      account.Scopes,
+			"is_disabled": account.IsDisabled,
+		}).Error
+}
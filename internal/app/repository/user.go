@@ -7,15 +7,18 @@ import (
 	"restapi/internal/app/model"
 	"restapi/internal/db/postgres"
 	"restapi/internal/db/redis"
+	"restapi/internal/metrics"
 	"time"
 )
 
 type UserRepo interface {
-	Create(user *model.User) error
-	Get(id uint) (*model.User, error)
-	GetByUsername(username string) (*model.User, error)
-	Update(user *model.User) error
-	Delete(id uint) error
+	Create(ctx context.Context, user *model.User) error
+	Get(ctx context.Context, id uint) (*model.User, error)
+	GetByUsername(ctx context.Context, username string) (*model.User, error)
+	// GetByEmail compares emails without case.
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Update(ctx context.Context, user *model.User) error
+	Delete(ctx context.Context, id uint) error
 }
 
 type userRepo struct {
@@ -27,13 +30,13 @@ func NewUserRepo(pg postgres.Client, rds redis.Client) UserRepo {
 	return &userRepo{pg, rds}
 }
 
-func (r *userRepo) Create(user *model.User) error {
-	err := r.pg.Conn().Create(&user).Select("id").Scan(&user.ID).Error
+func (r *userRepo) Create(ctx context.Context, user *model.User) error {
+	err := r.pg.Conn().WithContext(ctx).Create(&user).Select("id").Scan(&user.ID).Error
 	if err != nil {
 		return err
 	}
 
-	temp, err := r.Get(user.ID)
+	temp, err := r.Get(ctx, user.ID)
 	if err != nil {
 		return err
 	}
@@ -42,22 +45,24 @@ func (r *userRepo) Create(user *model.User) error {
 	return nil
 }
 
-func (r *userRepo) Get(id uint) (*model.User, error) {
+func (r *userRepo) Get(ctx context.Context, id uint) (*model.User, error) {
 	user := new(model.User)
 
-	str, err := r.rds.Conn().Get(context.Background(), fmt.Sprintf("user_id:%v", id)).Result()
+	str, err := r.rds.Conn().Get(ctx, fmt.Sprintf("user_id:%v", id)).Result()
 	if err == nil {
+		metrics.CacheHit()
 		json.Unmarshal([]byte(str), &user)
 		return user, nil
 	}
+	metrics.CacheMiss()
 
-	err = r.pg.Conn().First(&user, id).Error
+	err = r.pg.Conn().WithContext(ctx).First(&user, id).Error
 	if err != nil {
 		return nil, err
 	}
 
 	b, _ := json.Marshal(user)
-	_, err = r.rds.Conn().Set(context.Background(), fmt.Sprintf("user_id:%v", id), b, time.Duration(1*time.Hour)).Result()
+	_, err = r.rds.Conn().Set(ctx, fmt.Sprintf("user_id:%v", id), b, time.Duration(1*time.Hour)).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -65,17 +70,17 @@ func (r *userRepo) Get(id uint) (*model.User, error) {
 	return user, nil
 }
 
-func (r *userRepo) GetByUsername(username string) (*model.User, error) {
+func (r *userRepo) GetByUsername(ctx context.Context, username string) (*model.User, error) {
 	user := new(model.User)
 
-	err := r.pg.Conn().Where(&model.User{
+	err := r.pg.Conn().WithContext(ctx).Where(&model.User{
 		Username: username,
 	}).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 
-	temp, err := r.Get(user.ID)
+	temp, err := r.Get(ctx, user.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -84,25 +89,41 @@ func (r *userRepo) GetByUsername(username string) (*model.User, error) {
 	return user, nil
 }
 
-func (r *userRepo) Update(user *model.User) error {
-	err := r.pg.Conn().Model(&model.User{}).Where("id = ?", user.ID).
+func (r *userRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	user := new(model.User)
+
+	err := r.pg.Conn().WithContext(ctx).Where("lower(email) = lower(?)", email).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *userRepo) Update(ctx context.Context, user *model.User) error {
+	err := r.pg.Conn().WithContext(ctx).Model(&model.User{}).Where("id = ?", user.ID).
 		Updates(map[string]interface{}{
-			"id":         user.ID,
-			"username":   user.Username,
-			"is_login":   user.IsLogin,
-			"token_uuid": user.TokenUuid,
-			"password":   user.Password,
+			"id":                 user.ID,
+			"username":           user.Username,
+			"is_login":           user.IsLogin,
+			"token_uuid":         user.TokenUuid,
+			"password":           user.Password,
+			"role":               user.Role,
+			"is_disabled":        user.IsDisabled,
+			"password_change_on": user.PasswordChangedAt,
+			"email":              user.Email,
+			"auth_source":        user.AuthSource,
 		}).Error
 	if err != nil {
 		return err
 	}
 
-	_, err = r.rds.Conn().Del(context.Background(), fmt.Sprintf("user_id:%v", user.ID)).Result()
+	_, err = r.rds.Conn().Del(ctx, fmt.Sprintf("user_id:%v", user.ID)).Result()
 	if err != nil {
 		return err
 	}
 
-	temp, err := r.Get(user.ID)
+	temp, err := r.Get(ctx, user.ID)
 	if err != nil {
 		return err
 	}
@@ -111,13 +132,13 @@ func (r *userRepo) Update(user *model.User) error {
 	return nil
 }
 
-func (r *userRepo) Delete(id uint) error {
-	err := r.pg.Conn().Delete(&model.User{}, id).Error
+func (r *userRepo) Delete(ctx context.Context, id uint) error {
+	err := r.pg.Conn().WithContext(ctx).Delete(&model.User{}, id).Error
 	if err != nil {
 		return err
 	}
 
-	_, err = r.rds.Conn().Del(context.Background(), fmt.Sprintf("user_id:%v", id)).Result()
+	_, err = r.rds.Conn().Del(ctx, fmt.Sprintf("user_id:%v", id)).Result()
 	if err != nil {
 		return err
 	}
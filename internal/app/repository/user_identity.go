@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserIdentityRepo interface {
+	Get(ctx context.Context, provider, subject string) (*model.UserIdentity, error)
+	Create(ctx context.Context, identity *model.UserIdentity) error
+	// CreateWithUser provisions user and links identity to it in one
+	// transaction.
+	CreateWithUser(ctx context.Context, user *model.User, identity *model.UserIdentity) error
+	Touch(ctx context.Context, identity *model.UserIdentity, email string, at time.Time) error
+}
+
+type userIdentityRepo struct {
+	pg postgres.Client
+}
+
+func NewUserIdentityRepo(pg postgres.Client) UserIdentityRepo {
+	return &userIdentityRepo{pg}
+}
+
+func (r *userIdentityRepo) Get(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	identity := new(model.UserIdentity)
+	err := r.pg.Conn().WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(identity).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (r *userIdentityRepo) Create(ctx context.Context, identity *model.UserIdentity) error {
+	return r.pg.Conn().WithContext(ctx).Create(identity).Error
+}
+
+func (r *userIdentityRepo) CreateWithUser(ctx context.Context, user *model.User, identity *model.UserIdentity) error {
+	return r.pg.Conn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(user).Error
+		if err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+// Touch records a login with identity and the email the provider sent.
+func (r *userIdentityRepo) Touch(ctx context.Context, identity *model.UserIdentity, email string, at time.Time) error {
+	identity.Email = email
+	identity.LastLoginAt = &at
+
+	return r.pg.Conn().WithContext(ctx).Model(identity).Updates(map[string]interface{}{
+		"email":         email,
+		"last_login_on": at,
+	}).Error
+}
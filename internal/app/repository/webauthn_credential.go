@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebAuthnCredentialRepo interface {
+	Create(ctx context.Context, cred *model.WebAuthnCredential) error
+	GetByCredentialID(ctx context.Context, credentialID string) (*model.WebAuthnCredential, error)
+	ListByUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error)
+	CountByUser(ctx context.Context, userID uint) (int64, error)
+	Delete(ctx context.Context, userID, id uint) error
+	Touch(ctx context.Context, cred *model.WebAuthnCredential, signCount int64, backedUp bool, at time.Time) error
+}
+
+type webAuthnCredentialRepo struct {
+	pg postgres.Client
+}
+
+func NewWebAuthnCredentialRepo(pg postgres.Client) WebAuthnCredentialRepo {
+	return &webAuthnCredentialRepo{pg}
+}
+
+func (r *webAuthnCredentialRepo) Create(ctx context.Context, cred *model.WebAuthnCredential) error {
+	return r.pg.Conn().WithContext(ctx).Create(cred).Error
+}
+
+func (r *webAuthnCredentialRepo) GetByCredentialID(ctx context.Context, credentialID string) (*model.WebAuthnCredential, error) {
+	cred := new(model.WebAuthnCredential)
+	err := r.pg.Conn().WithContext(ctx).Where("credential_id = ?", credentialID).First(cred).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+func (r *webAuthnCredentialRepo) ListByUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error) {
+	creds := make([]*model.WebAuthnCredential, 0)
+	err := r.pg.Conn().WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&creds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (r *webAuthnCredentialRepo) CountByUser(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.pg.Conn().WithContext(ctx).Model(&model.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// Delete returns gorm.ErrRecordNotFound when the user has no such passkey.
+func (r *webAuthnCredentialRepo) Delete(ctx context.Context, userID, id uint) error {
+	res := r.pg.Conn().WithContext(ctx).Where("user_id = ?", userID).Delete(&model.WebAuthnCredential{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Touch records a login with cred. The counter only moves forward, so of
+// two concurrent logins with a cloned key the second fails.
+func (r *webAuthnCredentialRepo) Touch(ctx context.Context, cred *model.WebAuthnCredential, signCount int64, backedUp bool, at time.Time) error {
+	res := r.pg.Conn().WithContext(ctx).Model(&model.WebAuthnCredential{}).
+		Where("id = ? AND (sign_count < ? OR sign_count = 0)", cred.ID, signCount).
+		Updates(map[string]interface{}{
+			"sign_count":   signCount,
+			"backed_up":    backedUp,
+			"last_used_on": at,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	cred.SignCount, cred.BackedUp, cred.LastUsedAt = signCount, backedUp, &at
+	return nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/tracing"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lastUsedInterval throttles the last used updates of a token.
+const lastUsedInterval = time.Minute
+
+type AccessTokenService interface {
+	Create(ctx context.Context, req model.AccessTokenCreateRequest) (*model.AccessTokenResponse, error)
+	List(ctx context.Context, userID uint) ([]*model.AccessTokenResponse, error)
+	Revoke(ctx context.Context, userID, id uint) error
+	Authenticate(ctx context.Context, raw, ip string) (*model.AccessDetails, error)
+}
+
+type accessTokenService struct {
+	tokenRepo repository.AccessTokenRepo
+	userRepo  repository.UserRepo
+}
+
+func NewAccessTokenService(tokenRepo repository.AccessTokenRepo, userRepo repository.UserRepo) AccessTokenService {
+	return &accessTokenService{tokenRepo, userRepo}
+}
+
+// Create returns the token in clear text, it cannot be read back later.
+func (s *accessTokenService) Create(ctx context.Context, req model.AccessTokenCreateRequest) (*model.AccessTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "accessTokenService.Create")
+	defer span.End()
+
+	if req.GrantedScopes != nil {
+		for _, scope := range req.Scopes {
+			if !contains(req.GrantedScopes, scope) {
+				return nil, constant.ErrInsufficientScope
+			}
+		}
+	}
+
+	lookupID, secret, err := newAccessTokenSecret()
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate access token")
+		return nil, constant.ErrServer
+	}
+	raw := model.AccessTokenPrefix + lookupID + "_" + secret
+
+	token := &model.AccessToken{
+		UserID:   req.UserID,
+		Name:     req.Name,
+		LookupID: lookupID,
+		Hash:     hashAccessToken(raw),
+		Scopes:   strings.Join(req.Scopes, ","),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	err = s.tokenRepo.Create(ctx, token)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create access token")
+		return nil, constant.ErrServer
+	}
+
+	res := model.NewAccessTokenResponse(token)
+	res.Token = raw
+	return res, nil
+}
+
+func (s *accessTokenService) List(ctx context.Context, userID uint) ([]*model.AccessTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "accessTokenService.List")
+	defer span.End()
+
+	tokens, err := s.tokenRepo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list access tokens")
+		return nil, constant.ErrServer
+	}
+
+	res := make([]*model.AccessTokenResponse, len(tokens))
+	for i, token := range tokens {
+		res[i] = model.NewAccessTokenResponse(token)
+	}
+
+	return res, nil
+}
+
+func (s *accessTokenService) Revoke(ctx context.Context, userID, id uint) error {
+	ctx, span := tracing.Start(ctx, "accessTokenService.Revoke")
+	defer span.End()
+
+	err := s.tokenRepo.Delete(ctx, userID, id)
+	switch err {
+	case nil:
+		return nil
+	case gorm.ErrRecordNotFound:
+		return constant.ErrAccessTokenNotFound
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to revoke access token")
+		return constant.ErrServer
+	}
+}
+
+// Authenticate resolves a token to the details of its user and records
+// its use from ip.
+func (s *accessTokenService) Authenticate(ctx context.Context, raw, ip string) (*model.AccessDetails, error) {
+	ctx, span := tracing.Start(ctx, "accessTokenService.Authenticate")
+	defer span.End()
+
+	// rpat_<lookup id>_<secret>
+	parts := strings.SplitN(strings.TrimPrefix(raw, model.AccessTokenPrefix), "_", 2)
+	if !strings.HasPrefix(raw, model.AccessTokenPrefix) || len(parts) != 2 {
+		return nil, constant.ErrUnauthenticated
+	}
+
+	token, err := s.tokenRepo.GetByLookupID(ctx, parts[0])
+	if err == gorm.ErrRecordNotFound {
+		return nil, constant.ErrUnauthenticated
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get access token")
+		return nil, constant.ErrServer
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashAccessToken(raw)), []byte(token.Hash)) != 1 || token.Expired(now) {
+		return nil, constant.ErrUnauthenticated
+	}
+
+	user, err := s.userRepo.Get(ctx, token.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, constant.ErrUnauthenticated
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get access token user")
+		return nil, constant.ErrServer
+	}
+	if user.IsDisabled {
+		return nil, constant.ErrUserDisabled
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedInterval || token.LastUsedIP != ip {
+		err = s.tokenRepo.Touch(ctx, token.ID, now, ip)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to record access token use")
+		}
+	}
+
+	// like a session, the token only changes the password once it expired
+	return &model.AccessDetails{
+		UserId:          user.ID,
+		Username:        user.Username,
+		Role:            user.Role,
+		Scopes:          token.ScopeList(),
+		PasswordExpired: passwordExpired(user),
+	}, nil
+}
+
+// newAccessTokenSecret returns a lookup id and a secret with 256 bits of
+// entropy.
+func newAccessTokenSecret() (string, string, error) {
+	b := make([]byte, 10+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	lookupID := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:10]))
+	return lookupID, base64.RawURLEncoding.EncodeToString(b[10:]), nil
+}
+
+// hashAccessToken does not need a slow hash, the token is random.
+func hashAccessToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
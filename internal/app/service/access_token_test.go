@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenFollowsPasswordExpiry(t *testing.T) {
+	loadConfig(t, map[string]string{"PASSWORD_MAX_AGE": "24h"})
+	users := newFakeUserRepo()
+	s := NewAccessTokenService(&fakeAccessTokenRepo{}, users)
+	ctx := context.Background()
+
+	now := time.Now()
+	alice := createLocalUser(t, users, "alice", "alice-password")
+	alice.PasswordChangedAt = &now
+	users.Update(ctx, alice)
+
+	token, err := s.Create(ctx, model.AccessTokenCreateRequest{UserID: alice.ID, Name: "ci", Scopes: []string{model.ScopeUserRead}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	acc, err := s.Authenticate(ctx, token.Token, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if acc.PasswordExpired {
+		t.Error("a fresh password is reported expired")
+	}
+
+	old := now.Add(-48 * time.Hour)
+	alice.PasswordChangedAt = &old
+	users.Update(ctx, alice)
+
+	acc, err = s.Authenticate(ctx, token.Token, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !acc.PasswordExpired {
+		t.Error("the token of a user with an expired password is not held to the policy")
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/logger"
+)
+
+// audit actions
+const (
+	AuditOAuthToken      = "oauth.token"
+	AuditOAuthAuthorize  = "oauth.authorize"
+	AuditOAuthRevoke     = "oauth.revoke"
+	AuditExternalLogin   = "login.external"
+	AuditIdentityLink    = "identity.link"
+	AuditMagicLinkSend   = "login.magic_link.send"
+	AuditMagicLinkLogin  = "login.magic_link"
+	AuditPasskeyRegister = "passkey.register"
+	AuditPasskeyDelete   = "passkey.delete"
+
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationRequest = "impersonation.request"
+	AuditImpersonationStop    = "impersonation.stop"
+)
+
+type AuditService interface {
+	Record(ctx context.Context, event model.AuditEvent)
+}
+
+type auditService struct {
+	auditRepo repository.AuditRepo
+}
+
+func NewAuditService(auditRepo repository.AuditRepo) AuditService {
+	return &auditService{auditRepo}
+}
+
+// Record stores event with the request id of ctx. The audited action has
+// already happened, so a failure to store it is logged, not returned.
+func (s *auditService) Record(ctx context.Context, event model.AuditEvent) {
+	if event.RequestID == "" {
+		event.RequestID = logger.RequestIDFrom(ctx)
+	}
+
+	err := s.auditRepo.Create(ctx, &event)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Str("action", event.Action).Str("actor_id", event.ActorID).Msg("failed to record audit event")
+		return
+	}
+
+	logger.Ctx(ctx).Info().
+		Str("action", event.Action).
+		Str("actor_type", event.ActorType).
+		Str("actor_id", event.ActorID).
+		Str("target", event.Target).
+		Bool("success", event.Success).
+		Msg("audit")
+}
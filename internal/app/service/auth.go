@@ -1,109 +1,413 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"restapi/internal/app/model"
 	"restapi/internal/app/repository"
+	"restapi/internal/config"
 	"restapi/internal/constant"
 	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/password"
 	"restapi/internal/security/token"
+	"restapi/internal/security/webauthn"
+	"restapi/internal/tracing"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	goredis "github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
 type AuthService interface {
-	Login(req model.AuthRequest) (*model.AuthResponse, error)
-	Refresh(req model.AccessDetails) (*model.AuthResponse, error)
-	Logout(metaData *model.AccessDetails) error
+	Login(ctx context.Context, req model.AuthRequest) (*model.AuthResponse, error)
+	Authenticate(ctx context.Context, username, password string) (*model.User, error)
+	StartSession(ctx context.Context, user *model.User, forceLogin bool) (*model.AuthResponse, error)
+	LoginUser(ctx context.Context, user *model.User, forceLogin bool) (*model.AuthResponse, error)
+	PasskeyOptions(ctx context.Context, req model.PasskeyLoginOptionsRequest) (*webauthn.RequestOptions, error)
+	PasskeyLogin(ctx context.Context, req model.PasskeyLoginRequest) (*model.AuthResponse, error)
+	ReauthOptions(ctx context.Context, userID uint) (*webauthn.RequestOptions, error)
+	Reauthenticate(ctx context.Context, req model.PasskeyReauthRequest) (*model.PasskeyReauthResponse, error)
+	Refresh(ctx context.Context, req model.AccessDetails) (*model.AuthResponse, error)
+	CheckToken(ctx context.Context, acc *model.AccessDetails) error
+	Logout(ctx context.Context, metaData *model.AccessDetails) error
+	Sessions(ctx context.Context, userId uint) ([]*model.SessionResponse, error)
+	LogoutAll(ctx context.Context, userId uint) error
 }
 
 func NewAuthService(
 	userRepo repository.UserRepo,
 	authRepo repository.AuthRepo,
-	tk token.TokenInterface) AuthService {
-	return &authService{userRepo, authRepo, tk}
+	tk token.TokenInterface,
+	authenticator Authenticator,
+	credentialRepo repository.WebAuthnCredentialRepo) AuthService {
+	return &authService{userRepo, authRepo, tk, authenticator, credentialRepo}
 }
 
 type authService struct {
-	userRepo repository.UserRepo
-	authRepo repository.AuthRepo
-	tk       token.TokenInterface
+	userRepo       repository.UserRepo
+	authRepo       repository.AuthRepo
+	tk             token.TokenInterface
+	authenticator  Authenticator
+	credentialRepo repository.WebAuthnCredentialRepo
 }
 
-func (s *authService) Login(req model.AuthRequest) (*model.AuthResponse, error) {
-	user, err := s.userRepo.GetByUsername(req.Username)
+func (s *authService) Login(ctx context.Context, req model.AuthRequest) (*model.AuthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.Login")
+	defer span.End()
+
+	user, err := s.authenticator.Authenticate(ctx, req.Username, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.login(ctx, user, req.ForceLogin)
+}
+
+// LoginUser logs in a user whose first factor another service checked, such
+// as a magic link. Like Login it asks for the passkey of the user and
+// applies the password policy.
+func (s *authService) LoginUser(ctx context.Context, user *model.User, forceLogin bool) (*model.AuthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.LoginUser")
+	defer span.End()
+
+	if user.IsDisabled {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrUserDisabled
+	}
+
+	return s.login(ctx, user, forceLogin)
+}
+
+func (s *authService) login(ctx context.Context, user *model.User, forceLogin bool) (*model.AuthResponse, error) {
+	expired := passwordExpired(user)
+
+	hasPasskey, err := s.hasPasskey(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	} else if hasPasskey {
+		return s.pendingLogin(ctx, user, forceLogin, expired)
+	}
+
+	return s.startSession(ctx, user, forceLogin, expired)
+}
+
+// pendingLogin holds a first factor login until the passkey of the user
+// completes it at PasskeyLogin.
+func (s *authService) pendingLogin(ctx context.Context, user *model.User, forceLogin, expired bool) (*model.AuthResponse, error) {
+	// fail before the passkey is asked for a login that would fail anyway
+	if user.IsLogin && !forceLogin {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrAlreadyLoggedIn
+	}
+
+	token := oidc.RandomString(32)
+	err := s.authRepo.CreatePendingLogin(ctx, token, &model.PendingLogin{
+		UserID:          user.ID,
+		ForceLogin:      forceLogin,
+		PasswordExpired: expired,
+	}, config.Cfg().WebAuthnTimeout)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store pending login")
+		return nil, constant.ErrServer
+	}
+
+	return &model.AuthResponse{PasskeyRequired: true, LoginToken: token}, nil
+}
+
+// PasskeyOptions starts a passkey login, see
+// model.PasskeyLoginOptionsRequest.
+func (s *authService) PasskeyOptions(ctx context.Context, req model.PasskeyLoginOptionsRequest) (*webauthn.RequestOptions, error) {
+	ctx, span := tracing.Start(ctx, "authService.PasskeyOptions")
+	defer span.End()
+
+	ceremony := &model.WebAuthnChallenge{Ceremony: model.CeremonyLogin}
+	switch {
+	case req.LoginToken != "":
+		pending, err := s.authRepo.GetPendingLogin(ctx, req.LoginToken)
+		if err != nil {
+			if err != goredis.Nil {
+				logger.Ctx(ctx).Err(err).Msg("failed to get pending login")
+				return nil, constant.ErrServer
+			}
+			return nil, constant.ErrPasskeyLogin
+		}
+		ceremony.UserID, ceremony.LoginToken = pending.UserID, req.LoginToken
+	case req.Username != "":
+		user, err := s.userRepo.GetByUsername(ctx, req.Username)
+		switch err {
+		case nil:
+			ceremony.UserID = user.ID
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrPasskeyNotFound
+		default:
+			logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+			return nil, constant.ErrServer
+		}
+	}
+
+	var allow []webauthn.CredentialDescriptor
+	if ceremony.UserID != 0 {
+		creds, err := s.credentialRepo.ListByUser(ctx, ceremony.UserID)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to list passkeys")
+			return nil, constant.ErrServer
+		} else if len(creds) == 0 {
+			return nil, constant.ErrPasskeyNotFound
+		}
+		allow = credentialDescriptors(creds)
+	}
+
+	challenge, err := newChallenge(ctx, s.authRepo, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	return relyingParty().RequestOptions(challenge, allow), nil
+}
+
+// PasskeyLogin logs in with the answer of the authenticator to
+// PasskeyOptions, completing the pending password login if there is one.
+func (s *authService) PasskeyLogin(ctx context.Context, req model.PasskeyLoginRequest) (*model.AuthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.PasskeyLogin")
+	defer span.End()
+
+	ceremony, cred, err := verifyPasskey(ctx, s.authRepo, s.credentialRepo, model.CeremonyLogin, &req.Credential)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get user by username")
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, err
+	}
+
+	user, err := s.userRepo.Get(ctx, cred.UserID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		metrics.AuthEvent(metrics.EventLoginFailed)
 		switch err {
 		case gorm.ErrRecordNotFound:
-			return nil, constant.ErrUserNameNotRegistered
+			return nil, constant.ErrPasskeyLogin
 		default:
 			return nil, constant.ErrServer
 		}
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+	if user.IsDisabled {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrUserDisabled
+	}
+
+	// a passkey alone still honours the password policy
+	forceLogin := req.ForceLogin
+	expired := passwordExpired(user)
+	if ceremony.LoginToken != "" {
+		pending, err := s.authRepo.TakePendingLogin(ctx, ceremony.LoginToken)
+		if err != nil {
+			metrics.AuthEvent(metrics.EventLoginFailed)
+			return nil, constant.ErrPasskeyLogin
+		}
+		forceLogin, expired = pending.ForceLogin, pending.PasswordExpired
+	}
+
+	return s.startSession(ctx, user, forceLogin, expired)
+}
+
+// ReauthOptions asks the authenticator of a logged in user for one of its
+// passkeys, to confirm the user before its passkeys change.
+func (s *authService) ReauthOptions(ctx context.Context, userID uint) (*webauthn.RequestOptions, error) {
+	ctx, span := tracing.Start(ctx, "authService.ReauthOptions")
+	defer span.End()
+
+	creds, err := s.credentialRepo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list passkeys")
+		return nil, constant.ErrServer
+	} else if len(creds) == 0 {
+		return nil, constant.ErrPasskeyNotFound
+	}
+
+	challenge, err := newChallenge(ctx, s.authRepo, &model.WebAuthnChallenge{Ceremony: model.CeremonyReauth, UserID: userID})
 	if err != nil {
 		return nil, err
 	}
 
-	if user.IsLogin && !req.ForceLogin {
-		logger.Log().Err(errors.New("try to force login")).Msg("user is already logged in another device")
-		return nil, errors.New("user is already logged in another device")
-	} else if req.ForceLogin {
+	return relyingParty().RequestOptions(challenge, credentialDescriptors(creds)), nil
+}
+
+// Reauthenticate confirms the user of a session the way it logs in: with
+// its passkey when it has one, with its password otherwise. The reauth
+// token it returns allows one change of the passkeys.
+func (s *authService) Reauthenticate(ctx context.Context, req model.PasskeyReauthRequest) (*model.PasskeyReauthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.Reauthenticate")
+	defer span.End()
+
+	if req.Credential != nil {
+		_, cred, err := verifyPasskey(ctx, s.authRepo, s.credentialRepo, model.CeremonyReauth, req.Credential)
+		if err != nil {
+			return nil, err
+		} else if cred.UserID != req.UserID {
+			return nil, constant.ErrPasskeyLogin
+		}
+	} else {
+		user, err := s.Authenticate(ctx, req.Username, req.Password)
+		if err != nil {
+			return nil, err
+		} else if user.ID != req.UserID {
+			return nil, constant.ErrWrongPassword
+		}
+	}
+
+	ttl := config.Cfg().WebAuthnTimeout
+	token := oidc.RandomString(32)
+	err := s.authRepo.CreateReauth(ctx, token, req.UserID, ttl)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store reauth token")
+		return nil, constant.ErrServer
+	}
+
+	return &model.PasskeyReauthResponse{ReauthToken: token, ExpiresIn: int64(ttl / time.Second)}, nil
+}
+
+func (s *authService) hasPasskey(ctx context.Context, userID uint) (bool, error) {
+	count, err := s.credentialRepo.CountByUser(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to count passkeys")
+		return false, constant.ErrServer
+	}
+
+	return count > 0, nil
+}
+
+// passwordExpired applies the password policy to the local password of
+// user. The directory owns the password of LDAP users, their random local
+// one never expires.
+func passwordExpired(user *model.User) bool {
+	if user.AuthSource == config.AuthBackendLDAP {
+		return false
+	}
+
+	return password.CurrentPolicy().Expired(user.PasswordAge(), time.Now())
+}
+
+// StartSession logs in a user authenticated by an external identity
+// provider, the age of its local password does not matter. Like Login it
+// asks for the passkey of the user.
+func (s *authService) StartSession(ctx context.Context, user *model.User, forceLogin bool) (*model.AuthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.StartSession")
+	defer span.End()
+
+	if user.IsDisabled {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrUserDisabled
+	}
+
+	hasPasskey, err := s.hasPasskey(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	} else if hasPasskey {
+		return s.pendingLogin(ctx, user, forceLogin, false)
+	}
+
+	return s.startSession(ctx, user, forceLogin, false)
+}
+
+// startSession replaces the session of user by a new token pair, unless it
+// is logged in on another device and forceLogin is not set.
+func (s *authService) startSession(ctx context.Context, user *model.User, forceLogin, expired bool) (*model.AuthResponse, error) {
+	var err error
+	if user.IsLogin && !forceLogin {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		logger.Ctx(ctx).Err(errors.New("try to force login")).Msg("user is already logged in another device")
+		return nil, constant.ErrAlreadyLoggedIn
+	} else if forceLogin {
 		metaData := &model.AccessDetails{
 			TokenUuid: user.TokenUuid,
 			Username:  user.Username,
 			UserId:    user.ID,
 		}
 
-		err = s.authRepo.DeleteTokens(metaData)
+		err = s.authRepo.DeleteTokens(ctx, metaData)
 		if err != nil {
 
-			logger.Log().Err(err).Msg("failed to force login")
+			logger.Ctx(ctx).Err(err).Msg("failed to force login")
 			return nil, err
 		}
 	}
 
 	claims := map[string]interface{}{
-		"user_id":   user.ID,
-		"username":  user.Username,
-		"user_role": user.Role,
+		"user_id":          user.ID,
+		"username":         user.Username,
+		"user_role":        user.Role,
+		"password_expired": expired,
 	}
+	_, tokenSpan := tracing.Start(ctx, "token.CreateToken")
 	ts, err := s.tk.CreateToken(claims)
+	tokenSpan.End()
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create token")
+		logger.Ctx(ctx).Err(err).Msg("failed to create token")
 		return nil, err
 	}
 
-	err = s.authRepo.CreateAuth(claims, ts)
+	err = s.authRepo.CreateAuth(ctx, claims, ts)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create auth")
+		logger.Ctx(ctx).Err(err).Msg("failed to create auth")
 		return nil, err
 	}
 
 	user.IsLogin = true
 	user.TokenUuid = ts.TokenUuid
-	err = s.userRepo.Update(user)
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to login")
+		logger.Ctx(ctx).Err(err).Msg("failed to login")
 		return nil, constant.ErrServer
 	}
 
+	metrics.AuthEvent(metrics.EventLogin)
 	res := &model.AuthResponse{
-		AccessToken:  ts.AccessToken,
-		RefreshToken: ts.RefreshToken,
+		AccessToken:     ts.AccessToken,
+		RefreshToken:    ts.RefreshToken,
+		PasswordExpired: expired,
 	}
 
 	return res, nil
 }
 
-func (s *authService) Refresh(req model.AccessDetails) (*model.AuthResponse, error) {
-	td, err := s.authRepo.FetchAuth(req.TokenUuid)
+// Authenticate checks the credentials of a user without starting a
+// session, for logins made on behalf of an OAuth client.
+func (s *authService) Authenticate(ctx context.Context, username, pw string) (*model.User, error) {
+	ctx, span := tracing.Start(ctx, "authService.Authenticate")
+	defer span.End()
+
+	user, err := s.authenticator.Authenticate(ctx, username, pw)
+	if err != nil {
+		return nil, err
+	}
+
+	// the password alone is not enough for users with a passkey
+	hasPasskey, err := s.hasPasskey(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	} else if hasPasskey {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrPasskeyRequired
+	}
+
+	return user, nil
+}
+
+func (s *authService) Refresh(ctx context.Context, req model.AccessDetails) (*model.AuthResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.Refresh")
+	defer span.End()
+
+	// tokens of OAuth clients are refreshed at the token endpoint, a session
+	// would drop their scopes; impersonation tokens just end
+	if req.ClientID != "" || req.Actor != nil {
+		return nil, constant.ErrRefreshToken
+	}
+
+	td, err := s.authRepo.FetchAuth(ctx, req.TokenUuid)
 	if err != nil {
-		logger.Log().Err(errors.New("token not valid")).Msg("error fetch auth with prev token")
-		return nil, errors.New("refresh token not valid")
+		logger.Ctx(ctx).Err(errors.New("token not valid")).Msg("error fetch auth with prev token")
+		return nil, constant.ErrRefreshToken
 	}
 
 	accDetail := &model.AccessDetails{
@@ -112,72 +416,186 @@ func (s *authService) Refresh(req model.AccessDetails) (*model.AuthResponse, err
 		Username:  req.Username,
 		Role:      req.Role,
 	}
-	err = s.authRepo.DeleteTokens(accDetail)
+	err = s.authRepo.DeleteTokens(ctx, accDetail)
 	if err != nil {
-		logger.Log().Err(errors.New("token not valid")).Msg("refresh token not valid")
-		return nil, errors.New("refresh token not valid")
+		logger.Ctx(ctx).Err(errors.New("token not valid")).Msg("refresh token not valid")
+		return nil, constant.ErrRefreshToken
 	}
 
-	data := map[string]interface{}{
-		"user_id":   td["user_id"],
-		"username":  td["username"],
-		"user_role": req.Role,
-	}
-	ts, err := s.tk.CreateToken(data)
+	user, err := s.userRepo.Get(ctx, uint(td["user_id"].(float64)))
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create new refresh token")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id for new refresh auth")
 		return nil, err
 	}
 
-	err = s.authRepo.CreateAuth(data, ts)
+	if user.IsDisabled {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrUserDisabled
+	}
+
+	// the password and the role may have been changed since the previous
+	// token
+	expired := passwordExpired(user)
+	data := map[string]interface{}{
+		"user_id":          td["user_id"],
+		"username":         td["username"],
+		"user_role":        user.Role,
+		"password_expired": expired,
+	}
+	ts, err := s.tk.CreateToken(data)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create new refresh auth")
+		logger.Ctx(ctx).Err(err).Msg("failed to create new refresh token")
 		return nil, err
 	}
 
-	user, err := s.userRepo.Get(uint(td["user_id"].(float64)))
+	err = s.authRepo.CreateAuth(ctx, data, ts)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get user by id for new refresh auth")
+		logger.Ctx(ctx).Err(err).Msg("failed to create new refresh auth")
 		return nil, err
 	}
 
 	user.TokenUuid = ts.TokenUuid
-	err = s.userRepo.Update(user)
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed update user for new refresh token")
+		logger.Ctx(ctx).Err(err).Msg("failed update user for new refresh token")
 		return nil, constant.ErrServer
 	}
 
+	metrics.AuthEvent(metrics.EventRefresh)
+
 	res := &model.AuthResponse{
-		AccessToken:  ts.AccessToken,
-		RefreshToken: ts.RefreshToken,
+		AccessToken:     ts.AccessToken,
+		RefreshToken:    ts.RefreshToken,
+		PasswordExpired: expired,
 	}
 
 	return res, nil
 }
 
-func (s *authService) Logout(metaData *model.AccessDetails) error {
-	err := s.authRepo.DeleteTokens(metaData)
+// CheckToken answers ErrUnauthenticated for a signed access token that was
+// revoked, by a logout or at /oauth/revoke, before it expired.
+func (s *authService) CheckToken(ctx context.Context, acc *model.AccessDetails) error {
+	_, err := s.authRepo.FetchAuth(ctx, acc.TokenUuid)
+	switch err {
+	case nil:
+		return nil
+	case goredis.Nil:
+		return constant.ErrUnauthenticated
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to fetch auth")
+		return constant.ErrServer
+	}
+}
+
+func (s *authService) Logout(ctx context.Context, metaData *model.AccessDetails) error {
+	ctx, span := tracing.Start(ctx, "authService.Logout")
+	defer span.End()
+
+	err := s.authRepo.DeleteTokens(ctx, metaData)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to logout")
+		logger.Ctx(ctx).Err(err).Msg("failed to logout")
 		if err != nil {
 			return err
 		}
 	}
 
-	user, err := s.userRepo.GetByUsername(metaData.Username)
+	// the tokens of OAuth clients and of impersonating admins are not the
+	// session of the user
+	if metaData.ClientID != "" || metaData.Actor != nil {
+		return nil
+	}
+
+	user, err := s.userRepo.GetByUsername(ctx, metaData.Username)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to logout")
+		logger.Ctx(ctx).Err(err).Msg("failed to logout")
 		return err
 	}
 
 	user.IsLogin = false
 	user.TokenUuid = ""
-	err = s.userRepo.Update(user)
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to logout")
+		logger.Ctx(ctx).Err(err).Msg("failed to logout")
 		return err
 	}
 
+	metrics.AuthEvent(metrics.EventLogout)
+	return nil
+}
+
+func (s *authService) Sessions(ctx context.Context, userId uint) ([]*model.SessionResponse, error) {
+	ctx, span := tracing.Start(ctx, "authService.Sessions")
+	defer span.End()
+
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	sessions := []*model.SessionResponse{}
+	if !user.IsLogin || user.TokenUuid == "" {
+		return sessions, nil
+	}
+
+	ttl, err := s.authRepo.TTL(ctx, user.TokenUuid)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get session ttl")
+		return nil, constant.ErrServer
+	}
+
+	// a negative ttl means redis already evicted the access token
+	if ttl > 0 {
+		sessions = append(sessions, &model.SessionResponse{
+			TokenUuid: user.TokenUuid,
+			UserId:    user.ID,
+			Username:  user.Username,
+			ExpiresIn: int64(ttl.Seconds()),
+		})
+	}
+
+	return sessions, nil
+}
+
+func (s *authService) LogoutAll(ctx context.Context, userId uint) error {
+	ctx, span := tracing.Start(ctx, "authService.LogoutAll")
+	defer span.End()
+
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return constant.ErrUserNotFound
+		default:
+			return constant.ErrServer
+		}
+	}
+
+	if user.TokenUuid != "" {
+		err = s.authRepo.DeleteTokens(ctx, &model.AccessDetails{
+			TokenUuid: user.TokenUuid,
+			UserId:    user.ID,
+			Username:  user.Username,
+		})
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to delete user tokens")
+			return constant.ErrServer
+		}
+	}
+
+	user.IsLogin = false
+	user.TokenUuid = ""
+	err = s.userRepo.Update(ctx, user)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to logout all sessions")
+		return constant.ErrServer
+	}
+
 	return nil
 }
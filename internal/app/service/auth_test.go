@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/security/token"
+	"testing"
+)
+
+func TestRefreshFollowsTheStoredUser(t *testing.T) {
+	loadConfig(t, nil)
+	users, auth, tk := newFakeUserRepo(), newFakeAuthRepo(), token.NewToken()
+	s := NewAuthService(users, auth, tk, NewLocalAuthenticator(users), &fakeCredentialRepo{})
+	ctx := context.Background()
+
+	alice := createLocalUser(t, users, "alice", "alice-password")
+	alice.Role = "admin"
+	users.Update(ctx, alice)
+
+	res, err := s.Login(ctx, model.AuthRequest{Username: "alice", Password: "alice-password"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	acc, err := tk.ParseAccessToken(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a demoted admin does not keep the role of its previous token
+	alice, _ = users.Get(ctx, alice.ID)
+	alice.Role = "user"
+	users.Update(ctx, alice)
+
+	res, err = s.Refresh(ctx, *acc)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	acc, err = tk.ParseAccessToken(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Role != "user" {
+		t.Errorf("refreshed role = %q, want the stored role user", acc.Role)
+	}
+
+	alice, _ = users.Get(ctx, alice.ID)
+	alice.IsDisabled = true
+	users.Update(ctx, alice)
+
+	if _, err := s.Refresh(ctx, *acc); err != constant.ErrUserDisabled {
+		t.Errorf("Refresh of a disabled user = %v, want ErrUserDisabled", err)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/password"
+	"restapi/internal/tracing"
+
+	"gorm.io/gorm"
+)
+
+// Authenticator checks the password of a user against one backend.
+// ErrUserNameNotRegistered and ErrWrongPassword let the chain try the next
+// backend, other errors end the login.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*model.User, error)
+}
+
+// authenticatorChain tries the backends of AUTH_BACKENDS in order, or only
+// the AuthSource of the user when it has one.
+type authenticatorChain struct {
+	userRepo       repository.UserRepo
+	authenticators map[string]Authenticator
+}
+
+func NewAuthenticatorChain(userRepo repository.UserRepo, authenticators ...Authenticator) Authenticator {
+	chain := &authenticatorChain{userRepo, map[string]Authenticator{}}
+	for _, a := range authenticators {
+		chain.authenticators[a.Name()] = a
+	}
+
+	return chain
+}
+
+func (c *authenticatorChain) Name() string {
+	return "chain"
+}
+
+func (c *authenticatorChain) Authenticate(ctx context.Context, username, pw string) (*model.User, error) {
+	backends, err := config.Cfg().AuthBackendList()
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to read AUTH_BACKENDS")
+		return nil, constant.ErrServer
+	}
+
+	user, err := c.userRepo.GetByUsername(ctx, username)
+	if err == nil && user.AuthSource != "" {
+		backends = []string{user.AuthSource}
+	} else if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+		return nil, constant.ErrServer
+	}
+
+	// the most telling error is returned when every backend fails
+	var res error = constant.ErrUserNameNotRegistered
+	for _, backend := range backends {
+		a, ok := c.authenticators[backend]
+		if !ok {
+			logger.Ctx(ctx).Error().Str("backend", backend).Msg("authentication backend is not available")
+			continue
+		}
+
+		user, err := a.Authenticate(ctx, username, pw)
+		switch err {
+		case nil:
+			if user.IsDisabled {
+				metrics.AuthEvent(metrics.EventLoginFailed)
+				return nil, constant.ErrUserDisabled
+			}
+			return user, nil
+		case constant.ErrWrongPassword:
+			res = err
+		case constant.ErrServer:
+			if res != constant.ErrWrongPassword {
+				res = err
+			}
+		case constant.ErrUserNameNotRegistered:
+		default:
+			metrics.AuthEvent(metrics.EventLoginFailed)
+			return nil, err
+		}
+	}
+
+	metrics.AuthEvent(metrics.EventLoginFailed)
+	return nil, res
+}
+
+// localAuthenticator checks the password hashes of the users table.
+type localAuthenticator struct {
+	userRepo repository.UserRepo
+}
+
+func NewLocalAuthenticator(userRepo repository.UserRepo) Authenticator {
+	return &localAuthenticator{userRepo}
+}
+
+func (a *localAuthenticator) Name() string {
+	return config.AuthBackendLocal
+}
+
+// Authenticate rehashes and saves the password when the hash parameters
+// changed, the password itself does not change so neither its age nor its
+// history do.
+func (a *localAuthenticator) Authenticate(ctx context.Context, username, pw string) (*model.User, error) {
+	ctx, span := tracing.Start(ctx, "localAuthenticator.Authenticate")
+	defer span.End()
+
+	user, err := a.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNameNotRegistered
+		default:
+			logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+			return nil, constant.ErrServer
+		}
+	}
+
+	hasher := password.CurrentHasher()
+	_, hashSpan := tracing.Start(ctx, "password.Verify")
+	ok, err := hasher.Verify(pw, user.Password)
+	hashSpan.End()
+	if errors.Is(err, password.ErrPepperChanged) {
+		// a pepper missing from PASSWORD_PREVIOUS_PEPPERS, only a password
+		// reset lets the user in again
+		logger.Ctx(ctx).Warn().Err(err).Uint("user_id", user.ID).Msg("password hash pepper is unknown")
+		return nil, constant.ErrWrongPassword
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to verify password")
+		return nil, constant.ErrServer
+	} else if !ok {
+		return nil, constant.ErrWrongPassword
+	}
+
+	if hasher.NeedsRehash(user.Password) {
+		hash, err := hasher.Hash(pw)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to rehash password")
+			return user, nil
+		}
+
+		user.Password = hash
+		err = a.userRepo.Update(ctx, user)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to save rehashed password")
+		}
+	}
+
+	return user, nil
+}
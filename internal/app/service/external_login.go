@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/password"
+	"restapi/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// externalLoginTTL is how long the user has to log in at the provider.
+const externalLoginTTL = 10 * time.Minute
+
+// usernameAttempts bounds the usernames tried when provisioning a user whose
+// preferred username is taken.
+const usernameAttempts = 5
+
+type ExternalLoginService interface {
+	// Start returns the url of the provider to send the browser to and the
+	// state to keep in its session.
+	Start(ctx context.Context, req model.ExternalLoginRequest) (string, string, error)
+	Callback(ctx context.Context, req model.ExternalLoginCallbackRequest) (*model.ExternalLoginResponse, error)
+	Link(ctx context.Context, userID uint, provider, subject string) error
+}
+
+type externalLoginService struct {
+	userRepo     repository.UserRepo
+	identityRepo repository.UserIdentityRepo
+	authRepo     repository.AuthRepo
+	authService  AuthService
+	auditService AuditService
+}
+
+func NewExternalLoginService(
+	userRepo repository.UserRepo,
+	identityRepo repository.UserIdentityRepo,
+	authRepo repository.AuthRepo,
+	authService AuthService,
+	auditService AuditService) ExternalLoginService {
+	return &externalLoginService{userRepo, identityRepo, authRepo, authService, auditService}
+}
+
+func (s *externalLoginService) Start(ctx context.Context, req model.ExternalLoginRequest) (string, string, error) {
+	ctx, span := tracing.Start(ctx, "externalLoginService.Start")
+	defer span.End()
+
+	if req.ReturnTo != "" && !allowedReturnTo(req.ReturnTo) {
+		return "", "", constant.ErrInvalidReturnTo
+	}
+
+	provider, err := lookupProvider(ctx, req.Provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := oidc.RandomString(32)
+	data := &model.ExternalLoginState{
+		Provider:     provider.Config.Name,
+		Nonce:        oidc.RandomString(32),
+		CodeVerifier: oidc.RandomString(48),
+		ReturnTo:     req.ReturnTo,
+		ForceLogin:   req.ForceLogin,
+	}
+
+	redirect, err := provider.AuthCodeURL(ctx, callbackURL(provider.Config.Name), state, data.Nonce, data.CodeVerifier, req.ForceLogin)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Str("provider", provider.Config.Name).Msg("failed to discover identity provider")
+		return "", "", constant.ErrExternalLogin
+	}
+
+	err = s.authRepo.CreateState(ctx, state, data, externalLoginTTL)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store external login state")
+		return "", "", constant.ErrServer
+	}
+
+	return redirect, state, nil
+}
+
+// Callback finishes a login started with Start: the code is exchanged, the
+// id_token verified and the linked user, provisioned on its first login,
+// gets a session.
+func (s *externalLoginService) Callback(ctx context.Context, req model.ExternalLoginCallbackRequest) (*model.ExternalLoginResponse, error) {
+	ctx, span := tracing.Start(ctx, "externalLoginService.Callback")
+	defer span.End()
+
+	// the state must be the one of this browser, otherwise an attacker could
+	// log the victim in to the attacker's account
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(req.State), []byte(req.SessionState)) != 1 {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrLoginState
+	}
+
+	state, err := s.authRepo.TakeState(ctx, req.State)
+	if err != nil || state.Provider != req.Provider {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrLoginState
+	}
+	res := &model.ExternalLoginResponse{ReturnTo: state.ReturnTo}
+
+	event := model.AuditEvent{
+		Action:    AuditExternalLogin,
+		ActorType: model.ActorUser,
+		Target:    state.Provider,
+		IP:        req.IP,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	if req.Error != "" {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		event.Detail = fmt.Sprintf("error=%q", req.Error)
+		if req.Error == constant.ErrAccessDenied.Code {
+			return res, constant.ErrAccessDenied
+		}
+		return res, constant.ErrExternalLogin
+	}
+
+	provider, err := lookupProvider(ctx, state.Provider)
+	if err != nil {
+		return res, err
+	}
+
+	claims, err := s.verify(ctx, provider, req.Code, state)
+	if err != nil {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		event.Detail = err.Error()
+		logger.Ctx(ctx).Err(err).Str("provider", state.Provider).Msg("failed to verify external login")
+		return res, constant.ErrExternalLogin
+	}
+	event.Target = state.Provider + ":" + claims.Subject()
+
+	role, ok := provider.Config.RoleMappings.Role(claims.Strings(provider.Config.RoleClaim))
+	if !ok {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		event.Detail = fmt.Sprintf("%s=%q", provider.Config.RoleClaim, claims.Strings(provider.Config.RoleClaim))
+		return res, constant.ErrNoRoleMapping
+	}
+
+	user, identity, err := s.user(ctx, provider.Config.Name, claims, role)
+	if err != nil {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return res, err
+	}
+	event.ActorID = strconv.Itoa(int(user.ID))
+
+	err = s.identityRepo.Touch(ctx, identity, claims.String("email"), time.Now())
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to record identity login")
+	}
+
+	res.AuthResponse, err = s.authService.StartSession(ctx, user, state.ForceLogin)
+	if err != nil {
+		return res, err
+	}
+	event.Success = true
+
+	return res, nil
+}
+
+// Link attaches the account subject of provider to an existing user, who
+// can then log in there instead of with a password.
+func (s *externalLoginService) Link(ctx context.Context, userID uint, provider, subject string) error {
+	ctx, span := tracing.Start(ctx, "externalLoginService.Link")
+	defer span.End()
+
+	if _, err := lookupProvider(ctx, provider); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.Get(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return constant.ErrUserNotFound
+		default:
+			return constant.ErrServer
+		}
+	}
+
+	_, err = s.identityRepo.Get(ctx, provider, subject)
+	if err == nil {
+		return constant.ErrIdentityLinked
+	} else if err != gorm.ErrRecordNotFound {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user identity")
+		return constant.ErrServer
+	}
+
+	err = s.identityRepo.Create(ctx, &model.UserIdentity{UserID: user.ID, Provider: provider, Subject: subject})
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to link identity")
+		return constant.ErrServer
+	}
+
+	s.auditService.Record(ctx, model.AuditEvent{
+		Action:    AuditIdentityLink,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(user.ID)),
+		Target:    provider + ":" + subject,
+		Success:   true,
+	})
+
+	return nil
+}
+
+func (s *externalLoginService) verify(ctx context.Context, provider *oidc.Provider, code string, state *model.ExternalLoginState) (oidc.Claims, error) {
+	if code == "" {
+		return nil, errors.New("callback has no code")
+	}
+
+	idToken, err := provider.Exchange(ctx, callbackURL(provider.Config.Name), code, state.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Verify(ctx, idToken, state.Nonce)
+}
+
+// user returns the user linked to the identity of claims, provisioning it
+// on the first login. The role of the user follows the mapping.
+func (s *externalLoginService) user(ctx context.Context, provider string, claims oidc.Claims, role string) (*model.User, *model.UserIdentity, error) {
+	identity, err := s.identityRepo.Get(ctx, provider, claims.Subject())
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		return s.provision(ctx, provider, claims, role)
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get user identity")
+		return nil, nil, constant.ErrServer
+	}
+
+	user, err := s.userRepo.Get(ctx, identity.UserID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, nil, constant.ErrUserNotFound
+		default:
+			return nil, nil, constant.ErrServer
+		}
+	}
+
+	if user.Role != role {
+		user.Role = role
+		err = s.userRepo.Update(ctx, user)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to sync role of user")
+			return nil, nil, constant.ErrServer
+		}
+	}
+
+	return user, identity, nil
+}
+
+// provision creates the user of a first login. Its password is random, it
+// can only log in with the provider until an admin resets it.
+func (s *externalLoginService) provision(ctx context.Context, provider string, claims oidc.Claims, role string) (*model.User, *model.UserIdentity, error) {
+	hash, err := password.CurrentHasher().Hash(oidc.RandomString(32))
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to hash password")
+		return nil, nil, constant.ErrServer
+	}
+
+	base := usernameBase(claims)
+	for i := 0; i < usernameAttempts; i++ {
+		username := base
+		if i > 0 || len(base) < 4 {
+			username = usernameVariant(base)
+		}
+
+		_, err = s.userRepo.GetByUsername(ctx, username)
+		if err == nil {
+			continue
+		} else if err != gorm.ErrRecordNotFound {
+			logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+			return nil, nil, constant.ErrServer
+		}
+
+		user := &model.User{Username: username, Password: hash, Role: role}
+		identity := &model.UserIdentity{Provider: provider, Subject: claims.Subject(), Email: claims.String("email")}
+		err = s.identityRepo.CreateWithUser(ctx, user, identity)
+		if err == nil {
+			logger.Ctx(ctx).Info().Str("provider", provider).Str("username", username).Msg("provisioned user of identity provider")
+			return user, identity, nil
+		}
+
+		// a concurrent login of the same identity may have won the race
+		if identity, err := s.identityRepo.Get(ctx, provider, claims.Subject()); err == nil {
+			user, err := s.userRepo.Get(ctx, identity.UserID)
+			if err != nil {
+				return nil, nil, constant.ErrServer
+			}
+			return user, identity, nil
+		}
+	}
+
+	logger.Ctx(ctx).Err(err).Str("provider", provider).Msg("failed to provision user of identity provider")
+	return nil, nil, constant.ErrServer
+}
+
+// usernameBase derives a username from the claims of the provider, it is
+// cut to the letters the username rule allows.
+func usernameBase(claims oidc.Claims) string {
+	for _, candidate := range []string{
+		claims.String("preferred_username"),
+		strings.SplitN(claims.String("email"), "@", 2)[0],
+		claims.String("name"),
+	} {
+		var b strings.Builder
+		for _, r := range strings.ToLower(candidate) {
+			if r < unicode.MaxASCII && unicode.IsLetter(r) && b.Len() < 10 {
+				b.WriteRune(r)
+			}
+		}
+		if b.Len() > 0 {
+			return b.String()
+		}
+	}
+
+	return "user"
+}
+
+// usernameVariant appends random letters to a prefix of base, keeping the
+// 4 to 10 letters of the username rule.
+func usernameVariant(base string) string {
+	if len(base) > 6 {
+		base = base[:6]
+	}
+
+	n := 4 - len(base)
+	if n < 3 {
+		n = 3
+	}
+
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	random := oidc.RandomString(n)
+	var b strings.Builder
+	b.WriteString(base)
+	for i := 0; i < n; i++ {
+		b.WriteByte(letters[int(random[i])%len(letters)])
+	}
+
+	return b.String()
+}
+
+func lookupProvider(ctx context.Context, name string) (*oidc.Provider, error) {
+	provider, err := oidc.Lookup(name)
+	switch err {
+	case nil:
+		return provider, nil
+	case oidc.ErrProviderNotFound:
+		return nil, constant.ErrProviderNotFound
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to read identity providers")
+		return nil, constant.ErrServer
+	}
+}
+
+// callbackURL is the redirect uri registered at the provider.
+func callbackURL(provider string) string {
+	return config.Cfg().OIDCIssuerURL() + "/api/login/oidc/" + url.PathEscape(provider) + "/callback"
+}
+
+// allowedReturnTo reports whether returnTo is a page of a WHITELISTHOST
+// origin, the tokens are sent there.
+func allowedReturnTo(returnTo string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil {
+		return false
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	for _, host := range strings.Split(config.Cfg().WhitelistHost, ",") {
+		if strings.TrimSpace(host) == origin {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/token"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// testIdP is an OpenID Connect provider on httptest which logs in the user
+// of claims, it checks the client, redirect uri and PKCE verifier like a
+// real one.
+type testIdP struct {
+	*httptest.Server
+	issuer string
+	signer *token.IDTokenSigner
+
+	mu     sync.Mutex
+	claims jwt.MapClaims
+	codes  map[string]url.Values
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdP{
+		signer: token.NewIDTokenSigner(key),
+		claims: jwt.MapClaims{"sub": "subject-1", "preferred_username": "mockuser", "email": "mockuser@example.com", "groups": []string{"users"}},
+		codes:  map[string]url.Values{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                 idp.issuer,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, idp.signer.JWKS())
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	idp.issuer = idp.URL
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+func (idp *testIdP) setClaims(claims jwt.MapClaims) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	for k, v := range claims {
+		idp.claims[k] = v
+	}
+}
+
+func (idp *testIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != "restapi" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "unexpected client", http.StatusBadRequest)
+		return
+	}
+
+	code := oidc.RandomString(16)
+	idp.mu.Lock()
+	idp.codes[code] = q
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if r.ParseForm() != nil || !ok || id != "restapi" || secret != "secret" {
+		writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	authorize, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	if !ok || authorize.Get("redirect_uri") != r.PostForm.Get("redirect_uri") ||
+		authorize.Get("code_challenge") != oidc.Challenge(r.PostForm.Get("code_verifier")) {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   idp.issuer,
+		"aud":   "restapi",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": authorize.Get("nonce"),
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	idToken, err := idp.signer.Sign(claims)
+	if err != nil {
+		writeTestJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, map[string]string{"id_token": idToken})
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type externalLoginTest struct {
+	idp        *testIdP
+	users      *fakeUserRepo
+	identities *fakeIdentityRepo
+	auth       *fakeAuthRepo
+	creds      *fakeCredentialRepo
+	audit      *fakeAudit
+	service    ExternalLoginService
+}
+
+func newExternalLoginTest(t *testing.T) *externalLoginTest {
+	idp := newTestIdP(t)
+	loadConfig(t, map[string]string{
+		"OIDC_PROVIDERS":              "mock=" + idp.URL,
+		"OIDC_PROVIDER_CREDENTIALS":   "mock=restapi:secret",
+		"OIDC_PROVIDER_ROLE_CLAIMS":   "mock=groups",
+		"OIDC_PROVIDER_ROLE_MAPPINGS": "mock:admins=admin,mock:users=user",
+	})
+
+	users := newFakeUserRepo()
+	identities := &fakeIdentityRepo{users: users}
+	auth := newFakeAuthRepo()
+	creds := &fakeCredentialRepo{}
+	audit := &fakeAudit{}
+	authService := NewAuthService(users, auth, token.NewToken(), NewLocalAuthenticator(users), creds)
+
+	return &externalLoginTest{
+		idp:        idp,
+		users:      users,
+		identities: identities,
+		auth:       auth,
+		creds:      creds,
+		audit:      audit,
+		service:    NewExternalLoginService(users, identities, auth, authService, audit),
+	}
+}
+
+// authorize starts a login and follows the browser to the provider, it
+// returns the state kept in the session and the code of the redirect back.
+func (e *externalLoginTest) authorize(t *testing.T, forceLogin bool) (string, string) {
+	t.Helper()
+
+	redirect, state, err := e.service.Start(context.Background(), model.ExternalLoginRequest{Provider: "mock", ForceLogin: forceLogin})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("provider did not redirect back with a code: %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("provider returned state %q, want %q", callback.Query().Get("state"), state)
+	}
+
+	return state, callback.Query().Get("code")
+}
+
+func (e *externalLoginTest) callback(state, code string) (*model.ExternalLoginResponse, error) {
+	return e.service.Callback(context.Background(), model.ExternalLoginCallbackRequest{
+		Provider:     "mock",
+		State:        state,
+		SessionState: state,
+		Code:         code,
+	})
+}
+
+// tamper changes the stored state of a started login.
+func (e *externalLoginTest) tamper(t *testing.T, state string, change func(*model.ExternalLoginState)) {
+	t.Helper()
+
+	data, err := e.auth.TakeState(context.Background(), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(data)
+	if err := e.auth.CreateState(context.Background(), state, data, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalLoginStart(t *testing.T) {
+	e := newExternalLoginTest(t)
+
+	redirect, state, err := e.service.Start(context.Background(), model.ExternalLoginRequest{Provider: "mock"})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	stored, err := e.auth.TakeState(context.Background(), state)
+	if err != nil {
+		t.Fatalf("state was not stored: %v", err)
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if got := u.Scheme + "://" + u.Host + u.Path; got != e.idp.URL+"/authorize" {
+		t.Errorf("redirect to %s, want the discovered authorization endpoint", got)
+	}
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "restapi",
+		"redirect_uri":          callbackURL("mock"),
+		"state":                 state,
+		"nonce":                 stored.Nonce,
+		"code_challenge":        oidc.Challenge(stored.CodeVerifier),
+		"code_challenge_method": "S256",
+	} {
+		if q.Get(param) != want {
+			t.Errorf("%s = %q, want %q", param, q.Get(param), want)
+		}
+	}
+	if q.Get("code_challenge") == stored.CodeVerifier {
+		t.Error("the PKCE verifier is sent in the clear")
+	}
+
+	_, _, err = e.service.Start(context.Background(), model.ExternalLoginRequest{Provider: "other"})
+	if err != constant.ErrProviderNotFound {
+		t.Errorf("Start of an unknown provider = %v, want ErrProviderNotFound", err)
+	}
+}
+
+func TestExternalLoginStartRefusesIssuerMismatch(t *testing.T) {
+	e := newExternalLoginTest(t)
+	e.idp.issuer = "https://attacker.example"
+
+	_, _, err := e.service.Start(context.Background(), model.ExternalLoginRequest{Provider: "mock"})
+	if err != constant.ErrExternalLogin {
+		t.Errorf("Start = %v, want ErrExternalLogin", err)
+	}
+}
+
+func TestExternalLoginCallbackState(t *testing.T) {
+	e := newExternalLoginTest(t)
+	state, code := e.authorize(t, false)
+
+	_, err := e.service.Callback(context.Background(), model.ExternalLoginCallbackRequest{
+		Provider:     "mock",
+		State:        state,
+		SessionState: "state-of-another-browser",
+		Code:         code,
+	})
+	if err != constant.ErrLoginState {
+		t.Fatalf("Callback with the state of another session = %v, want ErrLoginState", err)
+	}
+
+	_, err = e.callback(state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+
+	_, err = e.callback(state, code)
+	if err != constant.ErrLoginState {
+		t.Errorf("replayed Callback = %v, want ErrLoginState", err)
+	}
+}
+
+func TestExternalLoginCallbackNonceMismatch(t *testing.T) {
+	e := newExternalLoginTest(t)
+	state, code := e.authorize(t, false)
+	e.tamper(t, state, func(s *model.ExternalLoginState) { s.Nonce = "another-nonce" })
+
+	_, err := e.callback(state, code)
+	if err != constant.ErrExternalLogin {
+		t.Errorf("Callback = %v, want ErrExternalLogin", err)
+	}
+	if _, err := e.users.GetByUsername(context.Background(), "mockuser"); err == nil {
+		t.Error("a user was provisioned from an id_token of another login")
+	}
+}
+
+func TestExternalLoginCallbackPKCE(t *testing.T) {
+	e := newExternalLoginTest(t)
+	state, code := e.authorize(t, false)
+	e.tamper(t, state, func(s *model.ExternalLoginState) { s.CodeVerifier = oidc.RandomString(48) })
+
+	_, err := e.callback(state, code)
+	if err != constant.ErrExternalLogin {
+		t.Errorf("Callback with another code verifier = %v, want ErrExternalLogin", err)
+	}
+}
+
+func TestExternalLoginProvisionsUser(t *testing.T) {
+	e := newExternalLoginTest(t)
+	e.idp.setClaims(jwt.MapClaims{"groups": []string{"staff", "admins"}})
+
+	state, code := e.authorize(t, false)
+	res, err := e.callback(state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" {
+		t.Error("Callback did not start a session")
+	}
+
+	user, err := e.users.GetByUsername(context.Background(), "mockuser")
+	if err != nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if user.Role != "admin" {
+		t.Errorf("role = %q, want the admin role mapped from the admins group", user.Role)
+	}
+	identity, err := e.identities.Get(context.Background(), "mock", "subject-1")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity is not linked to the provisioned user: %v", err)
+	}
+
+	// the role follows the groups on every login, no second user is made
+	e.idp.setClaims(jwt.MapClaims{"groups": []string{"users"}})
+	state, code = e.authorize(t, true)
+	if _, err := e.callback(state, code); err != nil {
+		t.Fatalf("second Callback: %v", err)
+	}
+	user, _ = e.users.Get(context.Background(), user.ID)
+	if user.Role != "user" {
+		t.Errorf("role after the groups changed = %q, want user", user.Role)
+	}
+	if len(e.users.users) != 1 {
+		t.Errorf("%d users after two logins of one identity, want 1", len(e.users.users))
+	}
+}
+
+func TestExternalLoginWithoutRoleMapping(t *testing.T) {
+	e := newExternalLoginTest(t)
+	e.idp.setClaims(jwt.MapClaims{"groups": []string{"guests"}})
+
+	state, code := e.authorize(t, false)
+	_, err := e.callback(state, code)
+	if err != constant.ErrNoRoleMapping {
+		t.Errorf("Callback = %v, want ErrNoRoleMapping", err)
+	}
+	if len(e.users.users) != 0 {
+		t.Error("a user without a mapped role was provisioned")
+	}
+}
+
+func TestExternalLoginLinkedIdentity(t *testing.T) {
+	e := newExternalLoginTest(t)
+	alice := &model.User{Username: "alice", Role: "user"}
+	if err := e.users.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+
+	err := e.service.Link(context.Background(), alice.ID, "mock", "subject-1")
+	if err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if actions := e.audit.actions(); len(actions) != 1 || actions[0] != AuditIdentityLink {
+		t.Errorf("audited %v, want the link", actions)
+	}
+
+	err = e.service.Link(context.Background(), alice.ID, "mock", "subject-1")
+	if err != constant.ErrIdentityLinked {
+		t.Errorf("second Link = %v, want ErrIdentityLinked", err)
+	}
+
+	state, code := e.authorize(t, false)
+	if _, err := e.callback(state, code); err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	user, _ := e.users.Get(context.Background(), alice.ID)
+	if !user.IsLogin {
+		t.Error("the linked user was not logged in")
+	}
+	if len(e.users.users) != 1 {
+		t.Error("a user was provisioned for a linked identity")
+	}
+}
+
+func TestExternalLoginAsksForPasskey(t *testing.T) {
+	e := newExternalLoginTest(t)
+	alice := &model.User{Username: "alice", Role: "user"}
+	if err := e.users.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.service.Link(context.Background(), alice.ID, "mock", "subject-1"); err != nil {
+		t.Fatal(err)
+	}
+	e.creds.Create(context.Background(), &model.WebAuthnCredential{UserID: alice.ID, CredentialID: "credential"})
+
+	// the provider replaces the password, not the passkey
+	state, code := e.authorize(t, false)
+	res, err := e.callback(state, code)
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	if !res.PasskeyRequired || res.LoginToken == "" || res.AccessToken != "" {
+		t.Fatalf("Callback = %+v, want the passkey asked for instead of tokens", res.AuthResponse)
+	}
+	if _, err := e.auth.GetPendingLogin(context.Background(), res.LoginToken); err != nil {
+		t.Errorf("the login is not pending the passkey: %v", err)
+	}
+
+	user, _ := e.users.Get(context.Background(), alice.ID)
+	if user.IsLogin {
+		t.Error("the provider logged in without the passkey")
+	}
+}
@@ -0,0 +1,614 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"restapi/internal/app/model"
+	"restapi/internal/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// loadConfig loads a config file of values on top of the defaults, with the
+// JWT keys every session needs.
+func loadConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	all := map[string]string{
+		"APP_ENV":         config.EnvDevelopment,
+		"JWT_SECRET_KEY":  "test-access-secret",
+		"JWT_REFRESH_KEY": "test-refresh-secret",
+	}
+	for k, v := range values {
+		all[k] = v
+	}
+
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, strconv.Quote(all[k]))
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeUserRepo keeps users in memory, it hands out copies like a database
+// would.
+type fakeUserRepo struct {
+	mu     sync.Mutex
+	users  map[uint]*model.User
+	nextID uint
+}
+
+func newFakeUserRepo() *fakeUserRepo {
+	return &fakeUserRepo{users: map[uint]*model.User{}}
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Username == user.Username {
+			return fmt.Errorf("duplicate username %q", user.Username)
+		}
+	}
+
+	r.nextID++
+	user.ID = r.nextID
+	saved := *user
+	r.users[user.ID] = &saved
+	return nil
+}
+
+func (r *fakeUserRepo) Get(ctx context.Context, id uint) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *u
+	return &found, nil
+}
+
+func (r *fakeUserRepo) GetByUsername(ctx context.Context, username string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Username == username {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) Update(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	saved := *user
+	r.users[user.ID] = &saved
+	return nil
+}
+
+func (r *fakeUserRepo) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+// fakeAuthRepo keeps the Redis keys of AuthRepo in memory, missing keys
+// answer goredis.Nil. Expiry is not simulated.
+type fakeAuthRepo struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newFakeAuthRepo() *fakeAuthRepo {
+	return &fakeAuthRepo{values: map[string][]byte{}}
+}
+
+func (r *fakeAuthRepo) set(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[key] = b
+	return nil
+}
+
+func (r *fakeAuthRepo) get(key string, v interface{}, del bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.values[key]
+	if !ok {
+		return goredis.Nil
+	}
+	if del {
+		delete(r.values, key)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (r *fakeAuthRepo) has(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.values[key]
+	return ok
+}
+
+func (r *fakeAuthRepo) CreateAuth(ctx context.Context, authD map[string]interface{}, td *model.TokenDetails) error {
+	if err := r.set(td.TokenUuid, authD); err != nil {
+		return err
+	}
+	if td.RefreshUuid != "" {
+		return r.set(td.RefreshUuid, authD)
+	}
+	return nil
+}
+
+func (r *fakeAuthRepo) FetchAuth(ctx context.Context, tokenUuid string) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	err := r.get(tokenUuid, &data, false)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) DeleteRefresh(ctx context.Context, refreshUuid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.values, refreshUuid)
+	return nil
+}
+
+func (r *fakeAuthRepo) DeleteTokens(ctx context.Context, authD *model.AccessDetails) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.values, authD.TokenUuid)
+	delete(r.values, fmt.Sprintf("%s++%v%s", authD.TokenUuid, authD.UserId, authD.Username))
+	return nil
+}
+
+func (r *fakeAuthRepo) TTL(ctx context.Context, tokenUuid string) (time.Duration, error) {
+	if !r.has(tokenUuid) {
+		return -2, nil
+	}
+	return time.Hour, nil
+}
+
+func (r *fakeAuthRepo) CreateCode(ctx context.Context, code string, data *model.AuthorizationCode, ttl time.Duration) error {
+	return r.set("oauth:code:"+code, data)
+}
+
+func (r *fakeAuthRepo) TakeCode(ctx context.Context, code string) (*model.AuthorizationCode, error) {
+	data := new(model.AuthorizationCode)
+	if err := r.get("oauth:code:"+code, data, true); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) CreateState(ctx context.Context, state string, data *model.ExternalLoginState, ttl time.Duration) error {
+	return r.set("oidc:state:"+state, data)
+}
+
+func (r *fakeAuthRepo) TakeState(ctx context.Context, state string) (*model.ExternalLoginState, error) {
+	data := new(model.ExternalLoginState)
+	if err := r.get("oidc:state:"+state, data, true); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) CreateMagicLink(ctx context.Context, key string, data *model.MagicLink, ttl time.Duration) error {
+	return r.set("magic:link:"+key, data)
+}
+
+func (r *fakeAuthRepo) TakeMagicLink(ctx context.Context, key string) (*model.MagicLink, error) {
+	data := new(model.MagicLink)
+	if err := r.get("magic:link:"+key, data, true); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) MarkMagicLinkSent(ctx context.Context, userID uint, interval time.Duration) (bool, error) {
+	key := fmt.Sprintf("magic:sent:%d", userID)
+	if r.has(key) {
+		return false, nil
+	}
+	return true, r.set(key, 1)
+}
+
+func (r *fakeAuthRepo) CreateChallenge(ctx context.Context, challenge string, data *model.WebAuthnChallenge, ttl time.Duration) error {
+	return r.set("webauthn:challenge:"+challenge, data)
+}
+
+func (r *fakeAuthRepo) TakeChallenge(ctx context.Context, challenge string) (*model.WebAuthnChallenge, error) {
+	data := new(model.WebAuthnChallenge)
+	if err := r.get("webauthn:challenge:"+challenge, data, true); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) CreatePendingLogin(ctx context.Context, loginToken string, data *model.PendingLogin, ttl time.Duration) error {
+	return r.set("webauthn:login:"+loginToken, data)
+}
+
+func (r *fakeAuthRepo) GetPendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error) {
+	data := new(model.PendingLogin)
+	if err := r.get("webauthn:login:"+loginToken, data, false); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) TakePendingLogin(ctx context.Context, loginToken string) (*model.PendingLogin, error) {
+	data := new(model.PendingLogin)
+	if err := r.get("webauthn:login:"+loginToken, data, true); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *fakeAuthRepo) CreateReauth(ctx context.Context, reauthToken string, userID uint, ttl time.Duration) error {
+	return r.set("webauthn:reauth:"+reauthToken, userID)
+}
+
+func (r *fakeAuthRepo) TakeReauth(ctx context.Context, reauthToken string) (uint, error) {
+	var userID uint
+	if err := r.get("webauthn:reauth:"+reauthToken, &userID, true); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// fakeIdentityRepo keeps user identities in memory next to a fakeUserRepo.
+type fakeIdentityRepo struct {
+	mu         sync.Mutex
+	users      *fakeUserRepo
+	identities []*model.UserIdentity
+}
+
+func (r *fakeIdentityRepo) Get(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.identities {
+		if i.Provider == provider && i.Subject == subject {
+			found := *i
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentityRepo) Create(ctx context.Context, identity *model.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity.ID = uint(len(r.identities) + 1)
+	saved := *identity
+	r.identities = append(r.identities, &saved)
+	return nil
+}
+
+func (r *fakeIdentityRepo) CreateWithUser(ctx context.Context, user *model.User, identity *model.UserIdentity) error {
+	err := r.users.Create(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+	return r.Create(ctx, identity)
+}
+
+func (r *fakeIdentityRepo) Touch(ctx context.Context, identity *model.UserIdentity, email string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.identities {
+		if i.ID == identity.ID {
+			i.Email, i.LastLoginAt = email, &at
+		}
+	}
+	return nil
+}
+
+// fakeAccessTokenRepo keeps personal access tokens in memory.
+type fakeAccessTokenRepo struct {
+	mu     sync.Mutex
+	tokens []*model.AccessToken
+}
+
+func (r *fakeAccessTokenRepo) Create(ctx context.Context, token *model.AccessToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = uint(len(r.tokens) + 1)
+	saved := *token
+	r.tokens = append(r.tokens, &saved)
+	return nil
+}
+
+func (r *fakeAccessTokenRepo) GetByLookupID(ctx context.Context, lookupID string) (*model.AccessToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.LookupID == lookupID {
+			found := *t
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeAccessTokenRepo) ListByUser(ctx context.Context, userID uint) ([]*model.AccessToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []*model.AccessToken
+	for _, t := range r.tokens {
+		if t.UserID == userID {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+func (r *fakeAccessTokenRepo) Delete(ctx context.Context, userID, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, t := range r.tokens {
+		if t.ID == id && t.UserID == userID {
+			r.tokens = append(r.tokens[:i], r.tokens[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *fakeAccessTokenRepo) Touch(ctx context.Context, id uint, at time.Time, ip string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.ID == id {
+			t.LastUsedAt, t.LastUsedIP = &at, ip
+		}
+	}
+	return nil
+}
+
+// fakeOAuthClientRepo keeps OAuth clients and consents in memory.
+type fakeOAuthClientRepo struct {
+	mu       sync.Mutex
+	clients  []*model.OAuthClient
+	consents []*model.OAuthConsent
+}
+
+func (r *fakeOAuthClientRepo) Create(ctx context.Context, client *model.OAuthClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client.ID = uint(len(r.clients) + 1)
+	saved := *client
+	r.clients = append(r.clients, &saved)
+	return nil
+}
+
+func (r *fakeOAuthClientRepo) GetByClientID(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.clients {
+		if c.ClientID == clientID {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthClientRepo) List(ctx context.Context) ([]*model.OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*model.OAuthClient(nil), r.clients...), nil
+}
+
+func (r *fakeOAuthClientRepo) Delete(ctx context.Context, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.clients {
+		if c.ClientID == clientID {
+			r.clients = append(r.clients[:i], r.clients[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthClientRepo) GetConsent(ctx context.Context, userID uint, clientID string) (*model.OAuthConsent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.consents {
+		if c.UserID == userID && c.ClientID == clientID {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeOAuthClientRepo) SaveConsent(ctx context.Context, consent *model.OAuthConsent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.consents {
+		if c.UserID == consent.UserID && c.ClientID == consent.ClientID {
+			c.Scopes = consent.Scopes
+			return nil
+		}
+	}
+	saved := *consent
+	r.consents = append(r.consents, &saved)
+	return nil
+}
+
+// fakeCredentialRepo keeps passkeys in memory. Touch refuses a counter
+// that does not move forward, like the conditional update of the database.
+type fakeCredentialRepo struct {
+	mu    sync.Mutex
+	creds []*model.WebAuthnCredential
+}
+
+func (r *fakeCredentialRepo) Create(ctx context.Context, cred *model.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred.ID = uint(len(r.creds) + 1)
+	saved := *cred
+	r.creds = append(r.creds, &saved)
+	return nil
+}
+
+func (r *fakeCredentialRepo) GetByCredentialID(ctx context.Context, credentialID string) (*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.creds {
+		if c.CredentialID == credentialID {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeCredentialRepo) ListByUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := []*model.WebAuthnCredential{}
+	for _, c := range r.creds {
+		if c.UserID == userID {
+			found := *c
+			res = append(res, &found)
+		}
+	}
+	return res, nil
+}
+
+func (r *fakeCredentialRepo) CountByUser(ctx context.Context, userID uint) (int64, error) {
+	creds, err := r.ListByUser(ctx, userID)
+	return int64(len(creds)), err
+}
+
+func (r *fakeCredentialRepo) Delete(ctx context.Context, userID, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.creds {
+		if c.ID == id && c.UserID == userID {
+			r.creds = append(r.creds[:i], r.creds[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *fakeCredentialRepo) Touch(ctx context.Context, cred *model.WebAuthnCredential, signCount int64, backedUp bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.creds {
+		if c.ID == cred.ID && (c.SignCount < signCount || c.SignCount == 0) {
+			c.SignCount, c.BackedUp, c.LastUsedAt = signCount, backedUp, &at
+			cred.SignCount, cred.BackedUp, cred.LastUsedAt = signCount, backedUp, &at
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// fakeAudit remembers the recorded events.
+type fakeAudit struct {
+	mu     sync.Mutex
+	events []model.AuditEvent
+}
+
+func (a *fakeAudit) Record(ctx context.Context, event model.AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events = append(a.events, event)
+}
+
+func (a *fakeAudit) actions() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	res := make([]string, len(a.events))
+	for i, e := range a.events {
+		res[i] = e.Action
+	}
+	return res
+}
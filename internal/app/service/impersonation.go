@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/token"
+	"restapi/internal/tracing"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+type ImpersonationService interface {
+	Impersonate(ctx context.Context, req model.ImpersonateRequest) (*model.ImpersonationResponse, error)
+	Stop(ctx context.Context, req model.StopImpersonationRequest) error
+}
+
+type impersonationService struct {
+	userRepo     repository.UserRepo
+	authRepo     repository.AuthRepo
+	auditService AuditService
+	tk           token.TokenInterface
+}
+
+func NewImpersonationService(
+	userRepo repository.UserRepo,
+	authRepo repository.AuthRepo,
+	auditService AuditService,
+	tk token.TokenInterface) ImpersonationService {
+	return &impersonationService{userRepo, authRepo, auditService, tk}
+}
+
+// Impersonate issues an access token of the user carrying the admin in its
+// act claim. It has no refresh token and leaves the session of the user
+// alone.
+func (s *impersonationService) Impersonate(ctx context.Context, req model.ImpersonateRequest) (*model.ImpersonationResponse, error) {
+	ctx, span := tracing.Start(ctx, "impersonationService.Impersonate")
+	defer span.End()
+
+	event := model.AuditEvent{
+		Action:    AuditImpersonationStart,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(req.ActorID)),
+		Target:    strconv.Itoa(int(req.UserID)),
+		IP:        req.IP,
+		Detail:    req.Reason,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	// service accounts have no user to answer for the requests
+	if req.ActorID == 0 {
+		return nil, constant.ErrForbidden
+	}
+
+	user, err := s.userRepo.Get(ctx, req.UserID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	// an admin acting as another admin would hide who did what
+	switch {
+	case user.ID == req.ActorID, user.Role == "admin":
+		return nil, constant.ErrImpersonationTarget
+	case user.IsDisabled:
+		return nil, constant.ErrUserDisabled
+	}
+
+	ttl := config.Cfg().ImpersonationTTL
+	claims := map[string]interface{}{
+		"user_id":   user.ID,
+		"username":  user.Username,
+		"user_role": user.Role,
+		"act": map[string]interface{}{
+			"sub":      strconv.Itoa(int(req.ActorID)),
+			"username": req.ActorUsername,
+		},
+	}
+	td, err := s.tk.CreateAccessToken(claims, ttl)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create impersonation token")
+		return nil, constant.ErrServer
+	}
+
+	// kept in redis like session tokens so it can be introspected and
+	// revoked
+	err = s.authRepo.CreateAuth(ctx, claims, td)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store impersonation token")
+		return nil, constant.ErrServer
+	}
+
+	metrics.AuthEvent(metrics.EventImpersonation)
+	event.Success = true
+
+	return &model.ImpersonationResponse{
+		AccessToken: td.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ttl / time.Second),
+		UserID:      user.ID,
+		Username:    user.Username,
+	}, nil
+}
+
+// Stop revokes an impersonation token before it expires. Any admin may stop
+// it, the admin who started it is kept in the audit detail.
+func (s *impersonationService) Stop(ctx context.Context, req model.StopImpersonationRequest) error {
+	ctx, span := tracing.Start(ctx, "impersonationService.Stop")
+	defer span.End()
+
+	event := model.AuditEvent{
+		Action:    AuditImpersonationStop,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(req.ActorID)),
+		IP:        req.IP,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	acc, err := s.tk.ParseAccessToken(req.Token)
+	if err != nil || acc.Actor == nil {
+		return constant.ErrImpersonationToken
+	}
+	event.Target = strconv.Itoa(int(acc.UserId))
+	event.Detail = "started by " + strconv.Itoa(int(acc.Actor.UserId))
+
+	_, err = s.authRepo.FetchAuth(ctx, acc.TokenUuid)
+	switch {
+	case err == goredis.Nil:
+		return constant.ErrImpersonationToken
+	case err != nil:
+		logger.Ctx(ctx).Err(err).Msg("failed to fetch impersonation token")
+		return constant.ErrServer
+	}
+
+	err = s.authRepo.DeleteTokens(ctx, acc)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to delete impersonation token")
+		return constant.ErrServer
+	}
+
+	event.Success = true
+	return nil
+}
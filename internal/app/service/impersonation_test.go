@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/security/token"
+	"testing"
+)
+
+func TestImpersonationStop(t *testing.T) {
+	loadConfig(t, nil)
+	users, auth, audit := newFakeUserRepo(), newFakeAuthRepo(), &fakeAudit{}
+	admin := createLocalUser(t, users, "admin", "admin-password")
+	admin.Role = "admin"
+	users.Update(context.Background(), admin)
+	alice := createLocalUser(t, users, "alice", "alice-password")
+
+	tk := token.NewToken()
+	s := NewImpersonationService(users, auth, audit, tk)
+	ctx := context.Background()
+
+	res, err := s.Impersonate(ctx, model.ImpersonateRequest{ActorID: admin.ID, ActorUsername: "admin", UserID: alice.ID, Reason: "support ticket"})
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+	acc, err := tk.ParseAccessToken(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Stop(ctx, model.StopImpersonationRequest{ActorID: admin.ID, Token: res.AccessToken}); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if auth.has(acc.TokenUuid) {
+		t.Error("the impersonation token is still in redis")
+	}
+	if err := s.Stop(ctx, model.StopImpersonationRequest{ActorID: admin.ID, Token: res.AccessToken}); err != constant.ErrImpersonationToken {
+		t.Errorf("second Stop = %v, want ErrImpersonationToken", err)
+	}
+
+	// the session of a user is not an impersonation
+	td, err := tk.CreateToken(map[string]interface{}{"user_id": alice.ID, "username": "alice", "user_role": "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(ctx, model.StopImpersonationRequest{ActorID: admin.ID, Token: td.AccessToken}); err != constant.ErrImpersonationToken {
+		t.Errorf("Stop of a session token = %v, want ErrImpersonationToken", err)
+	}
+
+	want := []string{AuditImpersonationStart, AuditImpersonationStop, AuditImpersonationStop, AuditImpersonationStop}
+	if got := audit.actions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("audited %v, want %v", got, want)
+	}
+	stop := audit.events[1]
+	if !stop.Success || stop.Target != fmt.Sprint(alice.ID) || stop.Detail != fmt.Sprintf("started by %d", admin.ID) {
+		t.Errorf("stop audited as %+v", stop)
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/security/ldap"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/password"
+	"restapi/internal/tracing"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ldapAuthenticator binds as the user found with LDAP_USER_FILTER. Users
+// are provisioned on their first login, their role and email follow the
+// directory on every login.
+type ldapAuthenticator struct {
+	userRepo repository.UserRepo
+}
+
+func NewLDAPAuthenticator(userRepo repository.UserRepo) Authenticator {
+	return &ldapAuthenticator{userRepo}
+}
+
+func (a *ldapAuthenticator) Name() string {
+	return config.AuthBackendLDAP
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, username, pw string) (*model.User, error) {
+	ctx, span := tracing.Start(ctx, "ldapAuthenticator.Authenticate")
+	defer span.End()
+
+	cfg := config.Cfg()
+	entry, err := a.bind(ctx, cfg, username, pw)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := cfg.LDAPRoleMappings()
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to read LDAP_ROLE_MAPPINGS")
+		return nil, constant.ErrServer
+	}
+
+	groups := entry.Values(cfg.LDAPGroupAttribute)
+	for i := range groups {
+		groups[i] = strings.ToLower(groups[i])
+	}
+	role, ok := mappings.Role(groups)
+	if !ok {
+		logger.Ctx(ctx).Warn().Str("dn", entry.DN).Msg("ldap user has no mapped group")
+		return nil, constant.ErrNoRoleMapping
+	}
+
+	return a.sync(ctx, username, role, entry.Value(cfg.LDAPEmailAttribute))
+}
+
+// bind finds the entry of username and checks pw with a bind as it.
+func (a *ldapAuthenticator) bind(ctx context.Context, cfg *config.Config, username, pw string) (*ldap.Entry, error) {
+	if pw == "" {
+		return nil, constant.ErrWrongPassword
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.LDAPInsecureSkipVerify}
+	conn, err := ldap.Dial(ctx, cfg.LDAPURL, tlsConfig, cfg.LDAPTimeout)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to connect to ldap")
+		return nil, constant.ErrServer
+	}
+	defer conn.Close()
+
+	if cfg.LDAPStartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to start tls with ldap")
+			return nil, constant.ErrServer
+		}
+	}
+
+	if cfg.LDAPBindDN != "" {
+		err = conn.Bind(cfg.LDAPBindDN, cfg.LDAPBindPassword)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to bind to ldap with LDAP_BIND_DN")
+			return nil, constant.ErrServer
+		}
+	}
+
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     cfg.LDAPBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     fmt.Sprintf(cfg.LDAPUserFilter, ldap.EscapeFilter(username)),
+		Attributes: []string{cfg.LDAPGroupAttribute, cfg.LDAPEmailAttribute},
+		SizeLimit:  2,
+	})
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to search ldap")
+		return nil, constant.ErrServer
+	}
+
+	switch len(entries) {
+	case 0:
+		return nil, constant.ErrUserNameNotRegistered
+	case 1:
+	default:
+		logger.Ctx(ctx).Error().Str("username", username).Msg("LDAP_USER_FILTER matches several ldap entries")
+		return nil, constant.ErrServer
+	}
+
+	err = conn.Bind(entries[0].DN, pw)
+	if ldap.IsInvalidCredentials(err) {
+		return nil, constant.ErrWrongPassword
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to bind to ldap as the user")
+		return nil, constant.ErrServer
+	}
+
+	return entries[0], nil
+}
+
+// sync provisions or updates the local user of an ldap login.
+func (a *ldapAuthenticator) sync(ctx context.Context, username, role, email string) (*model.User, error) {
+	user, err := a.userRepo.GetByUsername(ctx, username)
+	switch err {
+	case nil:
+		// a local account of the same name is not taken over, an admin
+		// has to set its auth source to ldap
+		if user.AuthSource != config.AuthBackendLDAP {
+			logger.Ctx(ctx).Warn().Str("username", username).Msg("ldap login matches a local account")
+			return nil, constant.ErrWrongPassword
+		}
+		if user.Role == role && user.Email == email {
+			return user, nil
+		}
+
+		user.Role, user.Email = role, email
+		err = a.userRepo.Update(ctx, user)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to sync ldap user")
+			return nil, constant.ErrServer
+		}
+
+		return user, nil
+	case gorm.ErrRecordNotFound:
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+		return nil, constant.ErrServer
+	}
+
+	// the local password is random, the directory checks the real one
+	hash, err := password.CurrentHasher().Hash(oidc.RandomString(32))
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to hash password")
+		return nil, constant.ErrServer
+	}
+
+	user = &model.User{
+		Username:   username,
+		Password:   hash,
+		Role:       role,
+		Email:      email,
+		AuthSource: config.AuthBackendLDAP,
+	}
+	err = a.userRepo.Create(ctx, user)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to provision ldap user")
+		return nil, constant.ErrServer
+	}
+	logger.Ctx(ctx).Info().Str("username", username).Msg("provisioned ldap user")
+
+	return user, nil
+}
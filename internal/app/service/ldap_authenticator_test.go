@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"restapi/internal/app/model"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/security/ldap"
+	"restapi/internal/security/password"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testBaseDN   = "dc=example,dc=com"
+	testAdmins   = "cn=admins,ou=groups," + testBaseDN
+	testStaff    = "cn=staff,ou=groups," + testBaseDN
+	testReaderDN = "cn=reader," + testBaseDN
+)
+
+// testDirectoryUser is an entry of the test directory.
+type testDirectoryUser struct {
+	uid, password, mail string
+	groups              []string
+}
+
+// newTestDirectory serves users on 127.0.0.1 and returns its url. A
+// non-nil tlsConfig answers StartTLS.
+func newTestDirectory(t *testing.T, tlsConfig *tls.Config, users ...testDirectoryUser) string {
+	t.Helper()
+
+	server := &ldap.Server{
+		Passwords: map[string]string{strings.ToLower(testReaderDN): "reader-secret"},
+		TLSConfig: tlsConfig,
+	}
+	for _, u := range users {
+		entry := &ldap.Entry{
+			DN: "uid=" + u.uid + ",ou=people," + testBaseDN,
+			Attributes: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {u.uid},
+				"mail":        {u.mail},
+				"memberOf":    u.groups,
+			},
+		}
+		server.Entries = append(server.Entries, entry)
+		server.Passwords[strings.ToLower(entry.DN)] = u.password
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	return "ldap://" + l.Addr().String()
+}
+
+func loadLDAPConfig(t *testing.T, url string, values map[string]string) {
+	t.Helper()
+
+	all := map[string]string{
+		"AUTH_BACKENDS":      "local,ldap",
+		"LDAP_URL":           url,
+		"LDAP_BIND_DN":       testReaderDN,
+		"LDAP_BIND_PASSWORD": "reader-secret",
+		"LDAP_BASE_DN":       testBaseDN,
+		"LDAP_ROLE_MAPPINGS": testAdmins + "=admin;" + testStaff + "=user",
+	}
+	for k, v := range values {
+		all[k] = v
+	}
+	loadConfig(t, all)
+}
+
+var (
+	jdoe  = testDirectoryUser{"j.doe", "jdoe-directory", "j.doe@example.com", []string{testAdmins}}
+	bob   = testDirectoryUser{"bob", "bob-directory", "bob@example.com", []string{testStaff}}
+	alice = testDirectoryUser{"alice", "alice-directory", "alice@example.com", []string{testStaff}}
+	guest = testDirectoryUser{"guest", "guest-directory", "guest@example.com", nil}
+)
+
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// createLocalUser stores a user of the local backend with password pw.
+func createLocalUser(t *testing.T, users *fakeUserRepo, username, pw string) *model.User {
+	t.Helper()
+
+	hash, err := password.CurrentHasher().Hash(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{Username: username, Password: hash, Role: "user"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestLDAPAuthenticatorBindAndSearch(t *testing.T) {
+	loadLDAPConfig(t, newTestDirectory(t, nil, jdoe, bob, guest), nil)
+	users := newFakeUserRepo()
+	a := NewLDAPAuthenticator(users)
+
+	user, err := a.Authenticate(context.Background(), "j.doe", "jdoe-directory")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Username != "j.doe" || user.AuthSource != config.AuthBackendLDAP {
+		t.Errorf("provisioned %q from %q, want j.doe from ldap", user.Username, user.AuthSource)
+	}
+	if user.Role != "admin" || user.Email != "j.doe@example.com" {
+		t.Errorf("role %q email %q, want the admin role of the admins group and the directory mail", user.Role, user.Email)
+	}
+	if _, err := users.GetByUsername(context.Background(), "j.doe"); err != nil {
+		t.Errorf("the ldap user was not stored: %v", err)
+	}
+
+	for _, c := range []struct {
+		username, password string
+		want               error
+	}{
+		{"j.doe", "wrong", constant.ErrWrongPassword},
+		{"j.doe", "", constant.ErrWrongPassword},
+		{"nobody", "anything", constant.ErrUserNameNotRegistered},
+		{"*", "jdoe-directory", constant.ErrUserNameNotRegistered},
+		{"guest", "guest-directory", constant.ErrNoRoleMapping},
+	} {
+		_, err := a.Authenticate(context.Background(), c.username, c.password)
+		if err != c.want {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", c.username, c.password, err, c.want)
+		}
+	}
+}
+
+func TestLDAPAuthenticatorServiceBind(t *testing.T) {
+	loadLDAPConfig(t, newTestDirectory(t, nil, bob), map[string]string{"LDAP_BIND_PASSWORD": "wrong"})
+
+	_, err := NewLDAPAuthenticator(newFakeUserRepo()).Authenticate(context.Background(), "bob", "bob-directory")
+	if err != constant.ErrServer {
+		t.Errorf("Authenticate with a wrong LDAP_BIND_PASSWORD = %v, want ErrServer", err)
+	}
+}
+
+func TestLDAPAuthenticatorStartTLS(t *testing.T) {
+	tlsConfig := selfSignedTLSConfig(t)
+
+	for _, c := range []struct {
+		name       string
+		serverTLS  *tls.Config
+		skipVerify string
+		want       error
+	}{
+		{"upgraded", tlsConfig, "true", nil},
+		{"untrusted certificate", tlsConfig, "false", constant.ErrServer},
+		{"not offered by the directory", nil, "true", constant.ErrServer},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			loadLDAPConfig(t, newTestDirectory(t, c.serverTLS, bob), map[string]string{
+				"LDAP_START_TLS":            "true",
+				"LDAP_INSECURE_SKIP_VERIFY": c.skipVerify,
+			})
+
+			_, err := NewLDAPAuthenticator(newFakeUserRepo()).Authenticate(context.Background(), "bob", "bob-directory")
+			if err != c.want {
+				t.Errorf("Authenticate = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorRoleMapping(t *testing.T) {
+	both := testDirectoryUser{"both", "both-directory", "both@example.com", []string{strings.ToUpper(testStaff), testAdmins}}
+	loadLDAPConfig(t, newTestDirectory(t, nil, both, guest), map[string]string{
+		"LDAP_ROLE_MAPPINGS": testAdmins + "=admin;" + testStaff + "=user;*=guest",
+	})
+	a := NewLDAPAuthenticator(newFakeUserRepo())
+
+	// mappings apply in order and compare group DNs without case
+	user, err := a.Authenticate(context.Background(), "both", "both-directory")
+	if err != nil || user.Role != "admin" {
+		t.Errorf("Authenticate = %v, want the admin role of the first mapping", err)
+	}
+
+	user, err = a.Authenticate(context.Background(), "guest", "guest-directory")
+	if err != nil || user.Role != "guest" {
+		t.Errorf("Authenticate of a user without groups = %v, want the role of the * mapping", err)
+	}
+}
+
+func TestLDAPAuthenticatorSyncsAttributes(t *testing.T) {
+	users := newFakeUserRepo()
+	a := NewLDAPAuthenticator(users)
+
+	loadLDAPConfig(t, newTestDirectory(t, nil, bob), nil)
+	first, err := a.Authenticate(context.Background(), "bob", "bob-directory")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// the directory moved bob to the admins with a new mail
+	moved := testDirectoryUser{"bob", "bob-directory", "robert@example.com", []string{testAdmins}}
+	loadLDAPConfig(t, newTestDirectory(t, nil, moved), nil)
+	if _, err := a.Authenticate(context.Background(), "bob", "bob-directory"); err != nil {
+		t.Fatalf("second Authenticate: %v", err)
+	}
+
+	user, _ := users.Get(context.Background(), first.ID)
+	if user.Role != "admin" || user.Email != "robert@example.com" {
+		t.Errorf("role %q email %q were not synced from the directory", user.Role, user.Email)
+	}
+	if len(users.users) != 1 {
+		t.Errorf("%d users after two logins, want 1", len(users.users))
+	}
+}
+
+func TestLDAPAuthenticatorRefusesLocalAccount(t *testing.T) {
+	loadLDAPConfig(t, newTestDirectory(t, nil, alice), nil)
+	users := newFakeUserRepo()
+	local := createLocalUser(t, users, "alice", "alice-local-password")
+
+	_, err := NewLDAPAuthenticator(users).Authenticate(context.Background(), "alice", "alice-directory")
+	if err != constant.ErrWrongPassword {
+		t.Errorf("Authenticate = %v, want ErrWrongPassword", err)
+	}
+
+	user, _ := users.Get(context.Background(), local.ID)
+	if user.AuthSource != "" || user.Email != "" || user.Password != local.Password {
+		t.Error("the directory took over the local account")
+	}
+}
+
+func TestAuthenticatorChainFallsThrough(t *testing.T) {
+	users := newFakeUserRepo()
+	carol := createLocalUser(t, users, "carol", "carol-local-password")
+	createLocalUser(t, users, "alice", "alice-local-password")
+	chain := NewAuthenticatorChain(users, NewLocalAuthenticator(users), NewLDAPAuthenticator(users))
+
+	loadLDAPConfig(t, newTestDirectory(t, nil, jdoe, alice), nil)
+	for _, c := range []struct {
+		username, password string
+		want               error
+	}{
+		// local first, the directory for users it does not know
+		{"carol", "carol-local-password", nil},
+		{"j.doe", "jdoe-directory", nil},
+		{"nobody", "anything", constant.ErrUserNameNotRegistered},
+		// the directory does not log in to a local account of its name
+		{"alice", "alice-directory", constant.ErrWrongPassword},
+		{"alice", "alice-local-password", nil},
+		// j.doe is now bound to ldap, the random local password is not tried
+		{"j.doe", "wrong", constant.ErrWrongPassword},
+	} {
+		_, err := chain.Authenticate(context.Background(), c.username, c.password)
+		if err != c.want {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", c.username, c.password, err, c.want)
+		}
+	}
+
+	// local users still log in while the directory is down
+	loadLDAPConfig(t, "ldap://127.0.0.1:1", map[string]string{"LDAP_TIMEOUT": "1s"})
+	if user, err := chain.Authenticate(context.Background(), "carol", "carol-local-password"); err != nil || user.ID != carol.ID {
+		t.Errorf("local Authenticate with the directory down = %v", err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "nobody", "anything"); err != constant.ErrServer {
+		t.Errorf("unknown user with the directory down = %v, want ErrServer", err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "j.doe", "jdoe-directory"); err != constant.ErrServer {
+		t.Errorf("ldap user with the directory down = %v, want ErrServer", err)
+	}
+}
+
+func TestLDAPUserPasswordDoesNotExpire(t *testing.T) {
+	users := newFakeUserRepo()
+	createLocalUser(t, users, "carol", "carol-local-password")
+	chain := NewAuthenticatorChain(users, NewLocalAuthenticator(users), NewLDAPAuthenticator(users))
+
+	loadLDAPConfig(t, newTestDirectory(t, nil, jdoe), map[string]string{"PASSWORD_MAX_AGE": "24h"})
+	for _, c := range []struct {
+		username, password string
+		expired            bool
+	}{
+		{"carol", "carol-local-password", true},
+		{"j.doe", "jdoe-directory", false},
+	} {
+		user, err := chain.Authenticate(context.Background(), c.username, c.password)
+		if err != nil {
+			t.Fatalf("Authenticate(%q): %v", c.username, err)
+		}
+		// both passwords were set long before PASSWORD_MAX_AGE
+		old := time.Now().Add(-48 * time.Hour)
+		user.PasswordChangedAt = &old
+
+		if got := passwordExpired(user); got != c.expired {
+			t.Errorf("passwordExpired(%s) = %v, want %v", c.username, got, c.expired)
+		}
+	}
+}
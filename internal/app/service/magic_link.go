@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/mail"
+	"restapi/internal/metrics"
+	"restapi/internal/security/oidc"
+	"restapi/internal/tracing"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// magicLinkInterval is the least time between two links sent to a user,
+// whatever the IP asking for them.
+const magicLinkInterval = time.Minute
+
+type MagicLinkService interface {
+	// Send mails a login link to the user when it may log in with one. The
+	// answer is the same either way so accounts cannot be enumerated.
+	Send(ctx context.Context, req model.MagicLinkRequest) error
+	Login(ctx context.Context, req model.MagicLinkCallbackRequest) (*model.MagicLinkResponse, error)
+}
+
+type magicLinkService struct {
+	userRepo     repository.UserRepo
+	authRepo     repository.AuthRepo
+	authService  AuthService
+	auditService AuditService
+	mailer       mail.Mailer
+}
+
+func NewMagicLinkService(
+	userRepo repository.UserRepo,
+	authRepo repository.AuthRepo,
+	authService AuthService,
+	auditService AuditService,
+	mailer mail.Mailer) MagicLinkService {
+	return &magicLinkService{userRepo, authRepo, authService, auditService, mailer}
+}
+
+func (s *magicLinkService) Send(ctx context.Context, req model.MagicLinkRequest) error {
+	ctx, span := tracing.Start(ctx, "magicLinkService.Send")
+	defer span.End()
+
+	if req.ReturnTo != "" && !allowedReturnTo(req.ReturnTo) {
+		return constant.ErrInvalidReturnTo
+	}
+
+	event := model.AuditEvent{
+		Action:    AuditMagicLinkSend,
+		ActorType: model.ActorUser,
+		Target:    req.Username,
+		IP:        req.IP,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	user, err := s.userRepo.GetByUsername(ctx, req.Username)
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		event.Detail = "username not registered"
+		return nil
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
+		return constant.ErrServer
+	}
+	event.ActorID = strconv.Itoa(int(user.ID))
+
+	switch {
+	case user.IsDisabled:
+		event.Detail = "user is disabled"
+		return nil
+	case !magicLinkAllowed(ctx, user.Role):
+		event.Detail = fmt.Sprintf("role %q may not use magic links", user.Role)
+		return nil
+	case user.Email == "":
+		event.Detail = "user has no email"
+		return nil
+	}
+
+	ok, err := s.authRepo.MarkMagicLinkSent(ctx, user.ID, magicLinkInterval)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to throttle magic link")
+		return constant.ErrServer
+	} else if !ok {
+		event.Detail = "a link was sent less than a minute ago"
+		return nil
+	}
+
+	cfg := config.Cfg()
+	token := oidc.RandomString(32)
+	err = s.authRepo.CreateMagicLink(ctx, magicLinkKey(token, req.Fingerprint), &model.MagicLink{
+		UserID:     user.ID,
+		ReturnTo:   req.ReturnTo,
+		ForceLogin: req.ForceLogin,
+	}, cfg.MagicLinkTTL)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store magic link")
+		return constant.ErrServer
+	}
+
+	link := cfg.OIDCIssuerURL() + "/api/login/magic/callback?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hello %s,\n\nopen this link to log in:\n\n%s\n\n"+
+			"It works once, for %s, and only in the browser it was requested from. "+
+			"If you did not ask for it you can ignore this mail.\n",
+			user.Username, link, cfg.MagicLinkTTL),
+	})
+	if err != nil {
+		// the answer must not differ from the one of an unknown user
+		logger.Ctx(ctx).Err(err).Msg("failed to send magic link")
+		event.Detail = "mail not sent"
+		return nil
+	}
+
+	metrics.AuthEvent(metrics.EventMagicLinkSent)
+	event.Success = true
+	return nil
+}
+
+// Login starts a session for the user of a link sent by Send, opened in the
+// browser that asked for it.
+func (s *magicLinkService) Login(ctx context.Context, req model.MagicLinkCallbackRequest) (*model.MagicLinkResponse, error) {
+	ctx, span := tracing.Start(ctx, "magicLinkService.Login")
+	defer span.End()
+
+	if req.Token == "" {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrMagicLink
+	}
+
+	// a link opened in another browser hashes to another key, so it stays
+	// usable in the right one
+	link, err := s.authRepo.TakeMagicLink(ctx, magicLinkKey(req.Token, req.Fingerprint))
+	if err != nil {
+		if err != goredis.Nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to take magic link")
+		}
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		return nil, constant.ErrMagicLink
+	}
+	res := &model.MagicLinkResponse{ReturnTo: link.ReturnTo}
+
+	event := model.AuditEvent{
+		Action:    AuditMagicLinkLogin,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(link.UserID)),
+		IP:        req.IP,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	user, err := s.userRepo.Get(ctx, link.UserID)
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		event.Detail = "user not found"
+		return res, constant.ErrMagicLink
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		return res, constant.ErrServer
+	}
+	event.Target = user.Username
+
+	// the role may have changed since the link was sent
+	if !magicLinkAllowed(ctx, user.Role) {
+		metrics.AuthEvent(metrics.EventLoginFailed)
+		event.Detail = fmt.Sprintf("role %q may not use magic links", user.Role)
+		return res, constant.ErrMagicLink
+	}
+
+	// the link replaces the password, not the passkey
+	res.AuthResponse, err = s.authService.LoginUser(ctx, user, link.ForceLogin)
+	if err != nil {
+		event.Detail = err.Error()
+		return res, err
+	}
+	if res.PasskeyRequired {
+		event.Detail = "passkey required"
+	}
+	event.Success = true
+
+	return res, nil
+}
+
+// magicLinkKey binds a token to the fingerprint of a browser, only the hash
+// is stored so a Redis dump holds no usable link.
+func magicLinkKey(token, fingerprint string) string {
+	sum := sha256.Sum256([]byte(token + "\x00" + fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// magicLinkAllowed reports whether role is listed in MAGIC_LINK_ROLES.
+func magicLinkAllowed(ctx context.Context, role string) bool {
+	roles, err := config.Cfg().MagicLinkRoleList()
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to read MAGIC_LINK_ROLES")
+		return false
+	}
+
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
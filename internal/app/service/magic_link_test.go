@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/mail"
+	"restapi/internal/security/token"
+	"testing"
+	"time"
+)
+
+// fakeMailer remembers the messages instead of sending them.
+type fakeMailer struct {
+	messages []mail.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var magicLinkToken = regexp.MustCompile(`token=(\S+)`)
+
+type magicLinkTest struct {
+	users  *fakeUserRepo
+	auth   *fakeAuthRepo
+	creds  *fakeCredentialRepo
+	mailer *fakeMailer
+	user   *model.User
+	svc    MagicLinkService
+}
+
+func newMagicLinkTest(t *testing.T, values map[string]string) *magicLinkTest {
+	loadConfig(t, values)
+
+	e := &magicLinkTest{
+		users:  newFakeUserRepo(),
+		auth:   newFakeAuthRepo(),
+		creds:  &fakeCredentialRepo{},
+		mailer: &fakeMailer{},
+	}
+	now := time.Now()
+	e.user = &model.User{Username: "alice", Role: "user", Email: "alice@example.com", PasswordChangedAt: &now}
+	if err := e.users.Create(context.Background(), e.user); err != nil {
+		t.Fatal(err)
+	}
+
+	authService := NewAuthService(e.users, e.auth, token.NewToken(), NewLocalAuthenticator(e.users), e.creds)
+	e.svc = NewMagicLinkService(e.users, e.auth, authService, &fakeAudit{}, e.mailer)
+	return e
+}
+
+// send asks for a link from the browser of fingerprint and returns the
+// token of the mail.
+func (e *magicLinkTest) send(t *testing.T, fingerprint string) string {
+	t.Helper()
+
+	err := e.svc.Send(context.Background(), model.MagicLinkRequest{Username: "alice", Fingerprint: fingerprint})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(e.mailer.messages) != 1 || e.mailer.messages[0].To != "alice@example.com" {
+		t.Fatalf("sent %v, want one mail to the email of the user", e.mailer.messages)
+	}
+
+	m := magicLinkToken.FindStringSubmatch(e.mailer.messages[0].Body)
+	if m == nil {
+		t.Fatal("the mail has no link")
+	}
+	link, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return link
+}
+
+func (e *magicLinkTest) login(link, fingerprint string) (*model.MagicLinkResponse, error) {
+	return e.svc.Login(context.Background(), model.MagicLinkCallbackRequest{Token: link, Fingerprint: fingerprint})
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	e := newMagicLinkTest(t, nil)
+	link := e.send(t, "browser")
+
+	if _, err := e.login(link, "another-browser"); err != constant.ErrMagicLink {
+		t.Errorf("Login from another browser = %v, want ErrMagicLink", err)
+	}
+
+	res, err := e.login(link, "browser")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if res.AccessToken == "" || res.PasskeyRequired || res.PasswordExpired {
+		t.Errorf("Login = %+v, want a session", res.AuthResponse)
+	}
+
+	if _, err := e.login(link, "browser"); err != constant.ErrMagicLink {
+		t.Errorf("second Login = %v, want ErrMagicLink", err)
+	}
+}
+
+func TestMagicLinkLoginAsksForPasskey(t *testing.T) {
+	e := newMagicLinkTest(t, nil)
+	e.creds.Create(context.Background(), &model.WebAuthnCredential{UserID: e.user.ID, CredentialID: "credential"})
+	link := e.send(t, "browser")
+
+	res, err := e.login(link, "browser")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !res.PasskeyRequired || res.LoginToken == "" || res.AccessToken != "" {
+		t.Fatalf("Login = %+v, want the passkey asked for instead of tokens", res.AuthResponse)
+	}
+	if _, err := e.auth.GetPendingLogin(context.Background(), res.LoginToken); err != nil {
+		t.Errorf("the login is not pending the passkey: %v", err)
+	}
+
+	user, _ := e.users.Get(context.Background(), e.user.ID)
+	if user.IsLogin {
+		t.Error("the link logged in without the passkey")
+	}
+}
+
+func TestMagicLinkLoginPasswordExpired(t *testing.T) {
+	e := newMagicLinkTest(t, map[string]string{"PASSWORD_MAX_AGE": "24h"})
+	old := time.Now().Add(-48 * time.Hour)
+	e.user.PasswordChangedAt = &old
+	e.users.Update(context.Background(), e.user)
+	link := e.send(t, "browser")
+
+	res, err := e.login(link, "browser")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !res.PasswordExpired {
+		t.Error("a link skipped the password policy")
+	}
+}
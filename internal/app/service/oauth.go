@@ -0,0 +1,786 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/token"
+	"restapi/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+)
+
+// grants and response types of the authorization server
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+
+	ResponseTypeCode = "code"
+	PKCEMethodS256   = "S256"
+)
+
+// token_type_hint values of RFC 7009 and RFC 7662
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+type OAuthService interface {
+	CreateClient(ctx context.Context, req model.OAuthClientCreateRequest) (*model.OAuthClientResponse, error)
+	ListClients(ctx context.Context) ([]*model.OAuthClientResponse, error)
+	DeleteClient(ctx context.Context, clientID string) error
+
+	CheckAuthorize(ctx context.Context, req model.AuthorizeRequest) (*model.OAuthClient, []string, error)
+	NeedsConsent(ctx context.Context, userID uint, clientID string, scopes []string) (bool, error)
+	Authorize(ctx context.Context, client *model.OAuthClient, req model.AuthorizeRequest, scopes []string, userID uint, approved bool) (string, error)
+
+	Token(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, error)
+	Revoke(ctx context.Context, req model.OAuthTokenActionRequest) error
+	Introspect(ctx context.Context, req model.OAuthTokenActionRequest) (*model.IntrospectionResponse, error)
+
+	UserInfo(ctx context.Context, userID uint, scopes []string) (*model.UserInfoResponse, error)
+	Logout(ctx context.Context, req model.LogoutRequest) (string, error)
+}
+
+type oauthService struct {
+	clientRepo   repository.OAuthClientRepo
+	userRepo     repository.UserRepo
+	authRepo     repository.AuthRepo
+	authService  AuthService
+	auditService AuditService
+	tk           token.TokenInterface
+}
+
+func NewOAuthService(
+	clientRepo repository.OAuthClientRepo,
+	userRepo repository.UserRepo,
+	authRepo repository.AuthRepo,
+	authService AuthService,
+	auditService AuditService,
+	tk token.TokenInterface,
+) OAuthService {
+	return &oauthService{clientRepo, userRepo, authRepo, authService, auditService, tk}
+}
+
+// CreateClient returns the client secret of confidential clients in clear
+// text, it cannot be read back later.
+func (s *oauthService) CreateClient(ctx context.Context, req model.OAuthClientCreateRequest) (*model.OAuthClientResponse, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.CreateClient")
+	defer span.End()
+
+	clientID, err := randomString(16)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate client id")
+		return nil, constant.ErrServer
+	}
+
+	client := &model.OAuthClient{
+		Name:         req.Name,
+		ClientID:     "oc_" + clientID,
+		RedirectURIs: strings.Join(req.RedirectURIs, " "),
+		Scopes:       strings.Join(req.Scopes, ","),
+		IsPublic:     req.Public,
+
+		PostLogoutRedirectURIs: strings.Join(req.PostLogoutRedirectURIs, " "),
+	}
+
+	var secret string
+	if !req.Public {
+		secret, err = randomString(32)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to generate client secret")
+			return nil, constant.ErrServer
+		}
+		client.SecretHash = hashAccessToken(secret)
+	}
+
+	err = s.clientRepo.Create(ctx, client)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create oauth client")
+		return nil, constant.ErrServer
+	}
+
+	res := model.NewOAuthClientResponse(client)
+	res.ClientSecret = secret
+	return res, nil
+}
+
+func (s *oauthService) ListClients(ctx context.Context) ([]*model.OAuthClientResponse, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.ListClients")
+	defer span.End()
+
+	clients, err := s.clientRepo.List(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list oauth clients")
+		return nil, constant.ErrServer
+	}
+
+	res := make([]*model.OAuthClientResponse, len(clients))
+	for i, client := range clients {
+		res[i] = model.NewOAuthClientResponse(client)
+	}
+
+	return res, nil
+}
+
+// DeleteClient removes the client and its consents, the tokens already
+// issued live until they expire.
+func (s *oauthService) DeleteClient(ctx context.Context, clientID string) error {
+	ctx, span := tracing.Start(ctx, "oauthService.DeleteClient")
+	defer span.End()
+
+	err := s.clientRepo.Delete(ctx, clientID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to delete oauth client")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return constant.ErrOAuthClientNotFound
+		default:
+			return constant.ErrServer
+		}
+	}
+
+	return nil
+}
+
+// CheckAuthorize validates an authorization request and returns the client
+// with the requested scopes. When the returned client is nil the error must
+// be shown to the user, otherwise it is sent to the redirect uri.
+func (s *oauthService) CheckAuthorize(ctx context.Context, req model.AuthorizeRequest) (*model.OAuthClient, []string, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.CheckAuthorize")
+	defer span.End()
+
+	client, err := s.clientRepo.GetByClientID(ctx, req.ClientID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, constant.ErrInvalidClient
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get oauth client")
+		return nil, nil, constant.ErrServer
+	}
+
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, nil, constant.ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != ResponseTypeCode {
+		return client, nil, constant.ErrUnsupportedResponseType
+	}
+
+	// PKCE is required from every client, plain challenges are refused
+	if req.CodeChallengeMethod != PKCEMethodS256 || len(req.CodeChallenge) != 43 {
+		return client, nil, constant.ErrInvalidRequest
+	}
+
+	if _, ok := req.MaxAgeSeconds(); req.MaxAge != "" && !ok {
+		return client, nil, constant.ErrInvalidRequest
+	}
+
+	scopes, err := requestedScopes(req.Scope, client.ScopeList())
+	if err != nil {
+		return client, nil, err
+	}
+
+	return client, scopes, nil
+}
+
+// NeedsConsent reports whether the user has not granted scopes to the
+// client yet.
+func (s *oauthService) NeedsConsent(ctx context.Context, userID uint, clientID string, scopes []string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.NeedsConsent")
+	defer span.End()
+
+	consent, err := s.clientRepo.GetConsent(ctx, userID, clientID)
+	if err == gorm.ErrRecordNotFound {
+		return true, nil
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get oauth consent")
+		return false, constant.ErrServer
+	}
+
+	for _, scope := range scopes {
+		if !contains(consent.ScopeList(), scope) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Authorize records the decision of the user on a request checked with
+// CheckAuthorize, client and scopes are the ones it returned. It returns
+// the authorization code when approved.
+func (s *oauthService) Authorize(ctx context.Context, client *model.OAuthClient, req model.AuthorizeRequest, scopes []string, userID uint, approved bool) (string, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.Authorize")
+	defer span.End()
+
+	event := model.AuditEvent{
+		Action:    AuditOAuthAuthorize,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(userID)),
+		Target:    client.ClientID,
+		Success:   approved,
+		Detail:    fmt.Sprintf("scope=%q", strings.Join(scopes, " ")),
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	if !approved {
+		return "", constant.ErrAccessDenied
+	}
+
+	user, err := s.userRepo.Get(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		event.Success = false
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return "", constant.ErrAccessDenied
+		default:
+			return "", constant.ErrServer
+		}
+	}
+	if user.IsDisabled {
+		event.Success = false
+		return "", constant.ErrAccessDenied
+	}
+
+	consent := &model.OAuthConsent{UserID: user.ID, ClientID: client.ClientID}
+	if previous, err := s.clientRepo.GetConsent(ctx, user.ID, client.ClientID); err == nil {
+		consent.Scopes = previous.Scopes
+	}
+	for _, scope := range scopes {
+		if !contains(consent.ScopeList(), scope) {
+			consent.Scopes = strings.Trim(consent.Scopes+","+scope, ",")
+		}
+	}
+	err = s.clientRepo.SaveConsent(ctx, consent)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to save oauth consent")
+		event.Success = false
+		return "", constant.ErrServer
+	}
+
+	code, err := randomString(32)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate authorization code")
+		event.Success = false
+		return "", constant.ErrServer
+	}
+
+	err = s.authRepo.CreateCode(ctx, code, &model.AuthorizationCode{
+		ClientID:            client.ClientID,
+		RedirectURI:         req.RedirectURI,
+		UserID:              user.ID,
+		Username:            user.Username,
+		Role:                user.Role,
+		Scope:               strings.Join(scopes, " "),
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            req.AuthTime,
+	}, config.Cfg().OAuthCodeTTL)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store authorization code")
+		event.Success = false
+		return "", constant.ErrServer
+	}
+
+	return code, nil
+}
+
+// Token implements the authorization_code and refresh_token grants.
+func (s *oauthService) Token(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.Token")
+	defer span.End()
+
+	var (
+		res  *model.OAuthTokenResponse
+		user *model.User
+		err  error
+	)
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		res, user, err = s.exchangeCode(ctx, req)
+	case GrantRefreshToken:
+		res, user, err = s.refresh(ctx, req)
+	default:
+		return nil, constant.ErrUnsupportedGrantType
+	}
+
+	event := model.AuditEvent{
+		Action:    AuditOAuthToken,
+		ActorType: model.ActorUser,
+		Target:    req.ClientID,
+		IP:        req.IP,
+		Success:   err == nil,
+		Detail:    "grant_type=" + req.GrantType,
+	}
+	if user != nil {
+		event.ActorID = strconv.Itoa(int(user.ID))
+	}
+	if err != nil {
+		metrics.AuthEvent(metrics.EventOAuthTokenFailed)
+		event.Detail += " error=" + err.Error()
+	} else {
+		metrics.AuthEvent(metrics.EventOAuthToken)
+		event.Detail += fmt.Sprintf(" scope=%q", res.Scope)
+	}
+	s.auditService.Record(ctx, event)
+
+	return res, err
+}
+
+func (s *oauthService) exchangeCode(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, *model.User, error) {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, nil, constant.ErrInvalidRequest
+	}
+
+	code, err := s.authRepo.TakeCode(ctx, req.Code)
+	if err != nil {
+		if err != goredis.Nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to take authorization code")
+		}
+		return nil, nil, constant.ErrInvalidGrant
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != req.RedirectURI || !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		return nil, nil, constant.ErrInvalidGrant
+	}
+
+	user, err := s.activeUser(ctx, code.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.issue(ctx, client, user, code.Scope, code.AuthTime, code.Nonce)
+	return res, user, err
+}
+
+// refresh rotates the refresh token, the previous pair is revoked.
+func (s *oauthService) refresh(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, *model.User, error) {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acc, err := s.tk.ParseRefreshToken(req.RefreshToken)
+	if err != nil || acc.ClientID != client.ClientID {
+		return nil, nil, constant.ErrInvalidGrant
+	}
+
+	// a missing entry means the token was revoked or already used
+	data, err := s.authRepo.FetchAuth(ctx, acc.RefreshUuid)
+	if err != nil {
+		return nil, nil, constant.ErrInvalidGrant
+	}
+	authTime, _ := data["auth_time"].(float64)
+
+	scope := strings.Join(acc.Scopes, " ")
+	if req.Scope != "" {
+		scopes, err := requestedScopes(req.Scope, acc.Scopes)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope = strings.Join(scopes, " ")
+	}
+
+	err = s.deleteTokens(ctx, acc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.activeUser(ctx, acc.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.issue(ctx, client, user, scope, int64(authTime), "")
+	return res, user, err
+}
+
+// Revoke answers success for unknown tokens and tokens of other clients as
+// RFC 7009 section 2.2 requires, only client authentication can fail.
+func (s *oauthService) Revoke(ctx context.Context, req model.OAuthTokenActionRequest) error {
+	ctx, span := tracing.Start(ctx, "oauthService.Revoke")
+	defer span.End()
+
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	acc := s.parseToken(req.Token, req.TokenTypeHint)
+	if acc == nil || acc.ClientID != client.ClientID {
+		return nil
+	}
+
+	err = s.deleteTokens(ctx, acc)
+	if err != nil {
+		return err
+	}
+
+	s.auditService.Record(ctx, model.AuditEvent{
+		Action:    AuditOAuthRevoke,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(acc.UserId)),
+		Target:    client.ClientID,
+		IP:        req.IP,
+		Success:   true,
+	})
+
+	return nil
+}
+
+// Introspect is reserved to confidential clients, a token is active while
+// its signature is valid and it is still in redis.
+func (s *oauthService) Introspect(ctx context.Context, req model.OAuthTokenActionRequest) (*model.IntrospectionResponse, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.Introspect")
+	defer span.End()
+
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if client.IsPublic {
+		return nil, constant.ErrInvalidClient
+	}
+
+	inactive := &model.IntrospectionResponse{Active: false}
+	acc := s.parseToken(req.Token, req.TokenTypeHint)
+	if acc == nil {
+		return inactive, nil
+	}
+
+	key, tokenType := acc.TokenUuid, TokenTypeAccess
+	if acc.RefreshUuid != "" {
+		key, tokenType = acc.RefreshUuid, TokenTypeRefresh
+	}
+	_, err = s.authRepo.FetchAuth(ctx, key)
+	if err == goredis.Nil {
+		return inactive, nil
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to fetch token for introspection")
+		return nil, constant.ErrServer
+	}
+
+	res := &model.IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(acc.Scopes, " "),
+		ClientID:  acc.ClientID,
+		Username:  acc.Username,
+		TokenType: tokenType,
+		Exp:       acc.ExpiresAt,
+	}
+	if acc.UserId != 0 {
+		res.Sub = strconv.Itoa(int(acc.UserId))
+	}
+
+	return res, nil
+}
+
+// authenticateClient checks the secret of confidential clients, public
+// clients are only identified.
+func (s *oauthService) authenticateClient(ctx context.Context, clientID, secret string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, constant.ErrInvalidClient
+	}
+
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, constant.ErrInvalidClient
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get oauth client")
+		return nil, constant.ErrServer
+	}
+
+	if client.IsPublic {
+		if secret != "" {
+			return nil, constant.ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAccessToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, constant.ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+func (s *oauthService) activeUser(ctx context.Context, userID uint) (*model.User, error) {
+	user, err := s.userRepo.Get(ctx, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, constant.ErrInvalidGrant
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		return nil, constant.ErrServer
+	}
+
+	if user.IsDisabled {
+		return nil, constant.ErrInvalidGrant
+	}
+
+	return user, nil
+}
+
+// issue creates a token pair for user, stored in redis like the session
+// tokens of AuthService, and an id_token when the openid scope is granted.
+func (s *oauthService) issue(ctx context.Context, client *model.OAuthClient, user *model.User, scope string, authTime int64, nonce string) (*model.OAuthTokenResponse, error) {
+	claims := map[string]interface{}{
+		"user_id":          user.ID,
+		"username":         user.Username,
+		"user_role":        user.Role,
+		"password_expired": passwordExpired(user),
+		"client_id":        client.ClientID,
+		"scope":            scope,
+		// only kept in redis, for the id_tokens of later refreshes
+		"auth_time": authTime,
+	}
+	td, err := s.tk.CreateToken(claims)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create oauth token")
+		return nil, constant.ErrServer
+	}
+
+	err = s.authRepo.CreateAuth(ctx, claims, td)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store oauth token")
+		return nil, constant.ErrServer
+	}
+
+	res := &model.OAuthTokenResponse{
+		AccessToken:  td.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    td.AtExpires - time.Now().Unix(),
+		RefreshToken: td.RefreshToken,
+		Scope:        scope,
+	}
+
+	scopes := strings.Fields(scope)
+	if contains(scopes, model.ScopeOpenID) {
+		res.IDToken, err = s.idToken(client, user, scopes, td, authTime, nonce)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to sign id_token")
+			return nil, constant.ErrServer
+		}
+	}
+
+	return res, nil
+}
+
+// idToken signs the id_token of a token pair. Its sid is the access token
+// uuid so RP-initiated logout can revoke the pair.
+func (s *oauthService) idToken(client *model.OAuthClient, user *model.User, scopes []string, td *model.TokenDetails, authTime int64, nonce string) (string, error) {
+	signer, err := token.CurrentSigner()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"iss":     config.Cfg().OIDCIssuerURL(),
+		"sub":     strconv.Itoa(int(user.ID)),
+		"aud":     client.ClientID,
+		"azp":     client.ClientID,
+		"iat":     time.Now().Unix(),
+		"exp":     td.AtExpires,
+		"sid":     td.TokenUuid,
+		"at_hash": token.AccessTokenHash(td.AccessToken),
+	}
+	if authTime > 0 {
+		claims["auth_time"] = authTime
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for k, v := range profileClaims(user, scopes) {
+		claims[k] = v
+	}
+
+	return signer.Sign(claims)
+}
+
+// UserInfo returns the claims of the user the access token was issued for.
+func (s *oauthService) UserInfo(ctx context.Context, userID uint, scopes []string) (*model.UserInfoResponse, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.UserInfo")
+	defer span.End()
+
+	user, err := s.userRepo.Get(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	res := &model.UserInfoResponse{Sub: strconv.Itoa(int(user.ID))}
+	// sessions have every scope
+	if scopes == nil || contains(scopes, model.ScopeProfile) {
+		res.PreferredUsername = user.Username
+		res.Roles = []string{user.Role}
+	}
+
+	return res, nil
+}
+
+// Logout ends the session an id_token_hint was issued with through
+// AuthService.Logout and returns where to send the browser, empty when the
+// relying party did not ask to come back. The pair is deleted from Redis,
+// so the authentication middleware refuses its access token at once.
+func (s *oauthService) Logout(ctx context.Context, req model.LogoutRequest) (string, error) {
+	ctx, span := tracing.Start(ctx, "oauthService.Logout")
+	defer span.End()
+
+	clientID := req.ClientID
+	var claims jwt.MapClaims
+	if req.IDTokenHint != "" {
+		signer, err := token.CurrentSigner()
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to load id_token signer")
+			return "", constant.ErrServer
+		}
+
+		claims, err = signer.Parse(req.IDTokenHint)
+		if err != nil || claims["iss"] != config.Cfg().OIDCIssuerURL() {
+			return "", constant.ErrInvalidRequest
+		}
+
+		aud, _ := claims["aud"].(string)
+		if clientID != "" && clientID != aud {
+			return "", constant.ErrInvalidRequest
+		}
+		clientID = aud
+	}
+
+	redirect := ""
+	if req.PostLogoutRedirectURI != "" {
+		client, err := s.clientRepo.GetByClientID(ctx, clientID)
+		if err != nil || !client.AllowsPostLogoutRedirect(req.PostLogoutRedirectURI) {
+			return "", constant.ErrInvalidRedirectURI
+		}
+		redirect = req.PostLogoutRedirectURI
+	}
+
+	if claims == nil {
+		return redirect, nil
+	}
+
+	sid, _ := claims["sid"].(string)
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return "", constant.ErrInvalidRequest
+	}
+
+	// the username is part of the refresh token key
+	user, err := s.userRepo.Get(ctx, uint(userID))
+	if err == gorm.ErrRecordNotFound {
+		return redirect, nil
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		return "", constant.ErrServer
+	}
+
+	err = s.authService.Logout(ctx, &model.AccessDetails{
+		TokenUuid: sid,
+		UserId:    user.ID,
+		Username:  user.Username,
+		ClientID:  clientID,
+	})
+	if err != nil {
+		return "", constant.ErrServer
+	}
+
+	return redirect, nil
+}
+
+func profileClaims(user *model.User, scopes []string) map[string]interface{} {
+	if !contains(scopes, model.ScopeProfile) {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"preferred_username": user.Username,
+		"roles":              []string{user.Role},
+	}
+}
+
+// parseToken returns the details of an access or refresh token, trying the
+// hinted type first, or nil when it is neither.
+func (s *oauthService) parseToken(raw, hint string) *model.AccessDetails {
+	parsers := []func(string) (*model.AccessDetails, error){s.tk.ParseAccessToken, s.tk.ParseRefreshToken}
+	if hint == TokenTypeRefresh {
+		parsers[0], parsers[1] = parsers[1], parsers[0]
+	}
+
+	for _, parse := range parsers {
+		if acc, err := parse(raw); err == nil {
+			return acc
+		}
+	}
+
+	return nil
+}
+
+// deleteTokens revokes the pair acc belongs to, acc is an access or a
+// refresh token.
+func (s *oauthService) deleteTokens(ctx context.Context, acc *model.AccessDetails) error {
+	pair := *acc
+	if acc.RefreshUuid != "" {
+		// the refresh uuid is the access uuid followed by ++
+		pair.TokenUuid = strings.SplitN(acc.RefreshUuid, "++", 2)[0]
+	}
+
+	err := s.authRepo.DeleteTokens(ctx, &pair)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to delete oauth tokens")
+		return constant.ErrServer
+	}
+
+	return nil
+}
+
+// requestedScopes parses a space separated scope parameter, it must be a
+// subset of allowed. No scope requests all of allowed.
+func requestedScopes(scope string, allowed []string) ([]string, error) {
+	if scope == "" {
+		return allowed, nil
+	}
+
+	scopes := strings.Fields(scope)
+	for _, s := range scopes {
+		if !contains(allowed, s) {
+			return nil, constant.ErrInvalidScope
+		}
+	}
+
+	return scopes, nil
+}
+
+// verifyPKCE checks an S256 code verifier, RFC 7636 section 4.6.
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/security/oidc"
+	"restapi/internal/security/token"
+	"testing"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+type oauthTest struct {
+	auth    *fakeAuthRepo
+	tk      token.TokenInterface
+	session AuthService
+	service OAuthService
+	client  *model.OAuthClientResponse
+	user    *model.User
+}
+
+func newOAuthTest(t *testing.T) *oauthTest {
+	loadConfig(t, nil)
+
+	users := newFakeUserRepo()
+	e := &oauthTest{auth: newFakeAuthRepo(), tk: token.NewToken()}
+	e.session = NewAuthService(users, e.auth, e.tk, NewLocalAuthenticator(users), &fakeCredentialRepo{})
+	e.service = NewOAuthService(&fakeOAuthClientRepo{}, users, e.auth, e.session, &fakeAudit{}, e.tk)
+	e.user = createLocalUser(t, users, "alice", "alice-password")
+
+	client, err := e.service.CreateClient(context.Background(), model.OAuthClientCreateRequest{
+		Name:         "app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{model.ScopeUserRead},
+		Public:       true,
+	})
+	if err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	e.client = client
+
+	return e
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize has alice approve the client and returns the code and its
+// code verifier.
+func (e *oauthTest) authorize(t *testing.T) (string, string) {
+	t.Helper()
+
+	verifier := oidc.RandomString(48)
+	req := model.AuthorizeRequest{
+		ResponseType:        ResponseTypeCode,
+		ClientID:            e.client.ClientID,
+		RedirectURI:         testRedirectURI,
+		CodeChallenge:       s256(verifier),
+		CodeChallengeMethod: PKCEMethodS256,
+	}
+	client, scopes, err := e.service.CheckAuthorize(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CheckAuthorize: %v", err)
+	}
+	code, err := e.service.Authorize(context.Background(), client, req, scopes, e.user.ID, true)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	return code, verifier
+}
+
+func (e *oauthTest) exchange(code, verifier, redirectURI string) (*model.OAuthTokenResponse, error) {
+	return e.service.Token(context.Background(), model.OAuthTokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		ClientID:     e.client.ClientID,
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+	})
+}
+
+func (e *oauthTest) refresh(refreshToken string) (*model.OAuthTokenResponse, error) {
+	return e.service.Token(context.Background(), model.OAuthTokenRequest{
+		GrantType:    GrantRefreshToken,
+		ClientID:     e.client.ClientID,
+		RefreshToken: refreshToken,
+	})
+}
+
+// checkToken answers like the authentication middleware for an access
+// token.
+func (e *oauthTest) checkToken(t *testing.T, accessToken string) error {
+	t.Helper()
+
+	acc, err := e.tk.ParseAccessToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.session.CheckToken(context.Background(), acc)
+}
+
+func TestOAuthPlainChallengeRefused(t *testing.T) {
+	e := newOAuthTest(t)
+	verifier := oidc.RandomString(48)
+
+	_, _, err := e.service.CheckAuthorize(context.Background(), model.AuthorizeRequest{
+		ResponseType:        ResponseTypeCode,
+		ClientID:            e.client.ClientID,
+		RedirectURI:         testRedirectURI,
+		CodeChallenge:       verifier,
+		CodeChallengeMethod: "plain",
+	})
+	if err != constant.ErrInvalidRequest {
+		t.Errorf("CheckAuthorize with a plain challenge = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestOAuthCodeExchange(t *testing.T) {
+	e := newOAuthTest(t)
+
+	code, _ := e.authorize(t)
+	if _, err := e.exchange(code, oidc.RandomString(48), testRedirectURI); err != constant.ErrInvalidGrant {
+		t.Errorf("Token with a wrong code_verifier = %v, want ErrInvalidGrant", err)
+	}
+
+	code, verifier := e.authorize(t)
+	if _, err := e.exchange(code, verifier, "https://app.example.com/other"); err != constant.ErrInvalidGrant {
+		t.Errorf("Token with another redirect_uri = %v, want ErrInvalidGrant", err)
+	}
+	// a failed exchange spends the code as well
+	if _, err := e.exchange(code, verifier, testRedirectURI); err != constant.ErrInvalidGrant {
+		t.Errorf("Token after a failed exchange = %v, want ErrInvalidGrant", err)
+	}
+
+	code, verifier = e.authorize(t)
+	res, err := e.exchange(code, verifier, testRedirectURI)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" || res.Scope != model.ScopeUserRead {
+		t.Errorf("Token = %+v, want a token pair with the granted scope", res)
+	}
+	if err := e.checkToken(t, res.AccessToken); err != nil {
+		t.Errorf("CheckToken of the issued token: %v", err)
+	}
+
+	if _, err := e.exchange(code, verifier, testRedirectURI); err != constant.ErrInvalidGrant {
+		t.Errorf("Token with a used code = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestOAuthRefreshRotation(t *testing.T) {
+	e := newOAuthTest(t)
+	code, verifier := e.authorize(t)
+	first, err := e.exchange(code, verifier, testRedirectURI)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	second, err := e.refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("the refresh token was not rotated")
+	}
+
+	if _, err := e.refresh(first.RefreshToken); err != constant.ErrInvalidGrant {
+		t.Errorf("refresh with a used refresh token = %v, want ErrInvalidGrant", err)
+	}
+	if err := e.checkToken(t, first.AccessToken); err != constant.ErrUnauthenticated {
+		t.Errorf("CheckToken of the rotated access token = %v, want ErrUnauthenticated", err)
+	}
+	if err := e.checkToken(t, second.AccessToken); err != nil {
+		t.Errorf("CheckToken of the new access token: %v", err)
+	}
+}
+
+func TestOAuthRevokedAccessToken(t *testing.T) {
+	e := newOAuthTest(t)
+	code, verifier := e.authorize(t)
+	res, err := e.exchange(code, verifier, testRedirectURI)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	err = e.service.Revoke(context.Background(), model.OAuthTokenActionRequest{
+		Token:         res.AccessToken,
+		TokenTypeHint: TokenTypeAccess,
+		ClientID:      e.client.ClientID,
+	})
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	// the signature is still valid until exp, redis decides
+	if err := e.checkToken(t, res.AccessToken); err != constant.ErrUnauthenticated {
+		t.Errorf("CheckToken of a revoked access token = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := e.refresh(res.RefreshToken); err != constant.ErrInvalidGrant {
+		t.Errorf("refresh of a revoked pair = %v, want ErrInvalidGrant", err)
+	}
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/security/webauthn"
+	"restapi/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// maxPasskeys bounds the passkeys of a user, they are all listed in the
+// login options.
+const maxPasskeys = 10
+
+type PasskeyService interface {
+	RegisterOptions(ctx context.Context, userID uint, reauthToken string) (*webauthn.CreationOptions, error)
+	Register(ctx context.Context, req model.PasskeyRegisterRequest) (*model.PasskeyResponse, error)
+	List(ctx context.Context, userID uint) ([]*model.PasskeyResponse, error)
+	Delete(ctx context.Context, userID, id uint, reauthToken, ip string) error
+}
+
+type passkeyService struct {
+	credentialRepo repository.WebAuthnCredentialRepo
+	userRepo       repository.UserRepo
+	authRepo       repository.AuthRepo
+	auditService   AuditService
+}
+
+func NewPasskeyService(
+	credentialRepo repository.WebAuthnCredentialRepo,
+	userRepo repository.UserRepo,
+	authRepo repository.AuthRepo,
+	auditService AuditService) PasskeyService {
+	return &passkeyService{credentialRepo, userRepo, authRepo, auditService}
+}
+
+// RegisterOptions starts the registration of a passkey for the user, its
+// existing passkeys are excluded so an authenticator is not registered
+// twice. The challenge binds Register to the reauth token spent here.
+func (s *passkeyService) RegisterOptions(ctx context.Context, userID uint, reauthToken string) (*webauthn.CreationOptions, error) {
+	ctx, span := tracing.Start(ctx, "passkeyService.RegisterOptions")
+	defer span.End()
+
+	err := s.takeReauth(ctx, userID, reauthToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.Get(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	creds, err := s.credentialRepo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list passkeys")
+		return nil, constant.ErrServer
+	}
+	if len(creds) >= maxPasskeys {
+		return nil, constant.ErrPasskeyLimit
+	}
+
+	challenge, err := s.challenge(ctx, &model.WebAuthnChallenge{Ceremony: model.CeremonyRegister, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	return relyingParty().CreationOptions(challenge, passkeyUserHandle(user.ID), user.Username, user.Username, credentialDescriptors(creds)), nil
+}
+
+// Register verifies the answer of the authenticator to RegisterOptions and
+// stores its credential.
+func (s *passkeyService) Register(ctx context.Context, req model.PasskeyRegisterRequest) (*model.PasskeyResponse, error) {
+	ctx, span := tracing.Start(ctx, "passkeyService.Register")
+	defer span.End()
+
+	event := model.AuditEvent{
+		Action:    AuditPasskeyRegister,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(req.UserID)),
+		Target:    req.Name,
+		IP:        req.IP,
+	}
+	defer func() { s.auditService.Record(ctx, event) }()
+
+	cd, err := webauthn.ParseClientData(req.Credential.Response.ClientDataJSON)
+	if err != nil {
+		event.Detail = err.Error()
+		return nil, constant.ErrPasskeyRegistration
+	}
+
+	ceremony, err := s.authRepo.TakeChallenge(ctx, cd.Challenge)
+	if err != nil || ceremony.Ceremony != model.CeremonyRegister || ceremony.UserID != req.UserID {
+		event.Detail = "unknown challenge"
+		return nil, constant.ErrPasskeyRegistration
+	}
+
+	cred, err := relyingParty().VerifyRegistration(cd.Challenge, &req.Credential)
+	if err != nil {
+		event.Detail = err.Error()
+		return nil, constant.ErrPasskeyRegistration
+	}
+
+	count, err := s.credentialRepo.CountByUser(ctx, req.UserID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to count passkeys")
+		return nil, constant.ErrServer
+	} else if count >= maxPasskeys {
+		return nil, constant.ErrPasskeyLimit
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(cred.ID)
+	_, err = s.credentialRepo.GetByCredentialID(ctx, credentialID)
+	switch err {
+	case nil:
+		event.Detail = "credential already registered"
+		return nil, constant.ErrPasskeyRegistered
+	case gorm.ErrRecordNotFound:
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get passkey")
+		return nil, constant.ErrServer
+	}
+
+	data := &model.WebAuthnCredential{
+		UserID:       req.UserID,
+		Name:         req.Name,
+		CredentialID: credentialID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+		AAGUID:       hex.EncodeToString(cred.AAGUID),
+		Transports:   strings.Join(cred.Transports, ","),
+		Discoverable: cred.Discoverable,
+		BackedUp:     cred.Flags&webauthn.FlagBackupState != 0,
+	}
+	err = s.credentialRepo.Create(ctx, data)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create passkey")
+		return nil, constant.ErrServer
+	}
+	event.Success = true
+
+	return model.NewPasskeyResponse(data), nil
+}
+
+func (s *passkeyService) List(ctx context.Context, userID uint) ([]*model.PasskeyResponse, error) {
+	ctx, span := tracing.Start(ctx, "passkeyService.List")
+	defer span.End()
+
+	creds, err := s.credentialRepo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list passkeys")
+		return nil, constant.ErrServer
+	}
+
+	res := make([]*model.PasskeyResponse, len(creds))
+	for i, cred := range creds {
+		res[i] = model.NewPasskeyResponse(cred)
+	}
+
+	return res, nil
+}
+
+// Delete removes a passkey of the user, without passkeys left the password
+// alone logs in again.
+func (s *passkeyService) Delete(ctx context.Context, userID, id uint, reauthToken, ip string) error {
+	ctx, span := tracing.Start(ctx, "passkeyService.Delete")
+	defer span.End()
+
+	err := s.takeReauth(ctx, userID, reauthToken)
+	if err != nil {
+		return err
+	}
+
+	err = s.credentialRepo.Delete(ctx, userID, id)
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		return constant.ErrPasskeyNotFound
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to delete passkey")
+		return constant.ErrServer
+	}
+
+	s.auditService.Record(ctx, model.AuditEvent{
+		Action:    AuditPasskeyDelete,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(userID)),
+		Target:    strconv.Itoa(int(id)),
+		IP:        ip,
+		Success:   true,
+	})
+	return nil
+}
+
+// takeReauth spends the reauth token of the user, see
+// AuthService.Reauthenticate.
+func (s *passkeyService) takeReauth(ctx context.Context, userID uint, reauthToken string) error {
+	owner, err := s.authRepo.TakeReauth(ctx, reauthToken)
+	if err != nil {
+		if err != goredis.Nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to take reauth token")
+		}
+		return constant.ErrReauthRequired
+	} else if owner != userID {
+		return constant.ErrReauthRequired
+	}
+
+	return nil
+}
+
+func (s *passkeyService) challenge(ctx context.Context, data *model.WebAuthnChallenge) (string, error) {
+	return newChallenge(ctx, s.authRepo, data)
+}
+
+// newChallenge stores a ceremony under a new challenge for as long as the
+// browser waits for the authenticator.
+func newChallenge(ctx context.Context, authRepo repository.AuthRepo, data *model.WebAuthnChallenge) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate webauthn challenge")
+		return "", constant.ErrServer
+	}
+
+	err = authRepo.CreateChallenge(ctx, challenge, data, config.Cfg().WebAuthnTimeout)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store webauthn challenge")
+		return "", constant.ErrServer
+	}
+
+	return challenge, nil
+}
+
+// verifyPasskey checks an assertion answering a challenge of ceremonyName
+// and records the use of its passkey. It returns the ceremony the assertion
+// answers and the passkey.
+func verifyPasskey(ctx context.Context, authRepo repository.AuthRepo, credentialRepo repository.WebAuthnCredentialRepo, ceremonyName string, res *webauthn.AssertionResponse) (*model.WebAuthnChallenge, *model.WebAuthnCredential, error) {
+	cd, err := webauthn.ParseClientData(res.Response.ClientDataJSON)
+	if err != nil {
+		return nil, nil, constant.ErrPasskeyLogin
+	}
+
+	ceremony, err := authRepo.TakeChallenge(ctx, cd.Challenge)
+	if err != nil {
+		if err != goredis.Nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to take webauthn challenge")
+		}
+		return nil, nil, constant.ErrPasskeyLogin
+	} else if ceremony.Ceremony != ceremonyName {
+		return nil, nil, constant.ErrPasskeyLogin
+	}
+
+	cred, err := credentialRepo.GetByCredentialID(ctx, base64.RawURLEncoding.EncodeToString(res.ID))
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		return nil, nil, constant.ErrPasskeyLogin
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to get passkey")
+		return nil, nil, constant.ErrServer
+	}
+
+	// the options named the user, or the authenticator tells it for a
+	// discoverable passkey
+	handle := res.Response.UserHandle
+	if ceremony.UserID != 0 && cred.UserID != ceremony.UserID ||
+		ceremony.UserID == 0 && len(handle) == 0 ||
+		len(handle) > 0 && string(handle) != string(passkeyUserHandle(cred.UserID)) {
+		return nil, nil, constant.ErrPasskeyLogin
+	}
+
+	data, err := relyingParty().VerifyAssertion(cd.Challenge, res, cred.PublicKey, uint32(cred.SignCount))
+	if err == webauthn.ErrSignCount {
+		logger.Ctx(ctx).Warn().Uint("passkey_id", cred.ID).Uint("user_id", cred.UserID).Msg("passkey counter went backwards, it may be cloned")
+		return nil, nil, constant.ErrPasskeyLogin
+	} else if err != nil {
+		logger.Ctx(ctx).Debug().Err(err).Msg("invalid passkey assertion")
+		return nil, nil, constant.ErrPasskeyLogin
+	}
+
+	err = credentialRepo.Touch(ctx, cred, int64(data.SignCount), data.Flags&webauthn.FlagBackupState != 0, time.Now())
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		// a concurrent login moved the counter past this one
+		return nil, nil, constant.ErrPasskeyLogin
+	default:
+		logger.Ctx(ctx).Err(err).Msg("failed to record passkey use")
+		return nil, nil, constant.ErrServer
+	}
+
+	return ceremony, cred, nil
+}
+
+func relyingParty() *webauthn.RelyingParty {
+	cfg := config.Cfg()
+	return &webauthn.RelyingParty{
+		ID:               cfg.WebAuthnRelyingPartyID(),
+		Name:             cfg.WebAuthnRPName,
+		Origins:          cfg.WebAuthnOriginList(),
+		UserVerification: cfg.WebAuthnUserVerification,
+		Timeout:          cfg.WebAuthnTimeout,
+	}
+}
+
+// passkeyUserHandle is the user.id of the passkeys of a user, its id which
+// means nothing outside this server.
+func passkeyUserHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+func credentialDescriptors(creds []*model.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	res := make([]webauthn.CredentialDescriptor, 0, len(creds))
+	for _, cred := range creds {
+		id, err := base64.RawURLEncoding.DecodeString(cred.CredentialID)
+		if err != nil {
+			continue
+		}
+
+		var transports []string
+		if cred.Transports != "" {
+			transports = strings.Split(cred.Transports, ",")
+		}
+		res = append(res, webauthn.CredentialDescriptor{Type: "public-key", ID: id, Transports: transports})
+	}
+
+	return res
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"restapi/internal/security/token"
+	"restapi/internal/security/webauthn"
+	"testing"
+)
+
+const testPasskeyOrigin = "http://localhost:8080"
+
+type passkeyTest struct {
+	users   *fakeUserRepo
+	auth    *fakeAuthRepo
+	creds   *fakeCredentialRepo
+	passkey PasskeyService
+	service AuthService
+}
+
+func newPasskeyTest(t *testing.T) *passkeyTest {
+	loadConfig(t, map[string]string{
+		"WEBAUTHN_RP_ID":   "localhost",
+		"WEBAUTHN_ORIGINS": testPasskeyOrigin,
+	})
+
+	e := &passkeyTest{users: newFakeUserRepo(), auth: newFakeAuthRepo(), creds: &fakeCredentialRepo{}}
+	e.passkey = NewPasskeyService(e.creds, e.users, e.auth, &fakeAudit{})
+	e.service = NewAuthService(e.users, e.auth, token.NewToken(), NewLocalAuthenticator(e.users), e.creds)
+	return e
+}
+
+// jsonRoundTrip sends v through JSON like the browser and the API do.
+func jsonRoundTrip(t *testing.T, v, out interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reauth confirms user with its password.
+func (e *passkeyTest) reauth(t *testing.T, user *model.User, pw string) string {
+	t.Helper()
+
+	res, err := e.service.Reauthenticate(context.Background(), model.PasskeyReauthRequest{UserID: user.ID, Username: user.Username, Password: pw})
+	if err != nil {
+		t.Fatalf("Reauthenticate: %v", err)
+	}
+	return res.ReauthToken
+}
+
+// register adds a passkey of a to the user, confirmed by reauthToken.
+func (e *passkeyTest) register(t *testing.T, a *webauthn.SoftAuthenticator, user *model.User, reauthToken string) {
+	t.Helper()
+
+	opts, err := e.passkey.RegisterOptions(context.Background(), user.ID, reauthToken)
+	if err != nil {
+		t.Fatalf("RegisterOptions: %v", err)
+	}
+	var browserOpts webauthn.CreationOptions
+	jsonRoundTrip(t, opts, &browserOpts)
+
+	res, err := a.Create(testPasskeyOrigin, &browserOpts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	req := model.PasskeyRegisterRequest{UserID: user.ID, Name: "laptop"}
+	jsonRoundTrip(t, res, &req.Credential)
+
+	if _, err := e.passkey.Register(context.Background(), req); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+}
+
+// assert answers the login options of req with a.
+func (e *passkeyTest) assert(t *testing.T, a *webauthn.SoftAuthenticator, req model.PasskeyLoginOptionsRequest) model.PasskeyLoginRequest {
+	t.Helper()
+
+	opts, err := e.service.PasskeyOptions(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PasskeyOptions: %v", err)
+	}
+	var browserOpts webauthn.RequestOptions
+	jsonRoundTrip(t, opts, &browserOpts)
+
+	res, err := a.Get(testPasskeyOrigin, &browserOpts)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var login model.PasskeyLoginRequest
+	jsonRoundTrip(t, res, &login.Credential)
+
+	return login
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	e := newPasskeyTest(t)
+	alice := createLocalUser(t, e.users, "alice", "alice-password")
+	a := &webauthn.SoftAuthenticator{}
+	e.register(t, a, alice, e.reauth(t, alice, "alice-password"))
+
+	login := e.assert(t, a, model.PasskeyLoginOptionsRequest{Username: "alice"})
+	res, err := e.service.PasskeyLogin(context.Background(), login)
+	if err != nil {
+		t.Fatalf("PasskeyLogin: %v", err)
+	}
+	if res.AccessToken == "" || res.PasskeyRequired {
+		t.Errorf("PasskeyLogin = %+v, want a session", res)
+	}
+
+	// the challenge is taken by the first answer
+	login.ForceLogin = true
+	if _, err := e.service.PasskeyLogin(context.Background(), login); err != constant.ErrPasskeyLogin {
+		t.Errorf("replayed PasskeyLogin = %v, want ErrPasskeyLogin", err)
+	}
+}
+
+func TestPasskeyLoginDiscoverable(t *testing.T) {
+	e := newPasskeyTest(t)
+	createLocalUser(t, e.users, "alice", "alice-password")
+	bob := createLocalUser(t, e.users, "bobby", "bobby-password")
+	a := &webauthn.SoftAuthenticator{}
+	e.register(t, a, bob, e.reauth(t, bob, "bobby-password"))
+
+	// without a username the user handle of the passkey names the user
+	login := e.assert(t, a, model.PasskeyLoginOptionsRequest{})
+	if _, err := e.service.PasskeyLogin(context.Background(), login); err != nil {
+		t.Fatalf("PasskeyLogin: %v", err)
+	}
+	user, _ := e.users.Get(context.Background(), bob.ID)
+	if !user.IsLogin {
+		t.Error("the user of the passkey was not logged in")
+	}
+
+	login = e.assert(t, a, model.PasskeyLoginOptionsRequest{})
+	login.Credential.Response.UserHandle = nil
+	if _, err := e.service.PasskeyLogin(context.Background(), login); err != constant.ErrPasskeyLogin {
+		t.Errorf("PasskeyLogin without a user handle = %v, want ErrPasskeyLogin", err)
+	}
+
+	login = e.assert(t, a, model.PasskeyLoginOptionsRequest{})
+	login.Credential.Response.UserHandle = passkeyUserHandle(bob.ID + 100)
+	if _, err := e.service.PasskeyLogin(context.Background(), login); err != constant.ErrPasskeyLogin {
+		t.Errorf("PasskeyLogin with the user handle of another user = %v, want ErrPasskeyLogin", err)
+	}
+}
+
+func TestPasskeyLoginSignCount(t *testing.T) {
+	e := newPasskeyTest(t)
+	alice := createLocalUser(t, e.users, "alice", "alice-password")
+	a := &webauthn.SoftAuthenticator{}
+	e.register(t, a, alice, e.reauth(t, alice, "alice-password"))
+
+	// the server has seen a higher counter, the authenticator is a clone
+	e.creds.creds[0].SignCount = 10
+
+	login := e.assert(t, a, model.PasskeyLoginOptionsRequest{Username: "alice"})
+	if _, err := e.service.PasskeyLogin(context.Background(), login); err != constant.ErrPasskeyLogin {
+		t.Errorf("PasskeyLogin = %v, want ErrPasskeyLogin", err)
+	}
+	user, _ := e.users.Get(context.Background(), alice.ID)
+	if user.IsLogin {
+		t.Error("a cloned passkey logged in")
+	}
+}
+
+func TestPasskeySecondFactor(t *testing.T) {
+	e := newPasskeyTest(t)
+	alice := createLocalUser(t, e.users, "alice", "alice-password")
+	bob := createLocalUser(t, e.users, "bobby", "bobby-password")
+	aliceKey, bobKey := &webauthn.SoftAuthenticator{}, &webauthn.SoftAuthenticator{}
+	e.register(t, aliceKey, alice, e.reauth(t, alice, "alice-password"))
+	e.register(t, bobKey, bob, e.reauth(t, bob, "bobby-password"))
+
+	if _, err := e.service.Authenticate(context.Background(), "alice", "alice-password"); err != constant.ErrPasskeyRequired {
+		t.Errorf("Authenticate of a user with a passkey = %v, want ErrPasskeyRequired", err)
+	}
+
+	res, err := e.service.Login(context.Background(), model.AuthRequest{Username: "alice", Password: "alice-password"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !res.PasskeyRequired || res.LoginToken == "" || res.AccessToken != "" {
+		t.Fatalf("Login = %+v, want the passkey asked for instead of tokens", res)
+	}
+
+	// the options of the login token only allow the passkeys of alice
+	if _, err := bobKey.Get(testPasskeyOrigin, mustPasskeyOptions(t, e, res.LoginToken)); err == nil {
+		t.Error("the options of alice allowed the passkey of bob")
+	}
+
+	login := e.assert(t, aliceKey, model.PasskeyLoginOptionsRequest{LoginToken: res.LoginToken})
+	done, err := e.service.PasskeyLogin(context.Background(), login)
+	if err != nil {
+		t.Fatalf("PasskeyLogin: %v", err)
+	}
+	if done.AccessToken == "" {
+		t.Error("the passkey did not complete the login")
+	}
+	if _, err := e.auth.GetPendingLogin(context.Background(), res.LoginToken); err == nil {
+		t.Error("the pending login can be completed again")
+	}
+	if _, err := e.service.PasskeyOptions(context.Background(), model.PasskeyLoginOptionsRequest{LoginToken: res.LoginToken}); err != constant.ErrPasskeyLogin {
+		t.Errorf("PasskeyOptions of a used login token = %v, want ErrPasskeyLogin", err)
+	}
+}
+
+func mustPasskeyOptions(t *testing.T, e *passkeyTest, loginToken string) *webauthn.RequestOptions {
+	t.Helper()
+
+	opts, err := e.service.PasskeyOptions(context.Background(), model.PasskeyLoginOptionsRequest{LoginToken: loginToken})
+	if err != nil {
+		t.Fatalf("PasskeyOptions: %v", err)
+	}
+	return opts
+}
+
+func TestPasskeyChangesNeedReauth(t *testing.T) {
+	e := newPasskeyTest(t)
+	alice := createLocalUser(t, e.users, "alice", "alice-password")
+	bob := createLocalUser(t, e.users, "bobby", "bobby-password")
+	ctx := context.Background()
+
+	if _, err := e.passkey.RegisterOptions(ctx, alice.ID, "unknown"); err != constant.ErrReauthRequired {
+		t.Errorf("RegisterOptions without reauth = %v, want ErrReauthRequired", err)
+	}
+	if _, err := e.passkey.RegisterOptions(ctx, alice.ID, e.reauth(t, bob, "bobby-password")); err != constant.ErrReauthRequired {
+		t.Errorf("RegisterOptions with the reauth of bob = %v, want ErrReauthRequired", err)
+	}
+	if _, err := e.service.Reauthenticate(ctx, model.PasskeyReauthRequest{UserID: alice.ID, Username: "alice", Password: "wrong"}); err == nil {
+		t.Error("Reauthenticate accepted a wrong password")
+	}
+
+	reauthToken := e.reauth(t, alice, "alice-password")
+	a := &webauthn.SoftAuthenticator{}
+	e.register(t, a, alice, reauthToken)
+	if _, err := e.passkey.RegisterOptions(ctx, alice.ID, reauthToken); err != constant.ErrReauthRequired {
+		t.Errorf("RegisterOptions with a spent reauth token = %v, want ErrReauthRequired", err)
+	}
+
+	// with a passkey the password alone no longer confirms the user
+	if _, err := e.service.Reauthenticate(ctx, model.PasskeyReauthRequest{UserID: alice.ID, Username: "alice", Password: "alice-password"}); err != constant.ErrPasskeyRequired {
+		t.Errorf("Reauthenticate with the password = %v, want ErrPasskeyRequired", err)
+	}
+
+	// the assertion of a reauth does not log in
+	req := e.passkeyReauth(t, a, alice)
+	if _, err := e.service.PasskeyLogin(ctx, model.PasskeyLoginRequest{Credential: *req.Credential}); err != constant.ErrPasskeyLogin {
+		t.Errorf("PasskeyLogin with a reauth assertion = %v, want ErrPasskeyLogin", err)
+	}
+
+	res, err := e.service.Reauthenticate(ctx, e.passkeyReauth(t, a, alice))
+	if err != nil {
+		t.Fatalf("Reauthenticate with the passkey: %v", err)
+	}
+	id := e.creds.creds[0].ID
+	if err := e.passkey.Delete(ctx, bob.ID, id, res.ReauthToken, ""); err != constant.ErrReauthRequired {
+		t.Errorf("Delete with the reauth of alice = %v, want ErrReauthRequired", err)
+	}
+	// the failed attempt spent the token
+	if err := e.passkey.Delete(ctx, alice.ID, id, res.ReauthToken, ""); err != constant.ErrReauthRequired {
+		t.Errorf("Delete with a spent reauth token = %v, want ErrReauthRequired", err)
+	}
+
+	res, err = e.service.Reauthenticate(ctx, e.passkeyReauth(t, a, alice))
+	if err != nil {
+		t.Fatalf("Reauthenticate with the passkey: %v", err)
+	}
+	if err := e.passkey.Delete(ctx, alice.ID, id, res.ReauthToken, ""); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+}
+
+// passkeyReauth answers the reauth options of user with a.
+func (e *passkeyTest) passkeyReauth(t *testing.T, a *webauthn.SoftAuthenticator, user *model.User) model.PasskeyReauthRequest {
+	t.Helper()
+
+	opts, err := e.service.ReauthOptions(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("ReauthOptions: %v", err)
+	}
+	var browserOpts webauthn.RequestOptions
+	jsonRoundTrip(t, opts, &browserOpts)
+
+	res, err := a.Get(testPasskeyOrigin, &browserOpts)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	req := model.PasskeyReauthRequest{UserID: user.ID, Username: user.Username}
+	jsonRoundTrip(t, res, &req.Credential)
+
+	return req
+}
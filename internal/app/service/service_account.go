@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"restapi/internal/app/model"
+	"restapi/internal/app/repository"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+	"restapi/internal/metrics"
+	"restapi/internal/security/token"
+	"restapi/internal/tracing"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const GrantClientCredentials = "client_credentials"
+
+type ServiceAccountService interface {
+	Create(ctx context.Context, req model.ServiceAccountCreateRequest) (*model.ServiceAccountResponse, error)
+	List(ctx context.Context) ([]*model.ServiceAccountResponse, error)
+	Disable(ctx context.Context, name string) (*model.ServiceAccountResponse, error)
+	RotateSecret(ctx context.Context, name string) (*model.ServiceAccountResponse, error)
+	IssueToken(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, error)
+}
+
+type serviceAccountService struct {
+	accountRepo  repository.ServiceAccountRepo
+	authRepo     repository.AuthRepo
+	auditService AuditService
+	tk           token.TokenInterface
+}
+
+func NewServiceAccountService(
+	accountRepo repository.ServiceAccountRepo,
+	authRepo repository.AuthRepo,
+	auditService AuditService,
+	tk token.TokenInterface,
+) ServiceAccountService {
+	return &serviceAccountService{accountRepo, authRepo, auditService, tk}
+}
+
+// Create returns the client secret in clear text, it cannot be read back
+// later.
+func (s *serviceAccountService) Create(ctx context.Context, req model.ServiceAccountCreateRequest) (*model.ServiceAccountResponse, error) {
+	ctx, span := tracing.Start(ctx, "serviceAccountService.Create")
+	defer span.End()
+
+	_, err := s.accountRepo.GetByName(ctx, req.Name)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Ctx(ctx).Err(err).Msg("failed to get service account by name")
+		return nil, constant.ErrServer
+	} else if err == nil {
+		return nil, constant.ErrServiceAccountExists
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate client id")
+		return nil, constant.ErrServer
+	}
+
+	account := &model.ServiceAccount{
+		Name:     req.Name,
+		ClientID: "sa_" + clientID,
+		Role:     req.Role,
+		Scopes:   strings.Join(req.Scopes, ","),
+	}
+	secret, err := s.newSecret(account)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate client secret")
+		return nil, constant.ErrServer
+	}
+
+	err = s.accountRepo.Create(ctx, account)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create service account")
+		return nil, constant.ErrServer
+	}
+
+	res := model.NewServiceAccountResponse(account)
+	res.ClientSecret = secret
+	return res, nil
+}
+
+func (s *serviceAccountService) List(ctx context.Context) ([]*model.ServiceAccountResponse, error) {
+	ctx, span := tracing.Start(ctx, "serviceAccountService.List")
+	defer span.End()
+
+	accounts, err := s.accountRepo.List(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to list service accounts")
+		return nil, constant.ErrServer
+	}
+
+	res := make([]*model.ServiceAccountResponse, len(accounts))
+	for i, account := range accounts {
+		res[i] = model.NewServiceAccountResponse(account)
+	}
+
+	return res, nil
+}
+
+// Disable stops issuing tokens to the account, the tokens already issued
+// live until they expire.
+func (s *serviceAccountService) Disable(ctx context.Context, name string) (*model.ServiceAccountResponse, error) {
+	ctx, span := tracing.Start(ctx, "serviceAccountService.Disable")
+	defer span.End()
+
+	account, err := s.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	account.IsDisabled = true
+	err = s.accountRepo.Update(ctx, account)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to disable service account")
+		return nil, constant.ErrServer
+	}
+
+	return model.NewServiceAccountResponse(account), nil
+}
+
+func (s *serviceAccountService) RotateSecret(ctx context.Context, name string) (*model.ServiceAccountResponse, error) {
+	ctx, span := tracing.Start(ctx, "serviceAccountService.RotateSecret")
+	defer span.End()
+
+	account, err := s.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := s.newSecret(account)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to generate client secret")
+		return nil, constant.ErrServer
+	}
+
+	err = s.accountRepo.Update(ctx, account)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to rotate service account secret")
+		return nil, constant.ErrServer
+	}
+
+	res := model.NewServiceAccountResponse(account)
+	res.ClientSecret = secret
+	return res, nil
+}
+
+// IssueToken implements the client_credentials grant. Every attempt with
+// a client id is audited.
+func (s *serviceAccountService) IssueToken(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "serviceAccountService.IssueToken")
+	defer span.End()
+
+	res, err := s.issueToken(ctx, req)
+
+	event := model.AuditEvent{
+		Action:    AuditOAuthToken,
+		ActorType: model.ActorServiceAccount,
+		ActorID:   req.ClientID,
+		IP:        req.IP,
+		Success:   err == nil,
+		Detail:    fmt.Sprintf("grant_type=%s scope=%q", req.GrantType, req.Scope),
+	}
+	if err != nil {
+		metrics.AuthEvent(metrics.EventClientTokenFailed)
+		event.Detail += " error=" + err.Error()
+	} else {
+		metrics.AuthEvent(metrics.EventClientToken)
+		event.Detail = fmt.Sprintf("grant_type=%s scope=%q", req.GrantType, res.Scope)
+	}
+	if req.ClientID != "" {
+		s.auditService.Record(ctx, event)
+	}
+
+	return res, err
+}
+
+func (s *serviceAccountService) issueToken(ctx context.Context, req model.OAuthTokenRequest) (*model.OAuthTokenResponse, error) {
+	if req.GrantType != GrantClientCredentials {
+		return nil, constant.ErrUnsupportedGrantType
+	}
+	if req.ClientID == "" || req.ClientSecret == "" {
+		return nil, constant.ErrInvalidClient
+	}
+
+	account, err := s.accountRepo.GetByClientID(ctx, req.ClientID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, constant.ErrInvalidClient
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get service account by client id")
+		return nil, constant.ErrServer
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAccessToken(req.ClientSecret)), []byte(account.SecretHash)) != 1 || account.IsDisabled {
+		return nil, constant.ErrInvalidClient
+	}
+
+	scopes, err := requestedScopes(req.Scope, account.ScopeList())
+	if err != nil {
+		return nil, err
+	}
+	scope := strings.Join(scopes, " ")
+
+	ttl := config.Cfg().OAuthAccessTokenTTL
+	claims := map[string]interface{}{
+		"client_id": account.ClientID,
+		"username":  account.Name,
+		"user_role": account.Role,
+		"scope":     scope,
+	}
+	td, err := s.tk.CreateAccessToken(claims, ttl)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to create client access token")
+		return nil, constant.ErrServer
+	}
+
+	// kept in redis like session tokens so it can be introspected and
+	// revoked
+	err = s.authRepo.CreateAuth(ctx, claims, td)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to store client access token")
+		return nil, constant.ErrServer
+	}
+
+	return &model.OAuthTokenResponse{
+		AccessToken: td.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ttl / time.Second),
+		Scope:       scope,
+	}, nil
+}
+
+func (s *serviceAccountService) get(ctx context.Context, name string) (*model.ServiceAccount, error) {
+	account, err := s.accountRepo.GetByName(ctx, name)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get service account by name")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrServiceAccountNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	return account, nil
+}
+
+// newSecret stores the hash of a new secret in account and returns the
+// secret.
+func (s *serviceAccountService) newSecret(account *model.ServiceAccount) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	account.SecretHash = hashAccessToken(secret)
+	return secret, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
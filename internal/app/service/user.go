@@ -1,76 +1,98 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"restapi/internal/app/model"
 	"restapi/internal/app/repository"
 	"restapi/internal/config"
 	"restapi/internal/constant"
 	"restapi/internal/logger"
+	"restapi/internal/security/password"
+	"restapi/internal/tracing"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type UserService interface {
-	Create(req model.UserCreateRequest) (*model.UserResponse, error)
-	Get(id uint) (*model.UserResponse, error)
-	List(req model.RequestDataTable) (*model.UserList, error)
-	Update(req model.UserUpdateRequest) (*model.UserResponse, error)
-	UpdatePassword(req model.UserPasswordUpdateRequest) (*model.UserResponse, error)
-	Delete(id uint) error
+	Create(ctx context.Context, req model.UserCreateRequest) (*model.UserResponse, error)
+	Get(ctx context.Context, id uint) (*model.UserResponse, error)
+	List(ctx context.Context, req model.RequestDataTable) (*model.UserList, error)
+	Update(ctx context.Context, req model.UserUpdateRequest) (*model.UserResponse, error)
+	UpdatePassword(ctx context.Context, req model.UserPasswordUpdateRequest) (*model.UserResponse, error)
+	ResetPassword(ctx context.Context, req model.UserPasswordResetRequest) (*model.UserResponse, error)
+	SetRole(ctx context.Context, req model.UserRoleUpdateRequest) (*model.UserResponse, error)
+	SetAuthSource(ctx context.Context, req model.UserAuthSourceUpdateRequest) (*model.UserResponse, error)
+	Disable(ctx context.Context, id uint) (*model.UserResponse, error)
+	Delete(ctx context.Context, id uint) error
 }
 
 type userService struct {
-	userRepo   repository.UserRepo
-	customRepo repository.CustomRepo
+	userRepo    repository.UserRepo
+	customRepo  repository.CustomRepo
+	historyRepo repository.PasswordHistoryRepo
 }
 
 func NewUserService(
 	userRepo repository.UserRepo,
 	CustomRepo repository.CustomRepo,
+	historyRepo repository.PasswordHistoryRepo,
 ) UserService {
-	return &userService{userRepo, CustomRepo}
+	return &userService{userRepo, CustomRepo, historyRepo}
 }
 
-func (s *userService) Create(req model.UserCreateRequest) (*model.UserResponse, error) {
-	_, err := s.userRepo.GetByUsername(req.Username)
+func (s *userService) Create(ctx context.Context, req model.UserCreateRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.Create")
+	defer span.End()
+
+	_, err := s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Log().Err(err).Msg("failed to get user by username")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
 		return nil, constant.ErrServer
+	} else if err == nil {
+		return nil, constant.ErrEmailRegistered
 	}
 
-	password, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	err = s.checkEmail(ctx, req.Email, 0)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to generate from password")
-		return nil, constant.ErrServer
+		return nil, err
 	}
 
 	user := &model.User{
 		Username: req.Username,
-		Password: string(password),
+		Email:    req.Email,
 		Role:     req.UserRole,
 	}
-	err = s.userRepo.Create(user)
+	err = s.setPassword(ctx, user, "password", req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.userRepo.Create(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create account")
+		logger.Ctx(ctx).Err(err).Msg("failed to create account")
 		return nil, constant.ErrServer
 	}
+	s.recordPassword(ctx, user)
 
-	user, err = s.userRepo.GetByUsername(req.Username)
+	user, err = s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to create account")
+		logger.Ctx(ctx).Err(err).Msg("failed to create account")
 		return nil, err
 	}
 
 	return model.NewUserResponse(user), nil
 }
 
-func (s *userService) Get(id uint) (*model.UserResponse, error) {
-	user, err := s.userRepo.Get(id)
+func (s *userService) Get(ctx context.Context, id uint) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.Get")
+	defer span.End()
+
+	user, err := s.userRepo.Get(ctx, id)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get user by id")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
 		switch err {
 		case gorm.ErrRecordNotFound:
 			return nil, constant.ErrUserNotFound
@@ -84,11 +106,14 @@ func (s *userService) Get(id uint) (*model.UserResponse, error) {
 	return res, nil
 }
 
-func (s *userService) List(req model.RequestDataTable) (*model.UserList, error) {
+func (s *userService) List(ctx context.Context, req model.RequestDataTable) (*model.UserList, error) {
+	ctx, span := tracing.Start(ctx, "userService.List")
+	defer span.End()
+
 	tableName := config.Cfg().DatabaseSchemaUser + ".users"
-	data, err := s.customRepo.List(req, model.UserResponse{}, tableName)
+	data, err := s.customRepo.List(ctx, req, model.UserResponse{}, tableName)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get list users")
+		logger.Ctx(ctx).Err(err).Msg("failed to get list users")
 		return nil, err
 	}
 
@@ -99,18 +124,26 @@ func (s *userService) List(req model.RequestDataTable) (*model.UserList, error)
 	return model.NewUserListResponse(users, data.Count), nil
 }
 
-func (s *userService) Update(req model.UserUpdateRequest) (*model.UserResponse, error) {
-	user, err := s.userRepo.GetByUsername(req.Username)
+func (s *userService) Update(ctx context.Context, req model.UserUpdateRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.Update")
+	defer span.End()
+
+	user, err := s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Log().Err(err).Msg("failed to get user by username")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by username")
 		return nil, constant.ErrServer
 	} else if err == nil && user.ID != req.ID {
 		return nil, constant.ErrEmailRegistered
 	}
 
-	user, err = s.userRepo.Get(req.ID)
+	err = s.checkEmail(ctx, req.Email, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = s.userRepo.Get(ctx, req.ID)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get user by id")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
 		switch err {
 		case gorm.ErrRecordNotFound:
 			return nil, constant.ErrUserNotFound
@@ -120,19 +153,23 @@ func (s *userService) Update(req model.UserUpdateRequest) (*model.UserResponse,
 	}
 
 	user.Username = req.Username
-	err = s.userRepo.Update(user)
+	user.Email = req.Email
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to update user")
+		logger.Ctx(ctx).Err(err).Msg("failed to update user")
 		return nil, constant.ErrServer
 	}
 
 	return model.NewUserResponse(user), nil
 }
 
-func (s *userService) UpdatePassword(req model.UserPasswordUpdateRequest) (*model.UserResponse, error) {
-	user, err := s.userRepo.Get(req.ID)
+func (s *userService) UpdatePassword(ctx context.Context, req model.UserPasswordUpdateRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.UpdatePassword")
+	defer span.End()
+
+	user, err := s.userRepo.Get(ctx, req.ID)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to get user by id")
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
 		switch err {
 		case gorm.ErrRecordNotFound:
 			return nil, constant.ErrUserNotFound
@@ -141,34 +178,209 @@ func (s *userService) UpdatePassword(req model.UserPasswordUpdateRequest) (*mode
 		}
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword))
-	if err != nil {
-		logger.Log().Err(err).Msg("wrong password")
+	ok, err := password.CurrentHasher().Verify(req.OldPassword, user.Password)
+	if errors.Is(err, password.ErrPepperChanged) {
+		logger.Ctx(ctx).Warn().Err(err).Uint("user_id", user.ID).Msg("password hash pepper is unknown")
+		return nil, constant.ErrWrongPassword
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to verify password")
+		return nil, constant.ErrServer
+	} else if !ok {
 		return nil, constant.ErrWrongPassword
 	}
 
-	password, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	err = s.setPassword(ctx, user, "new_password", req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	user.UpdatedAt = time.Now()
+
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to generate from password")
+		logger.Ctx(ctx).Err(err).Msg("failed to update user password")
 		return nil, constant.ErrServer
 	}
+	s.recordPassword(ctx, user)
 
-	user.Password = string(password)
-	user.UpdatedAt = time.Now()
+	return model.NewUserResponse(user), nil
+}
+
+func (s *userService) ResetPassword(ctx context.Context, req model.UserPasswordResetRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.ResetPassword")
+	defer span.End()
+
+	user, err := s.get(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.setPassword(ctx, user, "new_password", req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
 
-	err = s.userRepo.Update(user)
+	err = s.userRepo.Update(ctx, user)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to update user password")
+		logger.Ctx(ctx).Err(err).Msg("failed to reset user password")
 		return nil, constant.ErrServer
 	}
+	s.recordPassword(ctx, user)
 
 	return model.NewUserResponse(user), nil
 }
 
-func (s *userService) Delete(id uint) error {
-	err := s.userRepo.Delete(id)
+func (s *userService) SetRole(ctx context.Context, req model.UserRoleUpdateRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.SetRole")
+	defer span.End()
+
+	user, err := s.get(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = req.Role
+	err = s.userRepo.Update(ctx, user)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to update user role")
+		return nil, constant.ErrServer
+	}
+
+	return model.NewUserResponse(user), nil
+}
+
+func (s *userService) SetAuthSource(ctx context.Context, req model.UserAuthSourceUpdateRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.SetAuthSource")
+	defer span.End()
+
+	user, err := s.get(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.AuthSource = req.AuthSource
+	err = s.userRepo.Update(ctx, user)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to update user auth source")
+		return nil, constant.ErrServer
+	}
+
+	return model.NewUserResponse(user), nil
+}
+
+func (s *userService) Disable(ctx context.Context, id uint) (*model.UserResponse, error) {
+	ctx, span := tracing.Start(ctx, "userService.Disable")
+	defer span.End()
+
+	user, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.IsDisabled = true
+	err = s.userRepo.Update(ctx, user)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to disable user")
+		return nil, constant.ErrServer
+	}
+
+	return model.NewUserResponse(user), nil
+}
+
+// checkEmail refuses an email another user than id already has.
+func (s *userService) checkEmail(ctx context.Context, email string, id uint) error {
+	if email == "" {
+		return nil
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by email")
+		return constant.ErrServer
+	} else if err == nil && user.ID != id {
+		return constant.ErrEmailRegistered
+	}
+
+	return nil
+}
+
+// setPassword screens the new password against the breached list and the
+// password history, then hashes it into user. field names the request
+// field in the validation error.
+func (s *userService) setPassword(ctx context.Context, user *model.User, field, newPassword string) error {
+	policy := password.CurrentPolicy()
+
+	var previous []string
+	if user.ID != 0 && policy.History > 0 {
+		recent, err := s.historyRepo.Recent(ctx, user.ID, policy.History)
+		if err != nil {
+			logger.Ctx(ctx).Err(err).Msg("failed to get password history")
+			return constant.ErrServer
+		}
+		// accounts created before the history was kept only have their
+		// current password to compare with
+		previous = append(recent, user.Password)
+	}
+
+	err := policy.Screen(field, newPassword, previous)
+	if errors.Is(err, constant.ErrFieldValidation) {
+		return err
+	} else if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to screen password")
+		return constant.ErrServer
+	}
+
+	hash, err := password.CurrentHasher().Hash(newPassword)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to hash password")
+		return constant.ErrServer
+	}
+
+	now := time.Now()
+	user.Password = hash
+	user.PasswordChangedAt = &now
+
+	return nil
+}
+
+// recordPassword adds the password of user to its history. The password is
+// already changed at that point, a failure is only logged.
+func (s *userService) recordPassword(ctx context.Context, user *model.User) {
+	policy := password.CurrentPolicy()
+	if policy.History <= 0 {
+		return
+	}
+
+	err := s.historyRepo.Create(ctx, user.ID, user.Password)
+	if err == nil {
+		err = s.historyRepo.Prune(ctx, user.ID, policy.History)
+	}
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to record password history")
+	}
+}
+
+func (s *userService) get(ctx context.Context, id uint) (*model.User, error) {
+	user, err := s.userRepo.Get(ctx, id)
+	if err != nil {
+		logger.Ctx(ctx).Err(err).Msg("failed to get user by id")
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return nil, constant.ErrUserNotFound
+		default:
+			return nil, constant.ErrServer
+		}
+	}
+
+	return user, nil
+}
+
+func (s *userService) Delete(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "userService.Delete")
+	defer span.End()
+
+	err := s.userRepo.Delete(ctx, id)
 	if err != nil {
-		logger.Log().Err(err).Msg("failed to delete user")
+		logger.Ctx(ctx).Err(err).Msg("failed to delete user")
 		return constant.ErrServer
 	}
 
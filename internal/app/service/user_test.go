@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"restapi/internal/app/model"
+	"restapi/internal/constant"
+	"testing"
+)
+
+func TestUserEmailIsUnique(t *testing.T) {
+	loadConfig(t, map[string]string{"PASSWORD_BREACH_CHECK": "false", "PASSWORD_HISTORY": "0"})
+	s := NewUserService(newFakeUserRepo(), nil, nil)
+	ctx := context.Background()
+
+	alice, err := s.Create(ctx, model.UserCreateRequest{Username: "alice", Password: "Alice-Secret-1", Email: "alice@example.com", UserRole: "user"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if alice.Email != "alice@example.com" {
+		t.Errorf("email = %q, want the one of the request", alice.Email)
+	}
+
+	_, err = s.Create(ctx, model.UserCreateRequest{Username: "bobby", Password: "Bobby-Secret-1", Email: "Alice@Example.com", UserRole: "user"})
+	if err != constant.ErrEmailRegistered {
+		t.Errorf("Create with the email of alice = %v, want ErrEmailRegistered", err)
+	}
+
+	bob, err := s.Create(ctx, model.UserCreateRequest{Username: "bobby", Password: "Bobby-Secret-1", UserRole: "user"})
+	if err != nil {
+		t.Fatalf("Create without email: %v", err)
+	}
+
+	_, err = s.Update(ctx, model.UserUpdateRequest{ID: bob.ID, Username: "bobby", Email: "alice@example.com"})
+	if err != constant.ErrEmailRegistered {
+		t.Errorf("Update to the email of alice = %v, want ErrEmailRegistered", err)
+	}
+
+	// keeping its own email is not a conflict
+	res, err := s.Update(ctx, model.UserUpdateRequest{ID: alice.ID, Username: "alice", Email: "alice@example.com"})
+	if err != nil || res.Email != "alice@example.com" {
+		t.Errorf("Update keeping the email = %v", err)
+	}
+
+	res, err = s.Update(ctx, model.UserUpdateRequest{ID: bob.ID, Username: "bobby", Email: "bob@example.com"})
+	if err != nil || res.Email != "bob@example.com" {
+		t.Errorf("Update to a free email = %v", err)
+	}
+}
@@ -1,59 +1,515 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
 )
 
+const (
+	EnvDevelopment = "development"
+	EnvStaging     = "staging"
+	EnvProduction  = "production"
+)
+
 type Config struct {
-	DBDriver           string `mapstructure:"DB_DRIVER"`
-	DBUser             string `mapstructure:"DB_USER"`
-	DBPass             string `mapstructure:"DB_PASS"`
-	DBName             string `mapstructure:"DB_NAME"`
-	DBHost             string `mapstructure:"DB_HOST"`
-	DBPort             int    `mapstructure:"DB_PORT"`
-	RedisHost          string `mapstructure:"REDIS_HOST"`
-	RedisPort          int    `mapstructure:"REDIS_PORT"`
-	RedisDB            int    `mapstructure:"REDIS_DB"`
-	APPPort            int    `mapstructure:"APP_PORT"`
-	JwtSecretKey       string `mapstructure:"JWT_SECRET_KEY"`
-	JwtRefreshKey      string `mapstructure:"JWT_REFRESH_KEY"`
-	DatabaseSchemaUser string `mapstructure:"DATABASE_SCHEMA"`
-	WhitelistHost      string `mapstructure:"WHITELISTHOST"`
-}
-
-func load() *Config {
-	// viper.SetConfigType("env")
-	viper.AddConfigPath("./.development")
-	viper.SetConfigName("config")
-	viper.SetConfigType("yml")
-	// viper.AddConfigPath("../../.development")
-
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal("error reading config file ", err)
-		return &Config{}
-	}
-
-	return &Config{
-		DBDriver:           viper.GetString("DB_DRIVER"),
-		DBUser:             viper.GetString("DB_USER"),
-		DBPass:             viper.GetString("DB_PASS"),
-		DBName:             viper.GetString("DB_NAME"),
-		DBHost:             viper.GetString("DB_HOST"),
-		DBPort:             viper.GetInt("DB_PORT"),
-		RedisHost:          viper.GetString("REDIS_HOST"),
-		RedisPort:          viper.GetInt("REDIS_PORT"),
-		RedisDB:            viper.GetInt("REDIS_DB"),
-		APPPort:            viper.GetInt("APP_PORT"),
-		JwtSecretKey:       viper.GetString("JWT_SECRET_KEY"),
-		DatabaseSchemaUser: viper.GetString("DATABASE_SCHEMA"),
-		WhitelistHost:      viper.GetString("WHITELISTHOST"),
-	}
-}
-
-var config = load()
+	AppEnv                string        `mapstructure:"APP_ENV"`
+	DBDriver              string        `mapstructure:"DB_DRIVER"`
+	DBUser                string        `mapstructure:"DB_USER"`
+	DBPass                string        `mapstructure:"DB_PASS" secret:"true"`
+	DBName                string        `mapstructure:"DB_NAME"`
+	DBHost                string        `mapstructure:"DB_HOST"`
+	DBPort                int           `mapstructure:"DB_PORT"`
+	RedisHost             string        `mapstructure:"REDIS_HOST"`
+	RedisPort             int           `mapstructure:"REDIS_PORT"`
+	RedisDB               int           `mapstructure:"REDIS_DB"`
+	APPPort               int           `mapstructure:"APP_PORT"`
+	JwtSecretKey          string        `mapstructure:"JWT_SECRET_KEY" secret:"true"`
+	JwtRefreshKey         string        `mapstructure:"JWT_REFRESH_KEY" secret:"true"`
+	DatabaseSchemaUser    string        `mapstructure:"DATABASE_SCHEMA"`
+	DatabaseSchemas       string        `mapstructure:"DATABASE_SCHEMAS"`
+	WhitelistHost         string        `mapstructure:"WHITELISTHOST" reload:"true"`
+	SessionSecret         string        `mapstructure:"SESSION_SECRET" secret:"true"`
+	LogLevel              string        `mapstructure:"LOG_LEVEL" reload:"true"`
+	LogLevels             string        `mapstructure:"LOG_LEVELS" reload:"true"`
+	LogFormat             string        `mapstructure:"LOG_FORMAT"`
+	Features              string        `mapstructure:"FEATURES" reload:"true"`
+	RateLimits            string        `mapstructure:"RATE_LIMITS" reload:"true"`
+	ShutdownDelay         time.Duration `mapstructure:"SHUTDOWN_DELAY"`
+	ShutdownTimeout       time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	HTTPTimeout           time.Duration `mapstructure:"HTTP_TIMEOUT" reload:"true"`
+	RouteTimeouts         string        `mapstructure:"ROUTE_TIMEOUTS" reload:"true"`
+	AdminPort             int           `mapstructure:"ADMIN_PORT"`
+	TracingExporter       string        `mapstructure:"TRACING_EXPORTER"`
+	TracingSampleRatio    float64       `mapstructure:"TRACING_SAMPLE_RATIO"`
+	OtlpEndpoint          string        `mapstructure:"OTLP_ENDPOINT"`
+	OtlpInsecure          bool          `mapstructure:"OTLP_INSECURE"`
+	PasswordMinLength     int           `mapstructure:"PASSWORD_MIN_LENGTH" reload:"true"`
+	PasswordMaxLength     int           `mapstructure:"PASSWORD_MAX_LENGTH" reload:"true"`
+	PasswordClasses       string        `mapstructure:"PASSWORD_CLASSES" reload:"true"`
+	PasswordHistory       int           `mapstructure:"PASSWORD_HISTORY" reload:"true"`
+	PasswordMaxAge        time.Duration `mapstructure:"PASSWORD_MAX_AGE" reload:"true"`
+	PasswordBreachCheck   bool          `mapstructure:"PASSWORD_BREACH_CHECK" reload:"true"`
+	PasswordHashAlgorithm string        `mapstructure:"PASSWORD_HASH_ALGORITHM" reload:"true"`
+	PasswordArgon2Memory  uint32        `mapstructure:"PASSWORD_ARGON2_MEMORY" reload:"true"`
+	PasswordArgon2Time    uint32        `mapstructure:"PASSWORD_ARGON2_TIME" reload:"true"`
+	PasswordArgon2Threads uint8         `mapstructure:"PASSWORD_ARGON2_THREADS" reload:"true"`
+	PasswordBcryptCost    int           `mapstructure:"PASSWORD_BCRYPT_COST" reload:"true"`
+	PasswordPepper        string        `mapstructure:"PASSWORD_PEPPER" secret:"true"`
+	PasswordOldPeppers    string        `mapstructure:"PASSWORD_PREVIOUS_PEPPERS" secret:"true"`
+	OAuthAccessTokenTTL   time.Duration `mapstructure:"OAUTH_ACCESS_TOKEN_TTL" reload:"true"`
+	OAuthCodeTTL          time.Duration `mapstructure:"OAUTH_CODE_TTL" reload:"true"`
+	OIDCIssuer            string        `mapstructure:"OIDC_ISSUER"`
+	OIDCSigningKeyPEM     string        `mapstructure:"OIDC_SIGNING_KEY" secret:"true"`
+
+	// upstream identity providers, see OIDCProviders
+	OIDCProviderList         string `mapstructure:"OIDC_PROVIDERS" reload:"true"`
+	OIDCProviderCredentials  string `mapstructure:"OIDC_PROVIDER_CREDENTIALS" secret:"true" reload:"true"`
+	OIDCProviderRoleClaims   string `mapstructure:"OIDC_PROVIDER_ROLE_CLAIMS" reload:"true"`
+	OIDCProviderRoleMappings string `mapstructure:"OIDC_PROVIDER_ROLE_MAPPINGS" reload:"true"`
+
+	// password backends, see AuthBackendList and ldap.go
+	AuthBackends           string        `mapstructure:"AUTH_BACKENDS" reload:"true"`
+	LDAPURL                string        `mapstructure:"LDAP_URL" reload:"true"`
+	LDAPStartTLS           bool          `mapstructure:"LDAP_START_TLS" reload:"true"`
+	LDAPInsecureSkipVerify bool          `mapstructure:"LDAP_INSECURE_SKIP_VERIFY" reload:"true"`
+	LDAPBindDN             string        `mapstructure:"LDAP_BIND_DN" reload:"true"`
+	LDAPBindPassword       string        `mapstructure:"LDAP_BIND_PASSWORD" secret:"true" reload:"true"`
+	LDAPBaseDN             string        `mapstructure:"LDAP_BASE_DN" reload:"true"`
+	LDAPUserFilter         string        `mapstructure:"LDAP_USER_FILTER" reload:"true"`
+	LDAPGroupAttribute     string        `mapstructure:"LDAP_GROUP_ATTRIBUTE" reload:"true"`
+	LDAPEmailAttribute     string        `mapstructure:"LDAP_EMAIL_ATTRIBUTE" reload:"true"`
+	LDAPRoleMappingList    string        `mapstructure:"LDAP_ROLE_MAPPINGS" reload:"true"`
+	LDAPTimeout            time.Duration `mapstructure:"LDAP_TIMEOUT" reload:"true"`
+
+	// outgoing mail and magic links, see mail.go
+	Mailer         string        `mapstructure:"MAILER" reload:"true"`
+	MailFrom       string        `mapstructure:"MAIL_FROM" reload:"true"`
+	SMTPHost       string        `mapstructure:"SMTP_HOST" reload:"true"`
+	SMTPPort       int           `mapstructure:"SMTP_PORT" reload:"true"`
+	SMTPUsername   string        `mapstructure:"SMTP_USERNAME" reload:"true"`
+	SMTPPassword   string        `mapstructure:"SMTP_PASSWORD" secret:"true" reload:"true"`
+	MagicLinkRoles string        `mapstructure:"MAGIC_LINK_ROLES" reload:"true"`
+	MagicLinkTTL   time.Duration `mapstructure:"MAGIC_LINK_TTL" reload:"true"`
+
+	// passkeys, see webauthn.go
+	WebAuthnRPID             string        `mapstructure:"WEBAUTHN_RP_ID"`
+	WebAuthnRPName           string        `mapstructure:"WEBAUTHN_RP_NAME" reload:"true"`
+	WebAuthnOrigins          string        `mapstructure:"WEBAUTHN_ORIGINS" reload:"true"`
+	WebAuthnUserVerification string        `mapstructure:"WEBAUTHN_USER_VERIFICATION" reload:"true"`
+	WebAuthnTimeout          time.Duration `mapstructure:"WEBAUTHN_TIMEOUT" reload:"true"`
+
+	// admins acting as another user
+	ImpersonationTTL time.Duration `mapstructure:"IMPERSONATION_TTL" reload:"true"`
+}
+
+// defaults registers every key so environment variables override them even
+// when the key is missing from the config file.
+var defaults = map[string]interface{}{
+	"APP_ENV":                 EnvDevelopment,
+	"DB_DRIVER":               "postgres",
+	"DB_USER":                 "",
+	"DB_PASS":                 "",
+	"DB_NAME":                 "",
+	"DB_HOST":                 "",
+	"DB_PORT":                 5432,
+	"REDIS_HOST":              "",
+	"REDIS_PORT":              6379,
+	"REDIS_DB":                0,
+	"APP_PORT":                8080,
+	"JWT_SECRET_KEY":          "",
+	"JWT_REFRESH_KEY":         "",
+	"DATABASE_SCHEMA":         "",
+	"DATABASE_SCHEMAS":        "",
+	"WHITELISTHOST":           "",
+	"SESSION_SECRET":          "",
+	"LOG_LEVEL":               "info",
+	"LOG_LEVELS":              "",
+	"LOG_FORMAT":              "console",
+	"FEATURES":                "register",
+	"RATE_LIMITS":             "",
+	"SHUTDOWN_DELAY":          "5s",
+	"SHUTDOWN_TIMEOUT":        "30s",
+	"HTTP_TIMEOUT":            "30s",
+	"ROUTE_TIMEOUTS":          "",
+	"ADMIN_PORT":              9090,
+	"TRACING_EXPORTER":        "none",
+	"TRACING_SAMPLE_RATIO":    1.0,
+	"OTLP_ENDPOINT":           "localhost:4318",
+	"OTLP_INSECURE":           false,
+	"PASSWORD_MIN_LENGTH":     8,
+	"PASSWORD_MAX_LENGTH":     72,
+	"PASSWORD_CLASSES":        "letter,digit",
+	"PASSWORD_HISTORY":        5,
+	"PASSWORD_MAX_AGE":        "0s",
+	"PASSWORD_BREACH_CHECK":   true,
+	"PASSWORD_HASH_ALGORITHM": "argon2id",
+	"PASSWORD_ARGON2_MEMORY":  65536,
+	"PASSWORD_ARGON2_TIME":    3,
+	"PASSWORD_ARGON2_THREADS": 2,
+	"PASSWORD_BCRYPT_COST":    10,
+	"PASSWORD_PEPPER":         "",
+	"OAUTH_ACCESS_TOKEN_TTL":  "15m",
+	"OAUTH_CODE_TTL":          "1m",
+	"OIDC_ISSUER":             "",
+	"OIDC_SIGNING_KEY":        "",
+
+	"OIDC_PROVIDERS":              "",
+	"OIDC_PROVIDER_CREDENTIALS":   "",
+	"OIDC_PROVIDER_ROLE_CLAIMS":   "",
+	"OIDC_PROVIDER_ROLE_MAPPINGS": "",
+
+	"AUTH_BACKENDS":             AuthBackendLocal,
+	"LDAP_URL":                  "",
+	"LDAP_START_TLS":            false,
+	"LDAP_INSECURE_SKIP_VERIFY": false,
+	"LDAP_BIND_DN":              "",
+	"LDAP_BIND_PASSWORD":        "",
+	"LDAP_BASE_DN":              "",
+	"LDAP_USER_FILTER":          "(uid=%s)",
+	"LDAP_GROUP_ATTRIBUTE":      "memberOf",
+	"LDAP_EMAIL_ATTRIBUTE":      "mail",
+	"LDAP_ROLE_MAPPINGS":        "",
+	"LDAP_TIMEOUT":              "5s",
+
+	"MAILER":           MailerLog,
+	"MAIL_FROM":        "",
+	"SMTP_HOST":        "",
+	"SMTP_PORT":        587,
+	"SMTP_USERNAME":    "",
+	"SMTP_PASSWORD":    "",
+	"MAGIC_LINK_ROLES": "user",
+	"MAGIC_LINK_TTL":   "15m",
+
+	"WEBAUTHN_RP_ID":             "",
+	"WEBAUTHN_RP_NAME":           "restapi",
+	"WEBAUTHN_ORIGINS":           "",
+	"WEBAUTHN_USER_VERIFICATION": "preferred",
+	"WEBAUTHN_TIMEOUT":           "5m",
+
+	"IMPERSONATION_TTL": "15m",
+
+	"PASSWORD_PREVIOUS_PEPPERS": "",
+}
+
+var (
+	// mu guards v and the subscribers, current holds the *Config snapshot
+	// returned by Cfg and is swapped as a whole on reload.
+	mu      sync.Mutex
+	v       *viper.Viper
+	current atomic.Value
+)
+
+// Path returns the config file of a profile, ./.<env>/config.yml.
+func Path(env string) string {
+	return filepath.Join(".", "."+env, "config.yml")
+}
+
+// Load reads the config file, applies the environment variable overrides
+// and makes the result available through Cfg. When path is empty the profile
+// selected by APP_ENV is used and a missing file is not an error.
+func Load(path string) error {
+	nv := viper.New()
+	for key, value := range defaults {
+		nv.SetDefault(key, value)
+	}
+	nv.AutomaticEnv()
+
+	explicit := path != ""
+	if !explicit {
+		path = Path(nv.GetString("APP_ENV"))
+	}
+	nv.SetConfigFile(path)
+	nv.SetConfigType("yml")
+
+	err := nv.ReadInConfig()
+	if err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error reading config file %s: %w", path, err)
+		}
+		log.Printf("config file %s not found, using environment variables only", path)
+	}
+
+	cfg, err := decode(nv)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	v = nv
+	mu.Unlock()
+	current.Store(cfg)
+	return nil
+}
+
+func decode(v *viper.Viper) (*Config, error) {
+	cfg := new(Config)
+	err := v.Unmarshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding config: %w", err)
+	}
+
+	err = resolveSecrets(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving secrets: %w", err)
+	}
+
+	return cfg, nil
+}
 
+// Cfg returns the current config. The returned value must not be modified,
+// it is replaced by a new one when the config is reloaded.
 func Cfg() *Config {
-	return config
+	if cfg, ok := current.Load().(*Config); ok {
+		return cfg
+	}
+
+	mu.Lock()
+	loaded := v != nil
+	mu.Unlock()
+	if !loaded {
+		if err := Load(""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return current.Load().(*Config)
+}
+
+// Current returns the config without loading it when Load was not called
+// yet, for callers that must not fail like the logger.
+func Current() (*Config, bool) {
+	cfg, ok := current.Load().(*Config)
+	return cfg, ok
+}
+
+// PackageLogLevels parses LOG_LEVELS, a comma separated list of
+// package=level.
+func (c *Config) PackageLogLevels() map[string]string {
+	res := map[string]string{}
+	for _, entry := range strings.Split(c.LogLevels, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			res[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return res
+}
+
+// Enabled reports whether the feature flag is listed in FEATURES.
+func (c *Config) Enabled(feature string) bool {
+	for _, f := range strings.Split(c.Features, ",") {
+		if strings.TrimSpace(f) == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RouteTimeout returns the timeout of a route template, ROUTE_TIMEOUTS
+// entries taking precedence over HTTP_TIMEOUT. Zero means no timeout.
+func (c *Config) RouteTimeout(route string) time.Duration {
+	timeouts, err := c.routeTimeouts()
+	if err == nil {
+		if d, ok := timeouts[route]; ok {
+			return d
+		}
+	}
+
+	return c.HTTPTimeout
+}
+
+// routeTimeouts parses ROUTE_TIMEOUTS, a comma separated list of
+// /route/template=duration.
+func (c *Config) routeTimeouts() (map[string]time.Duration, error) {
+	res := map[string]time.Duration{}
+	for _, entry := range strings.Split(c.RouteTimeouts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q must be route=duration", entry)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q has an invalid duration", entry)
+		}
+		res[strings.TrimSpace(parts[0])] = d
+	}
+
+	return res, nil
+}
+
+// Validate reports every missing or malformed value at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	switch c.AppEnv {
+	case EnvDevelopment, EnvStaging, EnvProduction:
+	default:
+		problems = append(problems, fmt.Sprintf("APP_ENV must be one of %s, %s or %s, got %q", EnvDevelopment, EnvStaging, EnvProduction, c.AppEnv))
+	}
+
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"DB_HOST", c.DBHost},
+		{"DB_USER", c.DBUser},
+		{"DB_PASS", c.DBPass},
+		{"DB_NAME", c.DBName},
+		{"REDIS_HOST", c.RedisHost},
+		{"DATABASE_SCHEMA", c.DatabaseSchemaUser},
+		{"JWT_SECRET_KEY", c.JwtSecretKey},
+		{"JWT_REFRESH_KEY", c.JwtRefreshKey},
+		{"SESSION_SECRET", c.SessionSecret},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			problems = append(problems, r.key+" must not be empty")
+		}
+	}
+
+	ports := []struct {
+		key   string
+		value int
+	}{
+		{"APP_PORT", c.APPPort},
+		{"DB_PORT", c.DBPort},
+		{"REDIS_PORT", c.RedisPort},
+		{"ADMIN_PORT", c.AdminPort},
+	}
+	for _, p := range ports {
+		if p.value < 1 || p.value > 65535 {
+			problems = append(problems, fmt.Sprintf("%s must be between 1 and 65535, got %d", p.key, p.value))
+		}
+	}
+
+	if _, err := zerolog.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL %q is not a valid level", c.LogLevel))
+	}
+
+	for pkg, level := range c.PackageLogLevels() {
+		if _, err := zerolog.ParseLevel(level); err != nil {
+			problems = append(problems, fmt.Sprintf("LOG_LEVELS level %q of %s is not a valid level", level, pkg))
+		}
+	}
+
+	switch c.LogFormat {
+	case "console", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be console or json, got %q", c.LogFormat))
+	}
+
+	if c.AdminPort == c.APPPort {
+		problems = append(problems, "ADMIN_PORT must differ from APP_PORT")
+	}
+
+	switch c.TracingExporter {
+	case "none", "stdout", "otlp":
+	default:
+		problems = append(problems, fmt.Sprintf("TRACING_EXPORTER must be one of none, stdout or otlp, got %q", c.TracingExporter))
+	}
+
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		problems = append(problems, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	problems = append(problems, c.passwordProblems()...)
+
+	if c.OAuthAccessTokenTTL <= 0 {
+		problems = append(problems, "OAUTH_ACCESS_TOKEN_TTL must be positive")
+	}
+	if c.OAuthCodeTTL <= 0 || c.OAuthCodeTTL > 10*time.Minute {
+		problems = append(problems, "OAUTH_CODE_TTL must be positive and at most 10m")
+	}
+
+	problems = append(problems, c.oidcProblems()...)
+
+	if _, err := c.OIDCProviders(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	problems = append(problems, c.ldapProblems()...)
+	problems = append(problems, c.mailProblems()...)
+	problems = append(problems, c.webAuthnProblems()...)
+
+	if c.ImpersonationTTL <= 0 || c.ImpersonationTTL > time.Hour {
+		problems = append(problems, "IMPERSONATION_TTL must be positive and at most 1h")
+	}
+
+	if c.ShutdownDelay < 0 {
+		problems = append(problems, "SHUTDOWN_DELAY must not be negative")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if c.HTTPTimeout < 0 {
+		problems = append(problems, "HTTP_TIMEOUT must not be negative")
+	}
+
+	if _, err := c.routeTimeouts(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if _, err := c.RateLimitPolicies(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.JwtSecretKey != "" && c.JwtSecretKey == c.JwtRefreshKey {
+		problems = append(problems, "JWT_SECRET_KEY and JWT_REFRESH_KEY must differ")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Masked returns every key with its value, secrets replaced by asterisks.
+func (c *Config) Masked() map[string]interface{} {
+	res := map[string]interface{}{}
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+
+		value := v.Field(i).Interface()
+		if f.Tag.Get("secret") == "true" {
+			value = mask(fmt.Sprint(value))
+		}
+		res[key] = value
+	}
+
+	return res
+}
+
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "******"
 }
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// authentication backends of AUTH_BACKENDS
+const (
+	AuthBackendLocal = "local"
+	AuthBackendLDAP  = "ldap"
+)
+
+// AuthBackendList parses AUTH_BACKENDS, the backends a password is checked
+// against in order for users not bound to one.
+func (c *Config) AuthBackendList() ([]string, error) {
+	var res []string
+	for _, backend := range strings.Split(c.AuthBackends, ",") {
+		backend = strings.TrimSpace(backend)
+		switch backend {
+		case "":
+			continue
+		case AuthBackendLocal, AuthBackendLDAP:
+			res = append(res, backend)
+		default:
+			return nil, fmt.Errorf("AUTH_BACKENDS entry %q must be local or ldap", backend)
+		}
+	}
+
+	return res, nil
+}
+
+// AuthBackendEnabled reports whether backend is one of AUTH_BACKENDS.
+func (c *Config) AuthBackendEnabled(backend string) bool {
+	backends, _ := c.AuthBackendList()
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LDAPRoleMappings parses LDAP_ROLE_MAPPINGS, a ; separated list of
+// group=role in order of precedence, e.g.
+// cn=admins,ou=groups,dc=example,dc=com=admin;*=user. Group DNs are
+// compared in lower case.
+func (c *Config) LDAPRoleMappings() (RoleMappings, error) {
+	var res RoleMappings
+	for _, entry := range strings.Split(c.LDAPRoleMappingList, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// the role follows the last =, a DN has = of its own
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("LDAP_ROLE_MAPPINGS entry %q must be group=role", entry)
+		}
+		group, role := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		if !roleName.MatchString(role) {
+			return nil, fmt.Errorf("LDAP_ROLE_MAPPINGS role %q must be 1 to 5 letters", role)
+		}
+
+		res = append(res, RoleMapping{Value: strings.ToLower(group), Role: role})
+	}
+
+	return res, nil
+}
+
+func (c *Config) ldapProblems() []string {
+	var problems []string
+
+	backends, err := c.AuthBackendList()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if len(backends) == 0 {
+		problems = append(problems, "AUTH_BACKENDS must not be empty")
+	}
+
+	ldap := false
+	for _, backend := range backends {
+		ldap = ldap || backend == AuthBackendLDAP
+	}
+	if !ldap {
+		return problems
+	}
+
+	u, err := url.Parse(c.LDAPURL)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps"):
+		problems = append(problems, fmt.Sprintf("LDAP_URL %q must be an ldap:// or ldaps:// url", c.LDAPURL))
+	case u.Scheme == "ldaps" && c.LDAPStartTLS:
+		problems = append(problems, "LDAP_START_TLS must not be set with an ldaps:// LDAP_URL")
+	case u.Scheme == "ldap" && !c.LDAPStartTLS && c.AppEnv != EnvDevelopment:
+		problems = append(problems, "LDAP_URL must use ldaps:// or LDAP_START_TLS outside development")
+	}
+
+	if c.LDAPInsecureSkipVerify && c.AppEnv != EnvDevelopment {
+		problems = append(problems, "LDAP_INSECURE_SKIP_VERIFY must not be set outside development")
+	}
+
+	if c.LDAPBaseDN == "" {
+		problems = append(problems, "LDAP_BASE_DN must not be empty")
+	}
+
+	if strings.Count(c.LDAPUserFilter, "%s") != 1 || !strings.HasPrefix(c.LDAPUserFilter, "(") {
+		problems = append(problems, "LDAP_USER_FILTER must be a filter with one %s for the username")
+	}
+
+	if _, err := c.LDAPRoleMappings(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.LDAPTimeout <= 0 {
+		problems = append(problems, "LDAP_TIMEOUT must be positive")
+	}
+
+	return problems
+}
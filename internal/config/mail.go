@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// mailers of MAILER
+const (
+	// MailerLog writes the messages to the log instead of sending them.
+	MailerLog  = "log"
+	MailerSMTP = "smtp"
+)
+
+// FeatureMagicLink enables the passwordless login links of /api/login/magic.
+const FeatureMagicLink = "magic_link"
+
+// MagicLinkRoleList parses MAGIC_LINK_ROLES, the comma separated roles
+// allowed to log in with a link.
+func (c *Config) MagicLinkRoleList() ([]string, error) {
+	var res []string
+	for _, role := range strings.Split(c.MagicLinkRoles, ",") {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			continue
+		}
+		if !roleName.MatchString(role) {
+			return nil, fmt.Errorf("MAGIC_LINK_ROLES role %q must be 1 to 5 letters", role)
+		}
+		res = append(res, role)
+	}
+
+	return res, nil
+}
+
+func (c *Config) mailProblems() []string {
+	var problems []string
+
+	switch c.Mailer {
+	case MailerLog:
+		// the log would hold the login links
+		if c.Enabled(FeatureMagicLink) && c.AppEnv != EnvDevelopment {
+			problems = append(problems, "MAILER must not be log outside development while magic_link is enabled")
+		}
+	case MailerSMTP:
+		if c.SMTPHost == "" {
+			problems = append(problems, "SMTP_HOST must not be empty with MAILER smtp")
+		}
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			problems = append(problems, fmt.Sprintf("SMTP_PORT must be between 1 and 65535, got %d", c.SMTPPort))
+		}
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			problems = append(problems, fmt.Sprintf("MAIL_FROM %q must be an email address", c.MailFrom))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("MAILER must be log or smtp, got %q", c.Mailer))
+	}
+
+	if _, err := c.MagicLinkRoleList(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.MagicLinkTTL <= 0 || c.MagicLinkTTL > time.Hour {
+		problems = append(problems, "MAGIC_LINK_TTL must be positive and at most 1h")
+	}
+
+	return problems
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// minimum size of the id_token signing key
+const minSigningKeyBits = 2048
+
+// OIDCIssuerURL returns OIDC_ISSUER, by default the server on localhost.
+// It has no trailing slash so endpoints can be appended to it.
+func (c *Config) OIDCIssuerURL() string {
+	if c.OIDCIssuer == "" {
+		return fmt.Sprintf("http://localhost:%d", c.APPPort)
+	}
+
+	return strings.TrimSuffix(c.OIDCIssuer, "/")
+}
+
+// OIDCSigningKey parses OIDC_SIGNING_KEY, a PEM encoded RSA private key in
+// PKCS #1 or PKCS #8 form. Newlines may be escaped as \n so the key fits in
+// an environment variable. It returns nil when the key is not set.
+func (c *Config) OIDCSigningKey() (*rsa.PrivateKey, error) {
+	if strings.TrimSpace(c.OIDCSigningKeyPEM) == "" {
+		return nil, nil
+	}
+
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(c.OIDCSigningKeyPEM, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("OIDC_SIGNING_KEY is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("OIDC_SIGNING_KEY: %w", err)
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("OIDC_SIGNING_KEY: %w", err)
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("OIDC_SIGNING_KEY must be an RSA key")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("OIDC_SIGNING_KEY has unsupported PEM type %q", block.Type)
+	}
+
+	if key.N.BitLen() < minSigningKeyBits {
+		return nil, fmt.Errorf("OIDC_SIGNING_KEY must have at least %d bits", minSigningKeyBits)
+	}
+
+	return key, nil
+}
+
+func (c *Config) oidcProblems() []string {
+	var problems []string
+
+	if c.OIDCIssuer != "" {
+		u, err := url.Parse(c.OIDCIssuer)
+		switch {
+		case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+			problems = append(problems, fmt.Sprintf("OIDC_ISSUER %q must be an absolute http(s) url", c.OIDCIssuer))
+		case u.RawQuery != "" || u.Fragment != "":
+			problems = append(problems, "OIDC_ISSUER must not have a query or a fragment")
+		case u.Scheme != "https" && c.AppEnv != EnvDevelopment:
+			problems = append(problems, "OIDC_ISSUER must use https outside development")
+		}
+	} else if c.AppEnv != EnvDevelopment {
+		problems = append(problems, "OIDC_ISSUER must not be empty outside development")
+	}
+
+	key, err := c.OIDCSigningKey()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if key == nil && c.AppEnv != EnvDevelopment {
+		// a generated key would change on every restart and differ between
+		// replicas
+		problems = append(problems, "OIDC_SIGNING_KEY must not be empty outside development")
+	}
+
+	return problems
+}
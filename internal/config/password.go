@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// password character classes
+const (
+	PasswordClassLower  = "lower"
+	PasswordClassUpper  = "upper"
+	PasswordClassLetter = "letter"
+	PasswordClassDigit  = "digit"
+	PasswordClassSymbol = "symbol"
+)
+
+// bcrypt ignores everything after the 72nd byte.
+const maxPasswordLength = 72
+
+// PasswordClassList parses PASSWORD_CLASSES, the comma separated character
+// classes a password must contain.
+func (c *Config) PasswordClassList() ([]string, error) {
+	var res []string
+	for _, class := range strings.Split(c.PasswordClasses, ",") {
+		class = strings.TrimSpace(class)
+		switch class {
+		case "":
+			continue
+		case PasswordClassLower, PasswordClassUpper, PasswordClassLetter, PasswordClassDigit, PasswordClassSymbol:
+			res = append(res, class)
+		default:
+			return nil, fmt.Errorf("PASSWORD_CLASSES entry %q must be one of lower, upper, letter, digit or symbol", class)
+		}
+	}
+
+	return res, nil
+}
+
+// PasswordPreviousPepperList parses PASSWORD_PREVIOUS_PEPPERS, the comma
+// separated peppers replaced by PASSWORD_PEPPER. Hashes made with them are
+// still verified and rehashed with the current pepper at login.
+func (c *Config) PasswordPreviousPepperList() []string {
+	var res []string
+	for _, key := range strings.Split(c.PasswordOldPeppers, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			res = append(res, key)
+		}
+	}
+
+	return res
+}
+
+func (c *Config) passwordProblems() []string {
+	problems := []string{}
+
+	if c.PasswordMinLength < 1 {
+		problems = append(problems, "PASSWORD_MIN_LENGTH must be positive")
+	}
+
+	if c.PasswordMaxLength > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("PASSWORD_MAX_LENGTH must be at most %d", maxPasswordLength))
+	}
+
+	if c.PasswordMinLength > c.PasswordMaxLength {
+		problems = append(problems, "PASSWORD_MIN_LENGTH must not exceed PASSWORD_MAX_LENGTH")
+	}
+
+	if _, err := c.PasswordClassList(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.PasswordHistory < 0 {
+		problems = append(problems, "PASSWORD_HISTORY must not be negative")
+	}
+
+	if c.PasswordMaxAge < 0 {
+		problems = append(problems, "PASSWORD_MAX_AGE must not be negative")
+	}
+
+	switch c.PasswordHashAlgorithm {
+	case "argon2id", "bcrypt":
+	default:
+		problems = append(problems, fmt.Sprintf("PASSWORD_HASH_ALGORITHM must be argon2id or bcrypt, got %q", c.PasswordHashAlgorithm))
+	}
+
+	if c.PasswordArgon2Time < 1 {
+		problems = append(problems, "PASSWORD_ARGON2_TIME must be positive")
+	}
+
+	if c.PasswordArgon2Threads < 1 {
+		problems = append(problems, "PASSWORD_ARGON2_THREADS must be between 1 and 255")
+	}
+
+	// argon2 needs at least 8 KiB per lane
+	if c.PasswordArgon2Memory < 8*uint32(c.PasswordArgon2Threads) {
+		problems = append(problems, "PASSWORD_ARGON2_MEMORY must be at least 8 KiB per thread")
+	}
+
+	if c.PasswordBcryptCost < 4 || c.PasswordBcryptCost > 31 {
+		problems = append(problems, "PASSWORD_BCRYPT_COST must be between 4 and 31")
+	}
+
+	return problems
+}
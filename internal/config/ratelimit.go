@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rate limit keys
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByUser   = "user"
+	RateLimitByAPIKey = "apikey"
+)
+
+// RateLimitPolicy allows Limit requests per Period, with bursts of up to
+// Burst requests, counted per Key.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+	Key    string
+}
+
+// RateLimitPolicies parses RATE_LIMITS, a comma separated list of
+// name=limit/period:key[:burst], e.g. login=5/1m:ip:10. The burst defaults
+// to the limit.
+func (c *Config) RateLimitPolicies() (map[string]RateLimitPolicy, error) {
+	res := map[string]RateLimitPolicy{}
+	for _, entry := range strings.Split(c.RateLimits, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		p, err := parseRateLimitPolicy(entry)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMITS entry %q: %w", entry, err)
+		}
+		res[p.Name] = p
+	}
+
+	return res, nil
+}
+
+func parseRateLimitPolicy(entry string) (RateLimitPolicy, error) {
+	p := RateLimitPolicy{}
+
+	nameRule := strings.SplitN(entry, "=", 2)
+	if len(nameRule) != 2 {
+		return p, fmt.Errorf("must be name=limit/period:key[:burst]")
+	}
+	p.Name = strings.TrimSpace(nameRule[0])
+
+	parts := strings.Split(nameRule[1], ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return p, fmt.Errorf("must be name=limit/period:key[:burst]")
+	}
+
+	rate := strings.SplitN(parts[0], "/", 2)
+	if len(rate) != 2 {
+		return p, fmt.Errorf("rate must be limit/period")
+	}
+
+	var err error
+	p.Limit, err = strconv.Atoi(rate[0])
+	if err != nil || p.Limit <= 0 {
+		return p, fmt.Errorf("limit must be a positive number")
+	}
+
+	p.Period, err = time.ParseDuration(rate[1])
+	if err != nil || p.Period <= 0 {
+		return p, fmt.Errorf("period must be a positive duration")
+	}
+
+	p.Key = parts[1]
+	switch p.Key {
+	case RateLimitByIP, RateLimitByUser, RateLimitByAPIKey:
+	default:
+		return p, fmt.Errorf("key must be ip, user or apikey")
+	}
+
+	p.Burst = p.Limit
+	if len(parts) == 3 {
+		p.Burst, err = strconv.Atoi(parts[2])
+		if err != nil || p.Burst <= 0 {
+			return p, fmt.Errorf("burst must be a positive number")
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var subscribers []func(old, new *Config)
+
+// Subscribe registers fn to be called after every reload that changed the
+// config. fn must not block, it runs on the reloading goroutine.
+func Subscribe(fn func(old, new *Config)) {
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+// Watch reloads the config whenever its file changes on disk.
+func Watch() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if v == nil {
+		return
+	}
+	if _, err := os.Stat(v.ConfigFileUsed()); err != nil {
+		return
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		err := Reload()
+		if err != nil {
+			log.Printf("failed to reload config after %s: %v", e.Op, err)
+		}
+	})
+	v.WatchConfig()
+}
+
+// Reload reads the config again and atomically swaps the keys tagged
+// reload:"true". Changes to any other key are ignored with a warning until
+// the process is restarted. An invalid config is rejected as a whole.
+func Reload() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if v == nil {
+		return errors.New("config is not loaded")
+	}
+
+	err := v.ReadInConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading config file %s: %w", v.ConfigFileUsed(), err)
+	}
+
+	next, err := decode(v)
+	if err != nil {
+		return err
+	}
+
+	err = next.Validate()
+	if err != nil {
+		return err
+	}
+
+	old := current.Load().(*Config)
+	merged := merge(old, next)
+	if reflect.DeepEqual(old, merged) {
+		return nil
+	}
+
+	current.Store(merged)
+	log.Printf("config reloaded")
+	for _, fn := range subscribers {
+		fn(old, merged)
+	}
+
+	return nil
+}
+
+// merge returns a copy of old with the reloadable keys taken from next.
+func merge(old, next *Config) *Config {
+	merged := *old
+
+	mv := reflect.ValueOf(&merged).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := mv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+
+		if f.Tag.Get("reload") == "true" {
+			mv.Field(i).Set(nv.Field(i))
+		} else {
+			log.Printf("config key %s changed but cannot be reloaded, restart to apply it", f.Tag.Get("mapstructure"))
+		}
+	}
+
+	return &merged
+}
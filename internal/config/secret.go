@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	filePrefix = "file://"
+	encPrefix  = "enc:"
+
+	// MasterKeyEnv holds the base64 encoded AES-256 key used by enc: values,
+	// MasterKeyFileEnv points at a file containing it.
+	MasterKeyEnv     = "APP_MASTER_KEY"
+	MasterKeyFileEnv = "APP_MASTER_KEY_FILE"
+)
+
+// SecretProvider resolves config values that reference a secret instead of
+// containing it.
+type SecretProvider interface {
+	Supports(value string) bool
+	Resolve(value string) (string, error)
+}
+
+var providers = []SecretProvider{
+	fileProvider{},
+	encProvider{},
+}
+
+// RegisterSecretProvider adds a provider, tried before the built-in ones.
+func RegisterSecretProvider(p SecretProvider) {
+	providers = append([]SecretProvider{p}, providers...)
+}
+
+// ResolveSecret returns value unchanged unless a provider supports it.
+func ResolveSecret(value string) (string, error) {
+	for _, p := range providers {
+		if p.Supports(value) {
+			return p.Resolve(value)
+		}
+	}
+
+	return value, nil
+}
+
+// resolveSecrets resolves every string field of cfg in place.
+func resolveSecrets(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.String {
+			continue
+		}
+
+		value, err := ResolveSecret(f.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Field(i).Tag.Get("mapstructure"), err)
+		}
+		f.SetString(value)
+	}
+
+	return nil
+}
+
+// fileProvider reads file:///run/secrets/db_pass style values, as mounted by
+// Docker and Kubernetes secrets.
+type fileProvider struct{}
+
+func (fileProvider) Supports(value string) bool {
+	return strings.HasPrefix(value, filePrefix)
+}
+
+func (fileProvider) Resolve(value string) (string, error) {
+	b, err := os.ReadFile(strings.TrimPrefix(value, filePrefix))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// encProvider decrypts enc:<base64 nonce+ciphertext> values with AES-GCM.
+type encProvider struct{}
+
+func (encProvider) Supports(value string) bool {
+	return strings.HasPrefix(value, encPrefix)
+}
+
+func (encProvider) Resolve(value string) (string, error) {
+	key, err := MasterKey()
+	if err != nil {
+		return "", err
+	}
+
+	return Decrypt(value, key)
+}
+
+// MasterKey reads the master key from APP_MASTER_KEY or APP_MASTER_KEY_FILE.
+func MasterKey() ([]byte, error) {
+	encoded := os.Getenv(MasterKeyEnv)
+	if path := os.Getenv(MasterKeyFileEnv); encoded == "" && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimSpace(string(b))
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("%s or %s must be set to use encrypted values", MasterKeyEnv, MasterKeyFileEnv)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+
+	return key, nil
+}
+
+// NewMasterKey returns a random base64 encoded master key.
+func NewMasterKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Encrypt returns the enc: value of plain.
+func Encrypt(plain string, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plain text of an enc: value.
+func Decrypt(value string, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil {
+		return "", fmt.Errorf("encrypted value is not valid base64: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value, wrong master key?")
+	}
+
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// defaultRoleClaim is read for the role mapping of a provider without an
+// OIDC_PROVIDER_ROLE_CLAIMS entry.
+const defaultRoleClaim = "roles"
+
+// AnyClaimValue maps every user of a provider to a role, whatever their
+// role claim.
+const AnyClaimValue = "*"
+
+var (
+	providerName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+	roleName     = regexp.MustCompile(`^[a-zA-Z]{1,5}$`)
+)
+
+// OIDCProvider is an upstream OpenID Connect identity provider users can
+// log in with.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RoleClaim is the id_token claim holding the groups or roles of the
+	// user, a string or a list of strings.
+	RoleClaim string
+	// RoleMappings map a value of RoleClaim to a role in the configured
+	// order, the first match wins.
+	RoleMappings RoleMappings
+}
+
+// RoleMapping gives Role to the users with Value in their role claim or
+// groups, AnyClaimValue matches every user.
+type RoleMapping struct {
+	Value string
+	Role  string
+}
+
+type RoleMappings []RoleMapping
+
+// Role returns the role of the first mapping matching values.
+func (mappings RoleMappings) Role(values []string) (string, bool) {
+	for _, m := range mappings {
+		if m.Value == AnyClaimValue {
+			return m.Role, true
+		}
+		for _, v := range values {
+			if v == m.Value {
+				return m.Role, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// OIDCProviders parses the upstream providers:
+//   - OIDC_PROVIDERS, a comma separated list of name=issuer,
+//   - OIDC_PROVIDER_CREDENTIALS, name=client_id:client_secret per provider,
+//   - OIDC_PROVIDER_ROLE_CLAIMS, name=claim, roles by default,
+//   - OIDC_PROVIDER_ROLE_MAPPINGS, name:value=role, e.g. corp:admins=admin,
+//     in order of precedence.
+func (c *Config) OIDCProviders() (map[string]OIDCProvider, error) {
+	res := map[string]OIDCProvider{}
+
+	issuers, err := pairs("OIDC_PROVIDERS", c.OIDCProviderList, "=")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range issuers {
+		name, issuer := kv[0], kv[1]
+		if !providerName.MatchString(name) {
+			return nil, fmt.Errorf("OIDC_PROVIDERS name %q must be lower case letters, digits, - or _", name)
+		}
+		u, err := url.Parse(issuer)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("OIDC_PROVIDERS issuer %q of %s must be an absolute http(s) url", issuer, name)
+		}
+		if u.Scheme != "https" && c.AppEnv != EnvDevelopment {
+			return nil, fmt.Errorf("OIDC_PROVIDERS issuer of %s must use https outside development", name)
+		}
+
+		res[name] = OIDCProvider{
+			Name:      name,
+			Issuer:    strings.TrimSuffix(issuer, "/"),
+			RoleClaim: defaultRoleClaim,
+		}
+	}
+
+	credentials, err := pairs("OIDC_PROVIDER_CREDENTIALS", c.OIDCProviderCredentials, "=")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range credentials {
+		name, credential := kv[0], kv[1]
+		p, ok := res[name]
+		if !ok {
+			return nil, fmt.Errorf("OIDC_PROVIDER_CREDENTIALS has unknown provider %q", name)
+		}
+
+		idSecret := strings.SplitN(credential, ":", 2)
+		if len(idSecret) != 2 || idSecret[0] == "" {
+			return nil, fmt.Errorf("OIDC_PROVIDER_CREDENTIALS entry of %s must be name=client_id:client_secret", name)
+		}
+		p.ClientID, p.ClientSecret = idSecret[0], idSecret[1]
+		res[name] = p
+	}
+
+	claims, err := pairs("OIDC_PROVIDER_ROLE_CLAIMS", c.OIDCProviderRoleClaims, "=")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range claims {
+		name, claim := kv[0], kv[1]
+		p, ok := res[name]
+		if !ok {
+			return nil, fmt.Errorf("OIDC_PROVIDER_ROLE_CLAIMS has unknown provider %q", name)
+		}
+		p.RoleClaim = claim
+		res[name] = p
+	}
+
+	mappings, err := pairs("OIDC_PROVIDER_ROLE_MAPPINGS", c.OIDCProviderRoleMappings, "=")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range mappings {
+		key, role := kv[0], kv[1]
+		nameValue := strings.SplitN(key, ":", 2)
+		if len(nameValue) != 2 || nameValue[1] == "" {
+			return nil, fmt.Errorf("OIDC_PROVIDER_ROLE_MAPPINGS entry %q must be name:value=role", key)
+		}
+		p, ok := res[nameValue[0]]
+		if !ok {
+			return nil, fmt.Errorf("OIDC_PROVIDER_ROLE_MAPPINGS has unknown provider %q", nameValue[0])
+		}
+		if !roleName.MatchString(role) {
+			return nil, fmt.Errorf("OIDC_PROVIDER_ROLE_MAPPINGS role %q must be 1 to 5 letters", role)
+		}
+		p.RoleMappings = append(p.RoleMappings, RoleMapping{Value: nameValue[1], Role: role})
+		res[p.Name] = p
+	}
+
+	for name, p := range res {
+		if p.ClientID == "" {
+			return nil, fmt.Errorf("OIDC_PROVIDER_CREDENTIALS has no entry for %s", name)
+		}
+	}
+
+	return res, nil
+}
+
+// pairs parses a comma separated list of key<sep>value, in order.
+func pairs(key, list, sep string) ([][2]string, error) {
+	var res [][2]string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, sep, 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("%s entry %q must be key%svalue", key, entry, sep)
+		}
+		res = append(res, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+	}
+
+	return res, nil
+}
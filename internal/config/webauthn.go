@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebAuthnRelyingPartyID returns WEBAUTHN_RP_ID, by default the host of the
+// issuer. Passkeys are bound to it, changing it orphans them.
+func (c *Config) WebAuthnRelyingPartyID() string {
+	if c.WebAuthnRPID != "" {
+		return c.WebAuthnRPID
+	}
+
+	u, err := url.Parse(c.OIDCIssuerURL())
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// WebAuthnOriginList parses WEBAUTHN_ORIGINS, the comma separated origins of
+// the pages running the ceremonies, by default the origin of the issuer.
+func (c *Config) WebAuthnOriginList() []string {
+	var res []string
+	for _, origin := range strings.Split(c.WebAuthnOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			res = append(res, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if len(res) > 0 {
+		return res
+	}
+
+	u, err := url.Parse(c.OIDCIssuerURL())
+	if err != nil {
+		return nil
+	}
+	return []string{u.Scheme + "://" + u.Host}
+}
+
+func (c *Config) webAuthnProblems() []string {
+	var problems []string
+
+	rpID := c.WebAuthnRelyingPartyID()
+	if rpID == "" || strings.ContainsAny(rpID, ":/") {
+		problems = append(problems, fmt.Sprintf("WEBAUTHN_RP_ID %q must be a domain", rpID))
+	}
+
+	for _, origin := range c.WebAuthnOriginList() {
+		u, err := url.Parse(origin)
+		switch {
+		case err != nil || u.Host == "" || u.Path != "" || (u.Scheme != "https" && u.Scheme != "http"):
+			problems = append(problems, fmt.Sprintf("WEBAUTHN_ORIGINS entry %q must be an http(s) origin", origin))
+		case u.Scheme != "https" && u.Hostname() != "localhost":
+			// browsers only run the ceremonies in a secure context
+			problems = append(problems, fmt.Sprintf("WEBAUTHN_ORIGINS entry %q must use https", origin))
+		case u.Hostname() != rpID && !strings.HasSuffix(u.Hostname(), "."+rpID):
+			problems = append(problems, fmt.Sprintf("WEBAUTHN_ORIGINS entry %q is not within WEBAUTHN_RP_ID %q", origin, rpID))
+		}
+	}
+
+	switch c.WebAuthnUserVerification {
+	case "required", "preferred":
+	default:
+		problems = append(problems, fmt.Sprintf("WEBAUTHN_USER_VERIFICATION must be required or preferred, got %q", c.WebAuthnUserVerification))
+	}
+
+	if c.WebAuthnTimeout < 30*time.Second || c.WebAuthnTimeout > 10*time.Minute {
+		problems = append(problems, "WEBAUTHN_TIMEOUT must be between 30s and 10m")
+	}
+
+	return problems
+}
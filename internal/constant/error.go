@@ -1,30 +1,118 @@
 package constant
 
 import (
-	"errors"
-	"fmt"
-
-	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
 )
 
+// Error is an application error with a stable machine-readable code and the
+// HTTP status it is answered with. Clients match on Code, the message may
+// change.
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func newError(code string, status int, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
 var (
-	ErrServer = errors.New("something went wrong")
+	ErrServer = newError("internal_error", http.StatusInternalServerError, "something went wrong")
+
+	ErrUrlPathParameter  = newError("invalid_path_parameter", http.StatusBadRequest, "invalid url path parameter")
+	ErrUrlQueryParameter = newError("invalid_query_parameter", http.StatusBadRequest, "invalid url query parameter")
+	ErrRequestBody       = newError("invalid_request_body", http.StatusBadRequest, "invalid request body")
+	ErrUnauthenticated   = newError("unauthenticated", http.StatusUnauthorized, "authentication is required")
+	ErrUnauthorized      = newError("unauthorized", http.StatusUnauthorized, "you are not authorized to perform this action")
+	ErrForbidden         = newError("forbidden", http.StatusForbidden, "you do not have permission to perform this action")
+	ErrNotFound          = newError("not_found", http.StatusNotFound, "not found")
+	ErrFieldValidation   = newError("validation_failed", http.StatusUnprocessableEntity, "field is not valid")
+	ErrTooManyRequests   = newError("too_many_requests", http.StatusTooManyRequests, "too many requests")
+	ErrRequestTimeout    = newError("request_timeout", http.StatusGatewayTimeout, "request timed out")
+	ErrNotReady          = newError("not_ready", http.StatusServiceUnavailable, "service is not ready")
+
+	ErrCaptchaTimeout  = newError("captcha_timeout", http.StatusBadRequest, "captcha timeout")
+	ErrCaptchaEmpty    = newError("captcha_empty", http.StatusBadRequest, "captcha cannot empty")
+	ErrCaptchaMismatch = newError("captcha_mismatch", http.StatusBadRequest, "captcha not match")
+
+	ErrUserNotFound          = newError("user_not_found", http.StatusNotFound, "user not found")
+	ErrEmailRegistered       = newError("email_registered", http.StatusConflict, "email already in use")
+	ErrEmailNotRegistered    = newError("email_not_registered", http.StatusUnauthorized, "email not registered")
+	ErrUserNameNotRegistered = newError("username_not_registered", http.StatusUnauthorized, "username not registered")
+	ErrWrongPassword         = newError("wrong_password", http.StatusUnauthorized, "password incorrect")
+	ErrUserDisabled          = newError("user_disabled", http.StatusUnauthorized, "user is disabled")
+	ErrAlreadyLoggedIn       = newError("already_logged_in", http.StatusConflict, "user is already logged in another device")
+	ErrRefreshToken          = newError("invalid_refresh_token", http.StatusUnauthorized, "refresh token not valid")
+	ErrPasswordExpired       = newError("password_expired", http.StatusForbidden, "password expired, change it to continue")
+	ErrAccessTokenNotFound   = newError("access_token_not_found", http.StatusNotFound, "access token not found")
+	ErrInsufficientScope     = newError("insufficient_scope", http.StatusForbidden, "the token does not have the scope required")
+	ErrSessionRequired       = newError("session_required", http.StatusForbidden, "the operation needs a session from the login, not an access token")
 
-	ErrUrlPathParameter  = errors.New("invalid url path parameter")
-	ErrUrlQueryParameter = errors.New("invalid url query parameter")
-	ErrRequestBody       = errors.New("invalid request body")
-	ErrUnauthorized      = errors.New("you are not authorized to perform this action")
-	ErrFieldValidation   = errors.New("field is not valid")
+	ErrServiceAccountNotFound = newError("service_account_not_found", http.StatusNotFound, "service account not found")
+	ErrServiceAccountExists   = newError("service_account_exists", http.StatusConflict, "service account name already in use")
+	ErrOAuthClientNotFound    = newError("oauth_client_not_found", http.StatusNotFound, "oauth client not found")
 
-	ErrUserNotFound          = errors.New("user not found")
-	ErrEmailRegistered       = errors.New("email already in use")
-	ErrEmailNotRegistered    = errors.New("email not registered")
-	ErrUserNameNotRegistered = errors.New("username not registered")
-	ErrWrongPassword         = errors.New("password incorrect")
+	// errors of the login with an upstream identity provider
+	ErrProviderNotFound = newError("provider_not_found", http.StatusNotFound, "identity provider not found")
+	ErrExternalLogin    = newError("external_login_failed", http.StatusBadGateway, "the identity provider could not log you in")
+	ErrLoginState       = newError("invalid_login_state", http.StatusBadRequest, "the login expired or was started in another browser")
+	ErrNoRoleMapping    = newError("no_role_mapping", http.StatusForbidden, "your account at the identity provider has no role here")
+	ErrInvalidReturnTo  = newError("invalid_return_to", http.StatusBadRequest, "return_to is not an allowed origin")
+	ErrIdentityLinked   = newError("identity_linked", http.StatusConflict, "the identity is already linked to a user")
 
-	ErrRecordNotFound = errors.New("record not found")
+	ErrPasskeyRequired     = newError("passkey_required", http.StatusUnauthorized, "the account logs in with a passkey")
+	ErrPasskeyLogin        = newError("passkey_login_failed", http.StatusUnauthorized, "the passkey could not be verified")
+	ErrPasskeyRegistration = newError("passkey_registration_failed", http.StatusBadRequest, "the passkey could not be registered")
+	ErrPasskeyNotFound     = newError("passkey_not_found", http.StatusNotFound, "passkey not found")
+	ErrPasskeyRegistered   = newError("passkey_registered", http.StatusConflict, "the passkey is already registered")
+	ErrPasskeyLimit        = newError("passkey_limit", http.StatusConflict, "the account has too many passkeys")
+	ErrReauthRequired      = newError("reauth_required", http.StatusUnauthorized, "confirm your password or passkey first")
+
+	ErrImpersonation       = newError("impersonation_forbidden", http.StatusForbidden, "the operation is not allowed while impersonating a user")
+	ErrImpersonationTarget = newError("impersonation_target_forbidden", http.StatusForbidden, "the user cannot be impersonated")
+	ErrImpersonationToken  = newError("invalid_impersonation_token", http.StatusBadRequest, "the token is not an active impersonation token")
+
+	ErrMagicLink = newError("invalid_magic_link", http.StatusBadRequest, "the login link is invalid, expired or was requested in another browser")
+
+	// OAuth2 errors, their codes are the ones of RFC 6749 section 5.2
+	ErrInvalidRequest       = newError("invalid_request", http.StatusBadRequest, "the request is missing a parameter or is malformed")
+	ErrInvalidClient        = newError("invalid_client", http.StatusUnauthorized, "client authentication failed")
+	ErrInvalidGrant         = newError("invalid_grant", http.StatusBadRequest, "the grant is invalid, expired or revoked")
+	ErrUnsupportedGrantType = newError("unsupported_grant_type", http.StatusBadRequest, "the grant type is not supported")
+	ErrInvalidScope         = newError("invalid_scope", http.StatusBadRequest, "the requested scope is invalid")
+	ErrAccessDenied         = newError("access_denied", http.StatusForbidden, "the user denied the request")
+	ErrInvalidRedirectURI   = newError("invalid_redirect_uri", http.StatusBadRequest, "the redirect uri is not registered for the client")
+	// the response_type error of RFC 6749 section 4.1.2.1
+	ErrUnsupportedResponseType = newError("unsupported_response_type", http.StatusBadRequest, "the response type is not supported")
+
+	ErrRecordNotFound = newError("record_not_found", http.StatusNotFound, "record not found")
 )
 
-func NewErrFieldValidation(err validator.FieldError) error {
-	return fmt.Errorf("%s: %w; format must be (%s=%s)", err.Field(), ErrFieldValidation, err.ActualTag(), err.Param())
+// FieldError describes one invalid field of a request: the rule it fails,
+// e.g. min, with its parameter, e.g. 8.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
 }
+
+// ValidationError lists every invalid field of a request, it matches
+// ErrFieldValidation with errors.Is.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrFieldValidation }
@@ -2,32 +2,183 @@ package migration
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"restapi/internal/app/model"
+	"restapi/internal/config"
 	"restapi/internal/db/postgres"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
-func Up() error {
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// models lists every table owned by the migrations, in creation order.
+func models() []interface{} {
+	return []interface{}{
+		&model.Role{},
+		&model.User{},
+		&model.PasswordHistory{},
+		&model.AccessToken{},
+		&model.ServiceAccount{},
+		&model.AuditEvent{},
+		&model.OAuthClient{},
+		&model.OAuthConsent{},
+		&model.UserIdentity{},
+		&model.WebAuthnCredential{},
+	}
+}
+
+// Schemas returns the configured schemas: DATABASE_SCHEMA first, followed by
+// any extra schemas listed in DATABASE_SCHEMAS.
+func Schemas() []string {
+	schemas := []string{config.Cfg().DatabaseSchemaUser}
+	for _, s := range strings.Split(config.Cfg().DatabaseSchemas, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" && !contains(schemas, s) {
+			schemas = append(schemas, s)
+		}
+	}
+
+	return schemas
+}
+
+func Up(schemas ...string) error {
 	pg, err := postgres.NewClient()
 	if err != nil {
 		return err
 	}
+	defer pg.Close()
 
-	err = pg.Conn().AutoMigrate(
-		&model.User{},
-	)
-	return ignoreErrNoChange(err)
+	if len(schemas) == 0 {
+		schemas = Schemas()
+	}
+
+	for _, schema := range schemas {
+		err = UpSchema(pg.Conn(), schema)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpSchema creates the schema when it does not exist yet and migrates every
+// model into it.
+func UpSchema(db *gorm.DB, schema string) error {
+	if !schemaName.MatchString(schema) {
+		return fmt.Errorf("invalid schema name %q", schema)
+	}
+
+	err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schema)).Error
+	if err != nil {
+		return err
+	}
+
+	for _, m := range models() {
+		err = tableIn(db, schema, m).AutoMigrate(m)
+		if err = ignoreErrNoChange(err); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func Drop() error {
+// Pending lists the tables and columns of the configured schemas that the
+// migrations would still create, as schema.table or schema.table.column.
+func Pending(db *gorm.DB) ([]string, error) {
+	pending := []string{}
+	for _, schema := range Schemas() {
+		for _, m := range models() {
+			tx := tableIn(db, schema, m)
+			stmt := &gorm.Statement{DB: tx}
+			err := stmt.ParseWithSpecialTableName(m, tx.Statement.Table)
+			if err != nil {
+				return nil, err
+			}
+
+			if !tx.Migrator().HasTable(m) {
+				pending = append(pending, stmt.Table)
+				continue
+			}
+
+			for _, name := range stmt.Schema.DBNames {
+				if !tx.Migrator().HasColumn(m, name) {
+					pending = append(pending, stmt.Table+"."+name)
+				}
+			}
+		}
+	}
+
+	return pending, nil
+}
+
+func Drop(schemas ...string) error {
 	pg, err := postgres.NewClient()
 	if err != nil {
 		return err
 	}
+	defer pg.Close()
 
-	err = pg.Conn().Migrator().DropTable(
-		&model.User{},
-	)
-	return ignoreErrNoChange(err)
+	if len(schemas) == 0 {
+		schemas = Schemas()
+	}
+
+	for _, schema := range schemas {
+		err = DropSchema(pg.Conn(), schema)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropSchema drops every model table and then the schema itself, together
+// with anything else that was created inside it.
+func DropSchema(db *gorm.DB, schema string) error {
+	if !schemaName.MatchString(schema) {
+		return fmt.Errorf("invalid schema name %q", schema)
+	}
+
+	ms := models()
+	for i := len(ms) - 1; i >= 0; i-- {
+		err := tableIn(db, schema, ms[i]).Migrator().DropTable(ms[i])
+		if err = ignoreErrNoChange(err); err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema)).Error
+}
+
+// tableIn scopes db to the table of m inside the given schema, whatever
+// schema the model's TableName points at.
+func tableIn(db *gorm.DB, schema string, m interface{}) *gorm.DB {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(m); err != nil {
+		return db
+	}
+
+	table := stmt.Table
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+
+	return db.Table(schema + "." + table)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
 }
 
 func ignoreErrNoChange(err error) error {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"restapi/internal/config"
+	"restapi/internal/metrics"
+	"restapi/internal/tracing"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -16,12 +18,15 @@ type Client interface {
 }
 
 func NewClientPG() (Client, error) {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable TimeZone=Asia/Jakarta",
+	// search_path defaults to the configured schema so unqualified queries
+	// resolve to the tables created by the migrations.
+	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable TimeZone=Asia/Jakarta search_path=%s,public",
 		config.Cfg().DBHost,
 		config.Cfg().DBPort,
 		config.Cfg().DBUser,
 		config.Cfg().DBName,
 		config.Cfg().DBPass,
+		config.Cfg().DatabaseSchemaUser,
 	)
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
@@ -31,6 +36,16 @@ func NewClientPG() (Client, error) {
 		panic(err)
 	}
 
+	err = metrics.RegisterGORM(db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tracing.RegisterGORM(db)
+	if err != nil {
+		return nil, err
+	}
+
 	test, _ := db.DB()
 	err = test.PingContext(context.Background())
 	if err != nil {
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"restapi/internal/config"
+	"restapi/internal/metrics"
+	"restapi/internal/tracing"
 
 	redis "github.com/go-redis/redis/v8"
 )
@@ -18,6 +20,9 @@ func NewClientRDS() (Client, error) {
 		Addr: fmt.Sprintf("%s:%d", config.Cfg().RedisHost, config.Cfg().RedisPort),
 		DB:   config.Cfg().RedisDB,
 	})
+	db.AddHook(metrics.RedisHook{})
+	db.AddHook(tracing.RedisHook{})
+
 	err := db.Ping(context.Background()).Err()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,181 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"restapi/internal/app/model"
+	"restapi/internal/db/postgres"
+	"restapi/internal/logger"
+	"restapi/internal/security/password"
+	"restapi/internal/validation"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fixture is the content of one fixture file. JSON files use the same keys
+// since JSON is valid YAML.
+type Fixture struct {
+	Roles []RoleFixture `yaml:"roles"`
+	Users []UserFixture `yaml:"users"`
+}
+
+type RoleFixture struct {
+	Name        string `yaml:"name" validate:"required,max=5"`
+	Description string `yaml:"description"`
+}
+
+type UserFixture struct {
+	Username string `yaml:"username" validate:"required,username"`
+	Password string `yaml:"password" validate:"required,password"`
+	Role     string `yaml:"role" validate:"required,max=5"`
+}
+
+// Admin describes the first administrator created by the seed command.
+type Admin struct {
+	Username string
+	Password string
+}
+
+// Dir returns the fixture directory of an environment.
+func Dir(env string) string {
+	return filepath.Join(".", "."+env, "fixtures")
+}
+
+// Run loads every *.yml, *.yaml and *.json file of dir in lexical order and
+// upserts its content. Running it twice leaves the database unchanged.
+func Run(dir string, admin *Admin) error {
+	fixtures, err := Load(dir)
+	if err != nil {
+		return err
+	}
+
+	pg, err := postgres.NewClient()
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+
+	return pg.Conn().Transaction(func(tx *gorm.DB) error {
+		for _, f := range fixtures {
+			err := apply(tx, f)
+			if err != nil {
+				return err
+			}
+		}
+
+		if admin != nil {
+			return upsertUser(tx, UserFixture{
+				Username: admin.Username,
+				Password: admin.Password,
+				Role:     "admin",
+			})
+		}
+
+		return nil
+	})
+}
+
+// Load reads the fixture files of dir. A missing directory yields no fixture.
+func Load(dir string) ([]*Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Log().Warn().Msgf("fixture directory %s not found", dir)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yml", ".yaml", ".json":
+			if !e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	fixtures := make([]*Fixture, 0, len(names))
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		f := new(Fixture)
+		err = yaml.Unmarshal(b, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		fixtures = append(fixtures, f)
+	}
+
+	return fixtures, nil
+}
+
+func apply(tx *gorm.DB, f *Fixture) error {
+	for _, r := range f.Roles {
+		err := validation.Struct(r)
+		if err != nil {
+			return fmt.Errorf("role %q: %w", r.Name, err)
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "change_on"}),
+		}).Create(&model.Role{
+			Name:        r.Name,
+			Description: r.Description,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, u := range f.Users {
+		err := upsertUser(tx, u)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upsertUser creates the user or updates its role. The password of an
+// existing user is never overwritten so seeding does not undo a reset.
+func upsertUser(tx *gorm.DB, u UserFixture) error {
+	err := validation.Struct(u)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", u.Username, err)
+	}
+
+	hash, err := password.CurrentHasher().Hash(u.Password)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "change_on"}),
+	}).Create(&model.User{
+		Username: u.Username,
+		Password: hash,
+		Role:     u.Role,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Log().Info().Msgf("seeded user %s", u.Username)
+	return nil
+}
@@ -1,33 +1,134 @@
 package logger
 
 import (
-	"fmt"
+	"context"
+	"io"
 	"os"
+	"restapi/internal/config"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var logger = zerolog.New(zerolog.ConsoleWriter{
-	Out:        os.Stdout,
-	NoColor:    false,
-	TimeFormat: time.RFC3339,
-}).With().Timestamp().Logger().Level(zerolog.GlobalLevel())
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+var logger = newLogger(FormatConsole)
+
+type ctxKey struct{}
+
+func newLogger(format string) zerolog.Logger {
+	var w io.Writer = os.Stdout
+	if format != FormatJSON {
+		w = zerolog.ConsoleWriter{
+			Out:        os.Stdout,
+			NoColor:    false,
+			TimeFormat: time.RFC3339,
+		}
+	}
 
+	return zerolog.New(&redactWriter{w}).With().Timestamp().Logger()
+}
+
+// Log returns the base logger at the level configured for the calling
+// package.
 func Log() *zerolog.Logger {
-	return &logger
-}
-
-func Logger() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s - [%s] %s %s %d %s \n",
-			param.ClientIP,
-			param.TimeStamp.Format(time.RFC822),
-			param.Method,
-			param.Path,
-			param.StatusCode,
-			param.Latency,
-		)
+	l := logger.Level(level(callerPackage()))
+	return &l
+}
+
+// Ctx returns the request-scoped logger stored in ctx, or the base logger,
+// at the level configured for the calling package. A trace id is added when
+// ctx carries a span the logger does not know about yet.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	l, ok := ctx.Value(ctxKey{}).(*zerolog.Logger)
+	if !ok {
+		l = &logger
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+			withTrace := logger.With().Str("trace_id", sc.TraceID().String()).Logger()
+			l = &withTrace
+		}
+	}
+
+	res := l.Level(level(callerPackage()))
+	return &res
+}
+
+// WithContext stores l in ctx, it is returned by Ctx from then on.
+func WithContext(ctx context.Context, l zerolog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &l)
+}
+
+// With adds a field to the request-scoped logger of ctx, so every line
+// logged for the request from then on carries it.
+func With(ctx context.Context, key string, value interface{}) {
+	l, ok := ctx.Value(ctxKey{}).(*zerolog.Logger)
+	if !ok {
+		return
+	}
+
+	l.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Interface(key, value)
 	})
 }
+
+// Init applies LOG_FORMAT. Levels are read from the config on every call of
+// Log and Ctx so LOG_LEVEL and LOG_LEVELS follow config reloads.
+func Init() {
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	logger = newLogger(config.Cfg().LogFormat)
+}
+
+// level returns the LOG_LEVELS entry of pkg, falling back to LOG_LEVEL.
+// Entries are keyed by the last element of the import path, e.g. service.
+func level(pkg string) zerolog.Level {
+	cfg, ok := config.Current()
+	if !ok {
+		return zerolog.InfoLevel
+	}
+
+	value := cfg.LogLevel
+	if pkgLevel, ok := cfg.PackageLogLevels()[pkg]; ok {
+		value = pkgLevel
+	}
+
+	l, err := zerolog.ParseLevel(value)
+	if err != nil {
+		return zerolog.InfoLevel
+	}
+	return l
+}
+
+var packages sync.Map
+
+// callerPackage returns the package name of the function calling Log or Ctx.
+func callerPackage() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return ""
+	}
+	if pkg, ok := packages.Load(pc); ok {
+		return pkg.(string)
+	}
+
+	name := ""
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+	}
+	// restapi/internal/app/service.(*authService).Login -> service
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+
+	packages.Store(pc, name)
+	return name
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID accepts the X-Request-ID of the caller, or generates one, and
+// echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			u, _ := uuid.NewV4()
+			id = u.String()
+		}
+
+		c.Set("request_id", id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+		c.Writer.Header().Set(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDFrom returns the request id stored in ctx by RequestID.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID keeps caller supplied ids short and printable so they
+// cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Logger stores a logger carrying the request id, route and trace id in the
+// request context and writes one access line per request once it is served.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		lc := logger.With().
+			Str("request_id", c.GetString("request_id")).
+			Str("route", route)
+		if traceID := c.GetString("trace_id"); traceID != "" {
+			lc = lc.Str("trace_id", traceID)
+		}
+		c.Request = c.Request.WithContext(WithContext(c.Request.Context(), lc.Logger()))
+
+		c.Next()
+
+		l := Ctx(c.Request.Context())
+		event := l.Info()
+		if c.Writer.Status() >= 500 {
+			event = l.Error()
+		} else if c.Writer.Status() >= 400 {
+			event = l.Warn()
+		}
+
+		event.
+			Str("client_ip", c.ClientIP()).
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Int("status", c.Writer.Status()).
+			Dur("latency", time.Since(start)).
+			Int("size", c.Writer.Size()).
+			Msg("request")
+	}
+}
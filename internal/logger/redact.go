@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are redacted wherever they appear in a log line, matched
+// case-insensitively as a substring of the key.
+var sensitiveKeys = []string{
+	"password",
+	"secret",
+	"token",
+	"authorization",
+	"cookie",
+	"value_solution",
+}
+
+var bearer = regexp.MustCompile(`(?i)(bearer|basic)\s+[a-z0-9._~+/=-]+`)
+
+// redactWriter masks sensitive fields of the JSON lines written by zerolog
+// before they reach the output, whatever the code logging them.
+type redactWriter struct {
+	next io.Writer
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if !mayContainSecret(p) {
+		return w.next.Write(p)
+	}
+
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return w.next.Write(bearer.ReplaceAll(p, []byte("$1 "+redacted)))
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(redact(fields))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.next.Write(buf.Bytes())
+	return len(p), err
+}
+
+func mayContainSecret(p []byte) bool {
+	lower := bytes.ToLower(p)
+	for _, key := range sensitiveKeys {
+		if bytes.Contains(lower, []byte(key)) {
+			return true
+		}
+	}
+
+	return bytes.Contains(lower, []byte("bearer")) || bytes.Contains(lower, []byte("basic"))
+}
+
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if isSensitive(k) {
+				value[k] = redacted
+			} else {
+				value[k] = redact(field)
+			}
+		}
+		return value
+	case []interface{}:
+		for i := range value {
+			value[i] = redact(value[i])
+		}
+		return value
+	case string:
+		return bearer.ReplaceAllString(value, "$1 "+redacted)
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+// Package mail sends the messages of the server, through SMTP or to the log
+// during development.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"restapi/internal/config"
+	"restapi/internal/logger"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text mail.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer of MAILER. The config is read for every message so
+// the mailer follows reloads.
+func New() Mailer {
+	return configMailer{}
+}
+
+type configMailer struct{}
+
+func (configMailer) Send(ctx context.Context, msg Message) error {
+	cfg := config.Cfg()
+	switch cfg.Mailer {
+	case config.MailerSMTP:
+		return NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom).Send(ctx, msg)
+	default:
+		return NewLog().Send(ctx, msg)
+	}
+}
+
+// logMailer writes the messages to the log, for development.
+type logMailer struct{}
+
+func NewLog() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	logger.Ctx(ctx).Info().Str("to", msg.To).Str("subject", msg.Subject).Str("body", msg.Body).Msg("mail not sent, MAILER is log")
+	return nil
+}
+
+// smtpMailer sends through a relay, with implicit TLS on port 465 and
+// STARTTLS elsewhere when the relay offers it.
+type smtpMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+func NewSMTP(host string, port int, username, password, from string) Mailer {
+	return &smtpMailer{host, port, username, password, from}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("mail: header contains a line break")
+	}
+
+	data, err := m.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if m.port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return err
+		}
+	}
+
+	// PlainAuth refuses to send the password without TLS, except to localhost
+	if m.username != "" {
+		err = c.Auth(smtp.PlainAuth("", m.username, m.password, m.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.from)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (m *smtpMailer) compose(msg Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(buf)
+	_, err := w.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "restapi"
+
+// auth events counted by AuthEvent
+const (
+	EventLogin       = "login"
+	EventLoginFailed = "login_failed"
+	EventRefresh     = "refresh"
+	EventLogout      = "logout"
+
+	EventClientToken       = "client_token"
+	EventClientTokenFailed = "client_token_failed"
+	EventOAuthToken        = "oauth_token"
+	EventOAuthTokenFailed  = "oauth_token_failed"
+
+	EventMagicLinkSent = "magic_link_sent"
+	EventImpersonation = "impersonation"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "GORM query latency by operation, table and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "table", "status"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Redis command latency by command and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	userCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "user_cache",
+		Name:      "lookups_total",
+		Help:      "User cache lookups by result, hit or miss.",
+	}, []string{"result"})
+
+	authEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "events_total",
+		Help:      "Authentication events: login, login_failed, refresh and logout.",
+	}, []string{"event"})
+)
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records every request under its route template so that path
+// parameters do not explode the label cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// CacheHit and CacheMiss count user cache lookups.
+func CacheHit()  { userCache.WithLabelValues("hit").Inc() }
+func CacheMiss() { userCache.WithLabelValues("miss").Inc() }
+
+// AuthEvent counts one of the Event constants.
+func AuthEvent(event string) {
+	authEvents.WithLabelValues(event).Inc()
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
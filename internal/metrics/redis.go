@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+type redisStartKey struct{}
+
+// RedisHook times every command and pipeline sent through a client.
+type RedisHook struct{}
+
+var _ redis.Hook = RedisHook{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDuration.WithLabelValues(cmd.Name(), redisStatus(cmd.Err())).Observe(time.Since(start).Seconds())
+	}
+
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		var err error
+		for _, cmd := range cmds {
+			if cmd.Err() != nil && cmd.Err() != redis.Nil {
+				err = cmd.Err()
+			}
+		}
+		redisDuration.WithLabelValues("pipeline", status(err)).Observe(time.Since(start).Seconds())
+	}
+
+	return nil
+}
+
+// redisStatus does not count a missing key as an error.
+func redisStatus(err error) string {
+	if err == redis.Nil {
+		return "ok"
+	}
+	return status(err)
+}
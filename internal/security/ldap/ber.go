@@ -0,0 +1,233 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes of the identifier octet
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+
+	constructedBit = 0x20
+)
+
+// universal tags
+const (
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// maxPacketSize bounds what is read from the peer.
+const maxPacketSize = 1 << 20
+
+var errMalformed = errors.New("ldap: malformed packet")
+
+// packet is a BER element. A constructed packet has children, a primitive
+// one a value.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*packet
+}
+
+func sequence(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSequence, children: children}
+}
+
+func set(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSet, children: children}
+}
+
+func application(tag byte, children ...*packet) *packet {
+	return &packet{class: classApplication, constructed: true, tag: tag, children: children}
+}
+
+func octetString(s string) *packet {
+	return &packet{class: classUniversal, tag: tagOctetString, value: []byte(s)}
+}
+
+func integer(n int64) *packet {
+	return &packet{class: classUniversal, tag: tagInteger, value: encodeInt(n)}
+}
+
+func enumerated(n int64) *packet {
+	return &packet{class: classUniversal, tag: tagEnumerated, value: encodeInt(n)}
+}
+
+func boolean(b bool) *packet {
+	if b {
+		return &packet{class: classUniversal, tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &packet{class: classUniversal, tag: tagBoolean, value: []byte{0}}
+}
+
+// is reports whether p has the class and tag.
+func (p *packet) is(class, tag byte) bool {
+	return p != nil && p.class == class && p.tag == tag
+}
+
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+
+	return &packet{}
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+func (p *packet) int() int64 {
+	var n int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+
+	return n
+}
+
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+
+	identifier := p.class | p.tag
+	if p.constructed {
+		identifier |= constructedBit
+	}
+
+	res := append([]byte{identifier}, encodeLength(len(content))...)
+	return append(res, content...)
+}
+
+// readPacket reads one element from r.
+func readPacket(r io.Reader) (*packet, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	lengthBytes := []byte{}
+	if header[1]&0x80 != 0 {
+		n := int(header[1] & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errMalformed
+		}
+		lengthBytes = make([]byte, n)
+		_, err = io.ReadFull(r, lengthBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	_, err = io.ReadFull(r, content)
+	if err != nil {
+		return nil, err
+	}
+
+	p, rest, err := parsePacket(append(append(header, lengthBytes...), content...))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errMalformed
+	}
+
+	return p, nil
+}
+
+// parsePacket decodes the element at the start of b and returns the bytes
+// after it.
+func parsePacket(b []byte) (*packet, []byte, error) {
+	if len(b) < 2 || b[0]&0x1f == 0x1f {
+		return nil, nil, errMalformed
+	}
+
+	p := &packet{class: b[0] & 0xc0, constructed: b[0]&constructedBit != 0, tag: b[0] & 0x1f}
+
+	length, offset := int(b[1]), 2
+	if b[1]&0x80 != 0 {
+		n := int(b[1] & 0x7f)
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return nil, nil, errMalformed
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b)-offset < length {
+		return nil, nil, errMalformed
+	}
+
+	content := b[offset : offset+length]
+	if !p.constructed {
+		p.value = content
+		return p, b[offset+length:], nil
+	}
+
+	for len(content) > 0 {
+		child, rest, err := parsePacket(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.children = append(p.children, child)
+		content = rest
+	}
+
+	return p, b[offset+length:], nil
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// encodeInt returns the shortest two's complement of n.
+func encodeInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return b
+}
@@ -0,0 +1,304 @@
+// Package ldap is a minimal LDAP v3 client, enough to authenticate users
+// with a bind and read their attributes with a search, and a small
+// in-memory directory server to try it.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// protocol operations of RFC 4511 section 4.2 to 4.12
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+
+	contextSimpleAuth   = 0
+	contextExtendedName = 0
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// result codes of RFC 4511 appendix A
+const (
+	ResultSuccess                = 0
+	ResultOperationsError        = 1
+	ResultProtocolError          = 2
+	ResultSizeLimitExceeded      = 4
+	ResultAuthMethodNotSupported = 7
+	ResultInvalidCredentials     = 49
+)
+
+// search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// Error is an LDAP result other than success.
+type Error struct {
+	ResultCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsInvalidCredentials reports whether a bind failed on the password.
+func IsInvalidCredentials(err error) bool {
+	var ldapErr *Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ResultInvalidCredentials
+}
+
+// Entry is an entry returned by a search.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of attribute name, compared without case.
+func (e *Entry) Values(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Value returns the first value of attribute name.
+func (e *Entry) Value(name string) string {
+	if v := e.Values(name); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// Conn is a connection to a directory server. Requests are sent one at a
+// time, a Conn is not safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	host    string
+	msgID   int64
+	timeout time.Duration
+}
+
+// Dial connects to an ldap:// or ldaps:// url. Every request must complete
+// within timeout.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ldaps":
+			host = net.JoinHostPort(u.Hostname(), "636")
+		default:
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: withServerName(tlsConfig, u.Hostname())}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{conn: conn, r: bufio.NewReader(conn), host: u.Hostname(), timeout: timeout}, nil
+}
+
+// StartTLS upgrades the connection, RFC 4511 section 4.14.
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	res, err := c.request(application(opExtendedRequest,
+		&packet{class: classContext, tag: contextExtendedName, value: []byte(startTLSOID)},
+	), opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	err = result(res[0])
+	if err != nil {
+		return err
+	}
+
+	conn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	err = conn.Handshake()
+	if err != nil {
+		return err
+	}
+
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// Bind authenticates as dn with a simple bind. An empty password is
+// refused, the server would take it as an anonymous bind.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &Error{ResultCode: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	res, err := c.request(application(opBindRequest,
+		integer(3),
+		octetString(dn),
+		&packet{class: classContext, tag: contextSimpleAuth, value: []byte(password)},
+	), opBindResponse)
+	if err != nil {
+		return err
+	}
+
+	return result(res[0])
+}
+
+// Search returns the entries matching req.
+func (c *Conn) Search(req SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make([]*packet, len(req.Attributes))
+	for i, attr := range req.Attributes {
+		attributes[i] = octetString(attr)
+	}
+
+	res, err := c.request(application(opSearchRequest,
+		octetString(req.BaseDN),
+		enumerated(int64(req.Scope)),
+		enumerated(0),
+		integer(int64(req.SizeLimit)),
+		integer(int64(c.timeout/time.Second)),
+		boolean(false),
+		filter,
+		sequence(attributes...),
+	), opSearchDone)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+	for _, op := range res[:len(res)-1] {
+		if !op.is(classApplication, opSearchEntry) {
+			continue
+		}
+
+		entry := &Entry{DN: op.child(0).str(), Attributes: map[string][]string{}}
+		for _, attr := range op.child(1).children {
+			for _, v := range attr.child(1).children {
+				entry.Attributes[attr.child(0).str()] = append(entry.Attributes[attr.child(0).str()], v.str())
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, result(res[len(res)-1])
+}
+
+// Close sends an unbind and closes the connection.
+func (c *Conn) Close() error {
+	c.msgID++
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, _ = c.conn.Write(sequence(integer(c.msgID), &packet{class: classApplication, tag: opUnbindRequest}).bytes())
+
+	return c.conn.Close()
+}
+
+// request sends op and reads the responses up to the one tagged last.
+func (c *Conn) request(op *packet, last byte) ([]*packet, error) {
+	c.msgID++
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.conn.Write(sequence(integer(c.msgID), op).bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*packet
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.children) < 2 {
+			return nil, errMalformed
+		}
+		// an unsolicited notification has id 0, e.g. before a disconnect
+		if id := msg.child(0).int(); id != c.msgID {
+			return nil, fmt.Errorf("ldap: unexpected message id %d", id)
+		}
+
+		op := msg.child(1)
+		if op.class != classApplication {
+			return nil, errMalformed
+		}
+		res = append(res, op)
+		if op.tag == last {
+			return res, nil
+		}
+	}
+}
+
+// result returns the error of an LDAPResult.
+func result(op *packet) error {
+	code := op.child(0).int()
+	if code == ResultSuccess {
+		return nil
+	}
+
+	return &Error{ResultCode: int(code), Message: op.child(2).str()}
+}
+
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	return cfg
+}
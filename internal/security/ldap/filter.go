@@ -0,0 +1,178 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// filter choices of RFC 4511 section 4.5.1
+const (
+	filterAnd      = 0
+	filterOr       = 1
+	filterNot      = 2
+	filterEquality = 3
+	filterPresent  = 7
+)
+
+// EscapeFilter escapes the characters of value with a meaning in a filter,
+// RFC 4515 section 3.
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// compileFilter parses the string form of a filter. Only and, or, not,
+// equality and presence are supported.
+func compileFilter(filter string) (*packet, error) {
+	p, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+
+	return p, nil
+}
+
+func parseFilter(s string) (*packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter %q must start with (", s)
+	}
+	s = s[1:]
+
+	switch {
+	case strings.HasPrefix(s, "&"), strings.HasPrefix(s, "|"):
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+
+		p := &packet{class: classContext, constructed: true, tag: tag}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			p.children = append(p.children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") || len(p.children) == 0 {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+
+		return p, s[1:], nil
+	case strings.HasPrefix(s, "!"):
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+
+		return &packet{class: classContext, constructed: true, tag: filterNot, children: []*packet{child}}, rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 || strings.ContainsAny(item[:eq], "~<>:") {
+		return nil, "", fmt.Errorf("ldap: filter item %q must be attr=value", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	if value == "*" {
+		return &packet{class: classContext, tag: filterPresent, value: []byte(attr)}, rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("ldap: substring filter %q is not supported", item)
+	}
+
+	unescaped, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &packet{
+		class:       classContext,
+		constructed: true,
+		tag:         filterEquality,
+		children:    []*packet{octetString(attr), octetString(unescaped)},
+	}, rest, nil
+}
+
+func unescapeFilter(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("ldap: bad escape in %q", value)
+		}
+		c, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: bad escape in %q", value)
+		}
+		b.Write(c)
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+// matches evaluates a compiled filter on entry, attribute names and values
+// are compared without case.
+func matches(filter *packet, entry *Entry) bool {
+	if filter.class != classContext {
+		return false
+	}
+
+	switch filter.tag {
+	case filterAnd:
+		for _, child := range filter.children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.children) == 1 && !matches(filter.children[0], entry)
+	case filterEquality:
+		for _, v := range entry.Values(filter.child(0).str()) {
+			if strings.EqualFold(v, filter.child(1).str()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(entry.Values(filter.str())) > 0
+	default:
+		return false
+	}
+}
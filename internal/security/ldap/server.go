@@ -0,0 +1,191 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Server is an in-memory directory for development and tests. It answers
+// simple binds, searches with the filters compileFilter supports and
+// StartTLS when TLSConfig is set.
+type Server struct {
+	Entries []*Entry
+	// Passwords of the entries that can bind, by DN in lower case.
+	Passwords map[string]string
+	TLSConfig *tls.Config
+
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+// Serve answers the connections of l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Close()
+}
+
+// session is the state of one connection.
+type session struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *Server) serve(conn net.Conn) {
+	sess := &session{conn: conn, r: bufio.NewReader(conn)}
+	defer func() { sess.conn.Close() }()
+
+	for {
+		msg, err := readPacket(sess.r)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+
+		id, op := msg.child(0).int(), msg.child(1)
+		if op.class != classApplication {
+			return
+		}
+
+		switch op.tag {
+		case opBindRequest:
+			sess.reply(id, application(opBindResponse, s.bind(op)...))
+		case opSearchRequest:
+			s.search(sess, id, op)
+		case opExtendedRequest:
+			if op.child(0).str() != startTLSOID || s.TLSConfig == nil {
+				sess.reply(id, application(opExtendedResponse, ldapResult(ResultProtocolError, "unsupported extended operation")...))
+				continue
+			}
+			if _, ok := sess.conn.(*tls.Conn); ok {
+				sess.reply(id, application(opExtendedResponse, ldapResult(ResultOperationsError, "TLS already started")...))
+				continue
+			}
+
+			sess.reply(id, application(opExtendedResponse, ldapResult(ResultSuccess, "")...))
+			tlsConn := tls.Server(sess.conn, s.TLSConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			sess.conn, sess.r = tlsConn, bufio.NewReader(tlsConn)
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) bind(op *packet) []*packet {
+	dn, auth := op.child(1).str(), op.child(2)
+	if !auth.is(classContext, contextSimpleAuth) {
+		return ldapResult(ResultAuthMethodNotSupported, "only simple binds are supported")
+	}
+
+	// an empty password is an unauthenticated bind, RFC 4513 section 5.1.2
+	if len(auth.value) == 0 {
+		return ldapResult(ResultSuccess, "")
+	}
+
+	expected, ok := s.Passwords[strings.ToLower(dn)]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), auth.value) != 1 {
+		return ldapResult(ResultInvalidCredentials, "invalid credentials")
+	}
+
+	return ldapResult(ResultSuccess, "")
+}
+
+func (s *Server) search(sess *session, id int64, op *packet) {
+	base, scope, sizeLimit, filter := strings.ToLower(op.child(0).str()), op.child(1).int(), op.child(3).int(), op.child(6)
+
+	var attributes []string
+	for _, attr := range op.child(7).children {
+		attributes = append(attributes, attr.str())
+	}
+
+	sent := int64(0)
+	for _, entry := range s.Entries {
+		if !inScope(strings.ToLower(entry.DN), base, scope) || !matches(filter, entry) {
+			continue
+		}
+		if sizeLimit > 0 && sent == sizeLimit {
+			sess.reply(id, application(opSearchDone, ldapResult(ResultSizeLimitExceeded, "")...))
+			return
+		}
+
+		attrs := []*packet{}
+		for name, values := range entry.Attributes {
+			if len(attributes) > 0 && !containsFold(attributes, name) {
+				continue
+			}
+
+			vals := make([]*packet, len(values))
+			for i, v := range values {
+				vals[i] = octetString(v)
+			}
+			attrs = append(attrs, sequence(octetString(name), set(vals...)))
+		}
+
+		sess.reply(id, application(opSearchEntry, octetString(entry.DN), sequence(attrs...)))
+		sent++
+	}
+
+	sess.reply(id, application(opSearchDone, ldapResult(ResultSuccess, "")...))
+}
+
+func (sess *session) reply(id int64, op *packet) {
+	_, _ = sess.conn.Write(sequence(integer(id), op).bytes())
+}
+
+func ldapResult(code int, message string) []*packet {
+	return []*packet{enumerated(int64(code)), octetString(""), octetString(message)}
+}
+
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case ScopeBaseObject:
+		return dn == base
+	case ScopeSingleLevel:
+		i := strings.IndexByte(dn, ',')
+		return i > 0 && dn[i+1:] == base
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
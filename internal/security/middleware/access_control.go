@@ -1,7 +1,9 @@
 package middleware
 
 import (
-	"net/http"
+	"restapi/internal/constant"
+	"restapi/internal/web"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,11 +13,25 @@ func Authorize() gin.HandlerFunc {
 		// Get current user/subject
 		role := c.MustGet("user_role").(string)
 		if role != "admin" {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, map[string]interface{}{
-				"error":   http.StatusInternalServerError,
-				"message": "user unauthorized",
-				"data":    nil,
-			})
+			web.MarshalError(c, constant.ErrForbidden)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AuthorizeSelfOrAdmin lets users act on their own account, the :id path
+// parameter, and admins on any account.
+func AuthorizeSelfOrAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.MustGet("user_role").(string)
+		id := c.MustGet("user_id").(uint)
+		if role != "admin" && c.Param("id") != strconv.FormatUint(uint64(id), 10) {
+			web.MarshalError(c, constant.ErrForbidden)
+			c.Abort()
+			return
 		}
 
 		c.Next()
@@ -1,18 +1,92 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"restapi/internal/app/model"
+	"restapi/internal/app/service"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
 	"restapi/internal/security/token"
 	"restapi/internal/web"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupAuthenticationMiddleware() gin.HandlerFunc {
+// passwordChangeRoutes stay open to tokens issued with an expired password.
+var passwordChangeRoutes = map[string]bool{
+	"/user/password/:id": true,
+	"/user/refresh":      true,
+	"/user/logout":       true,
+}
+
+// accountRoutes act on the user of the token, they are closed to service
+// accounts which have none.
+var accountRoutes = map[string]bool{
+	"/user/":                        true,
+	"/user/tokens":                  true,
+	"/user/tokens/:id":              true,
+	"/user/passkeys":                true,
+	"/user/passkeys/:id":            true,
+	"/user/passkeys/options":        true,
+	"/user/passkeys/reauth":         true,
+	"/user/passkeys/reauth/options": true,
+	"/user/logout":                  true,
+	"/user/refresh":                 true,
+}
+
+// impersonationBlockedRoutes are closed to admins impersonating a user: they
+// would change how the user logs in or remove the account.
+var impersonationBlockedRoutes = map[string]bool{
+	"PUT /user/password/:id":             true,
+	"DELETE /user/:id":                   true,
+	"GET /user/refresh":                  true,
+	"POST /user/tokens":                  true,
+	"DELETE /user/tokens/:id":            true,
+	"POST /user/passkeys":                true,
+	"POST /user/passkeys/options":        true,
+	"POST /user/passkeys/reauth":         true,
+	"POST /user/passkeys/reauth/options": true,
+	"DELETE /user/passkeys/:id":          true,
+}
+
+// SetupAuthenticationMiddleware accepts a session JWT or a personal access
+// token, sent as a bearer token or in the X-API-Key header. A JWT must still
+// be known to Redis, revoked ones are refused before they expire. The
+// requests of an admin impersonating a user are audited.
+func SetupAuthenticationMiddleware(tokens service.AccessTokenService, sessions service.AuthService, audit service.AuditService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		data, err := token.TokenValid(c.Request)
+		var (
+			data *model.AccessDetails
+			err  error
+		)
+		if raw := accessToken(c.Request); raw != "" {
+			data, err = tokens.Authenticate(c.Request.Context(), raw, c.ClientIP())
+		} else {
+			data, err = token.TokenValid(c.Request)
+			if err != nil {
+				logger.Ctx(c.Request.Context()).Debug().Err(err).Msg("invalid access token")
+				err = constant.ErrUnauthenticated
+			} else {
+				err = sessions.CheckToken(c.Request.Context(), data)
+			}
+		}
 		if err != nil {
-			web.MarshalError(c, http.StatusUnauthorized, err.Error(), nil)
+			web.MarshalError(c, err)
+			c.Abort()
+			return
+		}
+
+		if data.ClientID != "" && data.UserId == 0 && accountRoutes[c.FullPath()] {
+			web.MarshalError(c, constant.ErrForbidden)
+			c.Abort()
+			return
+		}
+
+		if data.PasswordExpired && !passwordChangeRoutes[c.FullPath()] {
+			web.MarshalError(c, constant.ErrPasswordExpired)
 			c.Abort()
 			return
 		}
@@ -20,7 +94,105 @@ func SetupAuthenticationMiddleware() gin.HandlerFunc {
 		c.Set("user_id", data.UserId)
 		c.Set("username", data.Username)
 		c.Set("user_role", data.Role)
+		if data.Scopes != nil {
+			c.Set("scopes", data.Scopes)
+		}
+		if data.ClientID != "" {
+			c.Set("client_id", data.ClientID)
+		}
+		logger.With(c.Request.Context(), "user_id", data.UserId)
+
+		if data.Actor != nil {
+			impersonate(c, data, audit)
+			return
+		}
 
 		c.Next()
 	}
 }
+
+// impersonate serves a request made with an impersonation token. Handlers
+// find the admin in actor_id and actor_username.
+func impersonate(c *gin.Context, data *model.AccessDetails, audit service.AuditService) {
+	c.Set("actor_id", data.Actor.UserId)
+	c.Set("actor_username", data.Actor.Username)
+	logger.With(c.Request.Context(), "actor_id", data.Actor.UserId)
+
+	event := model.AuditEvent{
+		Action:    service.AuditImpersonationRequest,
+		ActorType: model.ActorUser,
+		ActorID:   strconv.Itoa(int(data.Actor.UserId)),
+		Target:    strconv.Itoa(int(data.UserId)),
+		IP:        c.ClientIP(),
+	}
+
+	// logging out with the token ends the impersonation
+	if c.FullPath() == "/user/logout" {
+		event.Action = service.AuditImpersonationStop
+	}
+
+	if impersonationBlockedRoutes[c.Request.Method+" "+c.FullPath()] {
+		web.MarshalError(c, constant.ErrImpersonation)
+		c.Abort()
+	} else {
+		c.Next()
+	}
+
+	status := c.Writer.Status()
+	event.Success = status < http.StatusBadRequest
+	event.Detail = fmt.Sprintf("%s %s %d", c.Request.Method, c.Request.URL.Path, status)
+	audit.Record(c.Request.Context(), event)
+}
+
+// RequireScope answers 403 to access tokens without scope. Sessions have
+// every scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v, ok := c.Get("scopes"); ok {
+			scopes, _ := v.([]string)
+			granted := false
+			for _, s := range scopes {
+				granted = granted || s == scope
+			}
+
+			if !granted {
+				web.MarshalError(c, constant.ErrInsufficientScope)
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession answers 403 to personal access tokens and OAuth tokens,
+// for the routes that could turn them into a login.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, scoped := c.Get("scopes")
+		_, client := c.Get("client_id")
+		if scoped || client {
+			web.MarshalError(c, constant.ErrSessionRequired)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// accessToken returns the personal access token of r, empty when r carries
+// a JWT or nothing.
+func accessToken(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(bearer, model.AccessTokenPrefix) {
+		return bearer
+	}
+
+	return ""
+}
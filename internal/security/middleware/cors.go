@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"restapi/internal/config"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,20 +19,29 @@ func CORSMiddleware() gin.HandlerFunc {
 			"X-CSRF-Token",
 			"Authorization",
 			"X-XSRF-TOKEN",
+			"X-Request-ID",
+			"X-API-Key",
 		},
 		", ",
 	)
 
+	var whitelistHost atomic.Value
+	whitelistHost.Store(splitHosts(config.Cfg().WhitelistHost))
+	config.Subscribe(func(old, new *config.Config) {
+		if old.WhitelistHost != new.WhitelistHost {
+			whitelistHost.Store(splitHosts(new.WhitelistHost))
+		}
+	})
+
 	return func(c *gin.Context) {
 		http_origin := c.GetHeader("origin")
-		whitelistHost := strings.Split(config.Cfg().WhitelistHost, ",")
-		exist, _ := indexOf(http_origin, whitelistHost)
+		exist, _ := indexOf(http_origin, whitelistHost.Load().([]string))
 		if exist {
 			c.Writer.Header().Set("Access-Control-Allow-Origin", http_origin)
 		}
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, UPDATE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", accessControlAllowHeaders)
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, X-Request-ID, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Referrer-Policy", "same-origin")
 		c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
@@ -49,6 +59,17 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
+func splitHosts(hosts string) []string {
+	res := []string{}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			res = append(res, h)
+		}
+	}
+
+	return res
+}
+
 func indexOf(val interface{}, array interface{}) (exists bool, index int) {
 	exists = false
 	index = -1
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/web"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireFeature answers 404 while the feature flag is not listed in
+// FEATURES. The flag is checked on every request so it follows reloads.
+func RequireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Cfg().Enabled(feature) {
+			web.MarshalError(c, constant.ErrNotFound)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
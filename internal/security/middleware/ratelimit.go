@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/db/redis"
+	"restapi/internal/logger"
+	"restapi/internal/web"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	rateLimitPrefix = "ratelimit:"
+	apiKeyHeader    = "X-API-Key"
+)
+
+// gcra implements the generic cell rate algorithm: the key stores the
+// theoretical arrival time of the next request, in seconds since
+// 2017-01-01 to keep the float precise.
+//
+// KEYS[1] key, ARGV burst, limit, period in seconds.
+// Returns allowed (0/1), remaining, retry after and reset after in seconds.
+var gcra = goredis.NewScript(`
+redis.replicate_commands()
+
+local burst = tonumber(ARGV[1])
+local emission = tonumber(ARGV[3]) / tonumber(ARGV[2])
+local offset = emission * burst
+
+local t = redis.call("TIME")
+local now = (t[1] - 1483228800) + (t[2] / 1000000)
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+tat = math.max(tat, now)
+
+local allowAt = tat + emission - offset
+if now < allowAt then
+	return {0, 0, tostring(allowAt - now), tostring(tat - now)}
+end
+
+local newTat = tat + emission
+redis.call("SET", KEYS[1], tostring(newTat), "EX", math.ceil(newTat - now))
+return {1, math.floor((now - (newTat - offset)) / emission), "0", tostring(newTat - now)}
+`)
+
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+	resetAfter time.Duration
+}
+
+// RateLimiter limits requests with the RATE_LIMITS policies, counted in
+// Redis so every instance shares the budget. While Redis is unavailable it
+// counts in memory, per instance.
+type RateLimiter struct {
+	rds      redis.Client
+	fallback *memoryLimiter
+}
+
+func NewRateLimiter(rds redis.Client) *RateLimiter {
+	return &RateLimiter{rds: rds, fallback: newMemoryLimiter()}
+}
+
+// Limit applies the named policy. A policy missing from RATE_LIMITS does
+// not limit, the policies are read on every request so they follow reloads.
+func (l *RateLimiter) Limit(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, err := config.Cfg().RateLimitPolicies()
+		p, ok := policies[policy]
+		if err != nil || !ok {
+			c.Next()
+			return
+		}
+
+		key := rateLimitPrefix + p.Name + ":" + rateLimitKey(c, p.Key)
+		res, err := l.redis(c.Request.Context(), key, p)
+		if err != nil {
+			logger.Ctx(c.Request.Context()).Warn().Err(err).Str("policy", p.Name).Msg("rate limit falls back to memory")
+			res = l.fallback.allow(key, p, time.Now())
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(p.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(res.resetAfter)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, seconds(p.Period)))
+
+		if !res.allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(res.retryAfter)))
+			web.MarshalError(c, constant.ErrTooManyRequests)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (l *RateLimiter) redis(ctx context.Context, key string, p config.RateLimitPolicy) (rateLimitResult, error) {
+	values, err := gcra.Run(ctx, l.rds.Conn(), []string{key}, p.Burst, p.Limit, p.Period.Seconds()).Slice()
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return rateLimitResult{}, fmt.Errorf("unexpected rate limit reply %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	retryAfter, err := parseSeconds(values[2])
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	resetAfter, err := parseSeconds(values[3])
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	return rateLimitResult{
+		allowed:    allowed == 1,
+		remaining:  int(remaining),
+		retryAfter: retryAfter,
+		resetAfter: resetAfter,
+	}, nil
+}
+
+// rateLimitKey identifies the client by IP, user id or API key. Requests
+// without a user or an API key are counted by IP.
+func rateLimitKey(c *gin.Context, by string) string {
+	switch by {
+	case config.RateLimitByUser:
+		if id, ok := c.Get("user_id"); ok {
+			return fmt.Sprintf("user:%v", id)
+		}
+	case config.RateLimitByAPIKey:
+		// the key is a secret, only its hash goes to redis
+		if k := c.GetHeader(apiKeyHeader); k != "" {
+			sum := sha256.Sum256([]byte(k))
+			return "apikey:" + hex.EncodeToString(sum[:16])
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func parseSeconds(v interface{}) (time.Duration, error) {
+	s, _ := v.(string)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// seconds rounds d up to whole seconds, as the headers expect.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// memoryLimiter is the in-memory counterpart of the gcra script.
+type memoryLimiter struct {
+	mu    sync.Mutex
+	tats  map[string]time.Time
+	calls int
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{tats: map[string]time.Time{}}
+}
+
+func (m *memoryLimiter) allow(key string, p config.RateLimitPolicy, now time.Time) rateLimitResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+	if m.calls%1000 == 0 {
+		m.prune(now)
+	}
+
+	emission := p.Period / time.Duration(p.Limit)
+	offset := emission * time.Duration(p.Burst)
+
+	tat, ok := m.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+
+	allowAt := tat.Add(emission - offset)
+	if now.Before(allowAt) {
+		return rateLimitResult{
+			retryAfter: allowAt.Sub(now),
+			resetAfter: tat.Sub(now),
+		}
+	}
+
+	newTat := tat.Add(emission)
+	m.tats[key] = newTat
+
+	return rateLimitResult{
+		allowed:    true,
+		remaining:  int(now.Sub(newTat.Add(-offset)) / emission),
+		resetAfter: newTat.Sub(now),
+	}
+}
+
+// prune drops the keys whose budget is full again.
+func (m *memoryLimiter) prune(now time.Time) {
+	for key, tat := range m.tats {
+		if tat.Before(now) {
+			delete(m.tats, key)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"restapi/internal/web"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout bounds the request context with the timeout configured for the
+// route, so the services and repositories stop their work once it expires.
+// web.MarshalError answers 504 for such requests; a handler that wrote
+// nothing gets the 504 from here.
+func Timeout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := config.Cfg().RouteTimeout(c.FullPath())
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
+			web.MarshalError(c, constant.ErrRequestTimeout)
+			c.Abort()
+		}
+	}
+}
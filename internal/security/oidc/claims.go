@@ -0,0 +1,39 @@
+package oidc
+
+import "github.com/golang-jwt/jwt"
+
+// Claims are the verified claims of an upstream id_token.
+type Claims jwt.MapClaims
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+// String returns the claim name when it is a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim name as a list, a single string counts as a
+// list of one as providers send either for groups and roles.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
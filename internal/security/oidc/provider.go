@@ -0,0 +1,370 @@
+// Package oidc is the relying party side of OpenID Connect, used to log in
+// with an upstream identity provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"restapi/internal/config"
+	"restapi/internal/tracing"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// discoveryTTL is how long the discovery document and the keys are
+	// cached.
+	discoveryTTL = time.Hour
+	// keysRefetchInterval limits how often an unknown kid fetches the keys
+	// again, the provider may have rotated them.
+	keysRefetchInterval = time.Minute
+	// clockSkew is allowed on exp and iat.
+	clockSkew = time.Minute
+)
+
+var (
+	ErrProviderNotFound = errors.New("oidc: provider not found")
+	ErrUnknownKey       = errors.New("oidc: id_token signed with an unknown key")
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// discovery is the part of the provider metadata the login needs.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an upstream identity provider. Its metadata and keys are
+// fetched on first use and cached.
+type Provider struct {
+	Config config.OIDCProvider
+
+	mu            sync.Mutex
+	discovery     *discovery
+	discoveredAt  time.Time
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+var providers struct {
+	sync.Mutex
+	byName map[string]*Provider
+}
+
+// Lookup returns the provider name of OIDC_PROVIDERS. The cached metadata is
+// kept as long as the configuration of the provider does not change.
+func Lookup(name string) (*Provider, error) {
+	configured, err := config.Cfg().OIDCProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, ok := configured[name]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	providers.Lock()
+	defer providers.Unlock()
+
+	if p, ok := providers.byName[name]; ok && reflect.DeepEqual(p.Config, cfg) {
+		return p, nil
+	}
+
+	if providers.byName == nil {
+		providers.byName = map[string]*Provider{}
+	}
+	p := &Provider{Config: cfg}
+	providers.byName[name] = p
+
+	return p, nil
+}
+
+// AuthCodeURL returns the authorization endpoint url the browser is sent
+// to, with the S256 challenge of the PKCE verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, verifier string, forceLogin bool) (string, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", "openid profile email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	if forceLogin {
+		query.Set("prompt", "login")
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems code at the token endpoint and returns the id_token.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, verifier string) (string, error) {
+	ctx, span := tracing.Start(ctx, "oidc.Exchange")
+	defer span.End()
+
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	var res struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := do(req, &res)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("oidc: token endpoint returned %d: %s %s", status, res.Error, res.ErrorDescription)
+	}
+	if res.IDToken == "" {
+		return "", errors.New("oidc: token response has no id_token")
+	}
+
+	return res.IDToken, nil
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an
+// id_token issued by p.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (Claims, error) {
+	ctx, span := tracing.Start(ctx, "oidc.Verify")
+	defer span.End()
+
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}, SkipClaimsValidation: true}
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("oidc: id_token invalid")
+	}
+
+	now := time.Now()
+	switch {
+	case !claims.VerifyIssuer(d.Issuer, true):
+		return nil, errors.New("oidc: id_token issuer mismatch")
+	case !claims.VerifyAudience(p.Config.ClientID, true):
+		return nil, errors.New("oidc: id_token audience mismatch")
+	case !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true):
+		return nil, errors.New("oidc: id_token expired")
+	case !claims.VerifyIssuedAt(now.Add(clockSkew).Unix(), false):
+		return nil, errors.New("oidc: id_token issued in the future")
+	}
+
+	// with several audiences the token must be meant for us
+	if azp, ok := claims["azp"].(string); ok && azp != p.Config.ClientID {
+		return nil, errors.New("oidc: id_token authorized party mismatch")
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("oidc: id_token nonce mismatch")
+	}
+
+	res := Claims(claims)
+	if res.Subject() == "" {
+		return nil, errors.New("oidc: id_token has no subject")
+	}
+
+	return res, nil
+}
+
+// metadata returns the discovery document, fetched once per discoveryTTL.
+func (p *Provider) metadata(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "oidc.Discover")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+	status, err := do(req, &d)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery of %s returned %d", p.Config.Name, status)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery of %s has issuer %q", p.Config.Name, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery of %s misses an endpoint", p.Config.Name)
+	}
+
+	p.discovery, p.discoveredAt = &d, time.Now()
+	p.keys = nil
+
+	return p.discovery, nil
+}
+
+// key returns the public key kid of the provider, fetching the key set
+// again when kid is unknown.
+func (p *Provider) key(ctx context.Context, d *discovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil && time.Since(p.keysFetchedAt) < discoveryTTL {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < keysRefetchInterval {
+		return nil, ErrUnknownKey
+	}
+
+	ctx, span := tracing.Start(ctx, "oidc.FetchKeys")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks of %s returned %d", p.Config.Name, status)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// lookupKey finds kid, a token without kid is accepted when the provider
+// has a single key.
+func (p *Provider) lookupKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// do sends req and decodes the JSON body into v.
+func do(req *http.Request, v interface{}) (int, error) {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+	if err != nil && res.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc: decode %s: %w", req.URL.Path, err)
+	}
+
+	return res.StatusCode, nil
+}
+
+// RandomString returns n random bytes encoded for a url, used for the
+// state, the nonce and the PKCE verifier.
+func RandomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge returns the S256 PKCE challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
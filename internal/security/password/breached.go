@@ -0,0 +1,49 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//go:generate sh -c "go run breached_gen.go < passwords.txt"
+
+// breachedFiles holds the SHA-1 suffixes of common and breached passwords,
+// one file per hash prefix, see breached_gen.go.
+//
+//go:embed breached
+var breachedFiles embed.FS
+
+const breachedPrefixLength = 2
+
+// Breached reports whether password is in the bundled breached list. Only
+// the suffixes sharing the hash prefix of password are read, the same
+// k-anonymity lookup the Have I Been Pwned range API offers.
+func Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	f, err := breachedFiles.Open(path.Join("breached", prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// lines may carry a count like the range API, SUFFIX:count
+		if strings.SplitN(scanner.Text(), ":", 2)[0] == suffix {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,71 @@
+//go:build ignore
+
+// breached_gen writes the breached directory from a list of passwords, one
+// per line, read from stdin:
+//
+//	go run breached_gen.go < passwords.txt
+//
+// Every password is hashed with SHA-1 and its suffix is appended to the file
+// named after the first prefixLength hex characters, the layout of the Have
+// I Been Pwned range API, so a lookup only ever reads the suffixes sharing
+// the prefix of the candidate.
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	dir          = "breached"
+	prefixLength = 2
+)
+
+func main() {
+	files := map[string]map[string]bool{}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		password := strings.TrimRight(scanner.Text(), "\r")
+		if password == "" {
+			continue
+		}
+
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+		if files[prefix] == nil {
+			files[prefix] = map[string]bool{}
+		}
+		files[prefix][suffix] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	for prefix, suffixes := range files {
+		lines := make([]string, 0, len(suffixes))
+		for suffix := range suffixes {
+			lines = append(lines, suffix)
+		}
+		sort.Strings(lines)
+
+		err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"restapi/internal/config"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hash algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var (
+	ErrUnknownHash   = errors.New("unknown password hash format")
+	ErrPepperChanged = errors.New("password hash was made with another pepper")
+)
+
+// PasswordHasher hashes passwords into PHC strings, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>. When a pepper is set the
+// password is keyed with HMAC-SHA256 first and the hash records the pepper
+// id in its k parameter.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was made with another algorithm,
+	// other parameters or another pepper than the hasher would use.
+	NeedsRehash(encoded string) bool
+}
+
+// CurrentHasher hashes with PASSWORD_HASH_ALGORITHM and its parameters, and
+// verifies the hashes of every supported algorithm so accounts keep working
+// when the algorithm changes.
+func CurrentHasher() PasswordHasher {
+	cfg := config.Cfg()
+
+	previous := cfg.PasswordPreviousPepperList()
+	argon := NewArgon2id(cfg.PasswordArgon2Memory, cfg.PasswordArgon2Time, cfg.PasswordArgon2Threads, cfg.PasswordPepper, previous...)
+	bc := NewBcrypt(cfg.PasswordBcryptCost, cfg.PasswordPepper, previous...)
+	if cfg.PasswordHashAlgorithm == AlgorithmBcrypt {
+		return &multiHasher{primary: bc, others: []PasswordHasher{argon}}
+	}
+
+	return &multiHasher{primary: argon, others: []PasswordHasher{bc}}
+}
+
+type multiHasher struct {
+	primary PasswordHasher
+	others  []PasswordHasher
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	return h.primary.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, encoded string) (bool, error) {
+	ok, err := h.primary.Verify(password, encoded)
+	if !errors.Is(err, ErrUnknownHash) {
+		return ok, err
+	}
+
+	for _, other := range h.others {
+		ok, err = other.Verify(password, encoded)
+		if !errors.Is(err, ErrUnknownHash) {
+			return ok, err
+		}
+	}
+
+	return false, err
+}
+
+func (h *multiHasher) NeedsRehash(encoded string) bool {
+	return h.primary.NeedsRehash(encoded)
+}
+
+// pepper hashes with key and still verifies the hashes made with the
+// previous keys, they are rehashed with key at the next login.
+type pepper struct {
+	key      []byte
+	id       string
+	previous map[string][]byte
+}
+
+func newPepper(key string, previous []string) pepper {
+	p := pepper{previous: map[string][]byte{}}
+	if key != "" {
+		p.key, p.id = []byte(key), pepperID(key)
+	}
+	for _, old := range previous {
+		if old != "" {
+			p.previous[pepperID(old)] = []byte(old)
+		}
+	}
+
+	return p
+}
+
+func pepperID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// apply keys password with the pepper of id, the empty id meaning no
+// pepper.
+func (p pepper) apply(password, id string) ([]byte, error) {
+	if id == "" {
+		return []byte(password), nil
+	}
+
+	key := p.previous[id]
+	if id == p.id {
+		key = p.key
+	}
+	if key == nil {
+		return nil, ErrPepperChanged
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
+
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	pepper  pepper
+}
+
+// NewArgon2id hashes with argon2id using memory KiB, time passes and
+// threads lanes.
+func NewArgon2id(memory, time uint32, threads uint8, pepperKey string, previousPeppers ...string) PasswordHasher {
+	return &argon2idHasher{memory, time, threads, newPepper(pepperKey, previousPeppers)}
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	input, err := h.pepper.apply(password, h.pepper.id)
+	if err != nil {
+		return "", err
+	}
+
+	params := phcParams{"m": strconv.FormatUint(uint64(h.memory), 10), "t": strconv.FormatUint(uint64(h.time), 10), "p": strconv.Itoa(int(h.threads)), "k": h.pepper.id}
+	key := argon2.IDKey(input, salt, h.time, h.memory, h.threads, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", AlgorithmArgon2id, argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	a, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	input, err := h.pepper.apply(password, a.pepperID)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey(input, a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+	return subtle.ConstantTimeCompare(key, a.key) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	a, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return a.memory != h.memory || a.time != h.time || a.threads != h.threads || a.pepperID != h.pepper.id
+}
+
+type argon2idHash struct {
+	memory   uint32
+	time     uint32
+	threads  uint8
+	pepperID string
+	salt     []byte
+	key      []byte
+}
+
+// parseArgon2id reads $argon2id$v=19$m=..,t=..,p=..[,k=..]$salt$key.
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return nil, ErrUnknownHash
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	params := parsePHCParams(parts[3])
+	memory, errM := strconv.ParseUint(params["m"], 10, 32)
+	time, errT := strconv.ParseUint(params["t"], 10, 32)
+	threads, errP := strconv.ParseUint(params["p"], 10, 8)
+	salt, errS := base64.RawStdEncoding.DecodeString(parts[4])
+	key, errK := base64.RawStdEncoding.DecodeString(parts[5])
+	if err := firstError(errM, errT, errP, errS, errK); err != nil {
+		return nil, fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+
+	return &argon2idHash{
+		memory:   uint32(memory),
+		time:     uint32(time),
+		threads:  uint8(threads),
+		pepperID: params["k"],
+		salt:     salt,
+		key:      key,
+	}, nil
+}
+
+type bcryptHasher struct {
+	cost   int
+	pepper pepper
+}
+
+// NewBcrypt hashes with bcrypt at cost. Plain bcrypt hashes, $2a$..., made
+// before hashes were PHC encoded are verified as well.
+func NewBcrypt(cost int, pepperKey string, previousPeppers ...string) PasswordHasher {
+	return &bcryptHasher{cost, newPepper(pepperKey, previousPeppers)}
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	input, err := h.pepper.apply(password, h.pepper.id)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(input, h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	// $2a$10$<salt and hash> -> $bcrypt$v=2a$r=10[,k=..]$<salt and hash>
+	parts := strings.Split(string(hash), "$")
+	params := phcParams{"r": strconv.Itoa(h.cost), "k": h.pepper.id}
+	return fmt.Sprintf("$%s$v=%s$%s$%s", AlgorithmBcrypt, parts[1], params, parts[3]), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	b, err := parseBcrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	input, err := h.pepper.apply(password, b.pepperID)
+	if err != nil {
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(b.native), input)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	b, err := parseBcrypt(encoded)
+	if err != nil || b.legacy {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(b.native))
+	return err != nil || cost != h.cost || b.pepperID != h.pepper.id
+}
+
+type bcryptHash struct {
+	native   string
+	pepperID string
+	legacy   bool
+}
+
+// parseBcrypt reads $bcrypt$v=2a$r=10[,k=..]$<salt and hash> and plain
+// $2a$10$<salt and hash> hashes.
+func parseBcrypt(encoded string) (*bcryptHash, error) {
+	parts := strings.Split(encoded, "$")
+	switch {
+	case len(parts) == 4 && strings.HasPrefix(parts[1], "2"):
+		return &bcryptHash{native: encoded, legacy: true}, nil
+	case len(parts) == 5 && parts[1] == AlgorithmBcrypt:
+		params := parsePHCParams(parts[3])
+		cost, err := strconv.Atoi(params["r"])
+		if err != nil {
+			return nil, fmt.Errorf("malformed bcrypt hash: %w", err)
+		}
+
+		native := fmt.Sprintf("$%s$%02d$%s", strings.TrimPrefix(parts[2], "v="), cost, parts[4])
+		return &bcryptHash{native: native, pepperID: params["k"]}, nil
+	default:
+		return nil, ErrUnknownHash
+	}
+}
+
+// phcParams encodes as k=v pairs in a fixed order, empty values are left
+// out.
+type phcParams map[string]string
+
+func (p phcParams) String() string {
+	var pairs []string
+	for _, key := range []string{"m", "t", "p", "r", "k"} {
+		if p[key] != "" {
+			pairs = append(pairs, key+"="+p[key])
+		}
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func parsePHCParams(s string) phcParams {
+	res := phcParams{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			res[kv[0]] = kv[1]
+		}
+	}
+
+	return res
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
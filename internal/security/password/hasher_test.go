@@ -0,0 +1,182 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"restapi/internal/config"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters, the tests hash many passwords
+const (
+	testMemory  = 1024
+	testTime    = 1
+	testThreads = 1
+	testCost    = bcrypt.MinCost
+)
+
+func TestHashVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+		prefix string
+	}{
+		{"argon2id", NewArgon2id(testMemory, testTime, testThreads, ""), "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"argon2id with pepper", NewArgon2id(testMemory, testTime, testThreads, "pepper"), "$argon2id$v=19$m=1024,t=1,p=1,k=" + pepperID("pepper") + "$"},
+		{"bcrypt", NewBcrypt(testCost, ""), "$bcrypt$v=2a$r=4$"},
+		{"bcrypt with pepper", NewBcrypt(testCost, "pepper"), "$bcrypt$v=2a$r=4,k=" + pepperID("pepper") + "$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Errorf("Hash = %q, want the prefix %q", encoded, tt.prefix)
+			}
+
+			if ok, err := tt.hasher.Verify("correct horse", encoded); !ok || err != nil {
+				t.Errorf("Verify of the password = %v, %v", ok, err)
+			}
+			if ok, err := tt.hasher.Verify("battery staple", encoded); ok || err != nil {
+				t.Errorf("Verify of another password = %v, %v", ok, err)
+			}
+			if tt.hasher.NeedsRehash(encoded) {
+				t.Error("NeedsRehash of a hash made with the same settings")
+			}
+		})
+	}
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), testCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(legacy), "$2a$") {
+		t.Fatalf("bcrypt made %q, want a $2a$ hash", legacy)
+	}
+
+	// an argon2id server still logs in the accounts of the bcrypt days
+	h := &multiHasher{
+		primary: NewArgon2id(testMemory, testTime, testThreads, ""),
+		others:  []PasswordHasher{NewBcrypt(testCost, "")},
+	}
+	if ok, err := h.Verify("correct horse", string(legacy)); !ok || err != nil {
+		t.Errorf("Verify of a legacy hash = %v, %v", ok, err)
+	}
+	if ok, _ := h.Verify("battery staple", string(legacy)); ok {
+		t.Error("Verify of another password against a legacy hash")
+	}
+	if !NewBcrypt(testCost, "").NeedsRehash(string(legacy)) {
+		t.Error("a legacy hash is not rehashed into PHC")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon := NewArgon2id(testMemory, testTime, testThreads, "")
+	bc := NewBcrypt(testCost, "")
+
+	tests := []struct {
+		name   string
+		made   PasswordHasher
+		hasher PasswordHasher
+	}{
+		{"argon2id memory", argon, NewArgon2id(2*testMemory, testTime, testThreads, "")},
+		{"argon2id time", argon, NewArgon2id(testMemory, testTime+1, testThreads, "")},
+		{"argon2id threads", argon, NewArgon2id(testMemory, testTime, testThreads+1, "")},
+		{"argon2id pepper", argon, NewArgon2id(testMemory, testTime, testThreads, "pepper")},
+		{"bcrypt cost", bc, NewBcrypt(testCost+1, "")},
+		{"bcrypt pepper", bc, NewBcrypt(testCost, "pepper")},
+		{"bcrypt to argon2id", bc, &multiHasher{primary: argon, others: []PasswordHasher{bc}}},
+		{"argon2id to bcrypt", argon, &multiHasher{primary: bc, others: []PasswordHasher{argon}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.made.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.hasher.NeedsRehash(encoded) {
+				t.Errorf("NeedsRehash(%q) = false", encoded)
+			}
+			// the hash still logs in until it is rehashed
+			if _, ok := tt.hasher.(*multiHasher); ok {
+				if ok, err := tt.hasher.Verify("correct horse", encoded); !ok || err != nil {
+					t.Errorf("Verify = %v, %v", ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviousPepper(t *testing.T) {
+	loadConfig(t, map[string]string{
+		"PASSWORD_PEPPER":           "new-pepper",
+		"PASSWORD_PREVIOUS_PEPPERS": "older-pepper, old-pepper",
+	})
+
+	for _, made := range []PasswordHasher{
+		NewArgon2id(testMemory, testTime, testThreads, "old-pepper"),
+		NewBcrypt(testCost, "old-pepper"),
+	} {
+		encoded, err := made.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := CurrentHasher()
+		if ok, err := h.Verify("correct horse", encoded); !ok || err != nil {
+			t.Errorf("Verify of %q under a previous pepper = %v, %v", encoded, ok, err)
+		}
+		if ok, err := h.Verify("battery staple", encoded); ok || err != nil {
+			t.Errorf("Verify of another password under a previous pepper = %v, %v", ok, err)
+		}
+		if !h.NeedsRehash(encoded) {
+			t.Errorf("%q is not rehashed with the current pepper", encoded)
+		}
+	}
+}
+
+func TestUnknownPepper(t *testing.T) {
+	loadConfig(t, map[string]string{"PASSWORD_PEPPER": "new-pepper"})
+
+	for _, made := range []PasswordHasher{
+		NewArgon2id(testMemory, testTime, testThreads, "lost-pepper"),
+		NewBcrypt(testCost, "lost-pepper"),
+	} {
+		encoded, err := made.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := CurrentHasher().Verify("correct horse", encoded); !errors.Is(err, ErrPepperChanged) {
+			t.Errorf("Verify of %q = %v, want ErrPepperChanged", encoded, err)
+		}
+	}
+}
+
+func loadConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	b := strings.Builder{}
+	b.WriteString("APP_ENV: " + config.EnvDevelopment + "\nJWT_SECRET_KEY: test-access-secret\nJWT_REFRESH_KEY: test-refresh-secret\n")
+	for k, v := range values {
+		b.WriteString(k + ": \"" + v + "\"\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package password
+
+import (
+	"fmt"
+	"restapi/internal/config"
+	"restapi/internal/constant"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Policy is the password policy set by the PASSWORD_* keys.
+type Policy struct {
+	MinLength   int
+	MaxLength   int
+	Classes     []string
+	History     int
+	MaxAge      time.Duration
+	BreachCheck bool
+}
+
+// CurrentPolicy returns the policy of the current config, it follows
+// reloads.
+func CurrentPolicy() Policy {
+	cfg := config.Cfg()
+	classes, _ := cfg.PasswordClassList()
+
+	return Policy{
+		MinLength:   cfg.PasswordMinLength,
+		MaxLength:   cfg.PasswordMaxLength,
+		Classes:     classes,
+		History:     cfg.PasswordHistory,
+		MaxAge:      cfg.PasswordMaxAge,
+		BreachCheck: cfg.PasswordBreachCheck,
+	}
+}
+
+// Valid reports whether password has an allowed length and every required
+// character class. It is the part of the policy a request validator can
+// check on its own.
+func (p Policy) Valid(password string) bool {
+	if len(password) < p.MinLength || len(password) > p.MaxLength {
+		return false
+	}
+
+	for _, class := range p.Classes {
+		if !hasClass(password, class) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Describe returns the required character classes, e.g. "letter, digit".
+func (p Policy) Describe() string {
+	if len(p.Classes) == 0 {
+		return "any character"
+	}
+
+	return strings.Join(p.Classes, ", ")
+}
+
+// Screen checks password against the breached list and the previous
+// password hashes, reporting every violation under field.
+func (p Policy) Screen(field, password string, previous []string) error {
+	res := &constant.ValidationError{}
+
+	if p.BreachCheck {
+		breached, err := Breached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			res.Fields = append(res.Fields, constant.FieldError{
+				Field:   field,
+				Rule:    "password_breached",
+				Message: fmt.Sprintf("%s appears in a list of breached passwords", field),
+			})
+		}
+	}
+
+	if p.History > 0 && Reused(password, previous) {
+		res.Fields = append(res.Fields, constant.FieldError{
+			Field:   field,
+			Rule:    "password_history",
+			Param:   strconv.Itoa(p.History),
+			Message: fmt.Sprintf("%s must differ from the last %d passwords", field, p.History),
+		})
+	}
+
+	if len(res.Fields) > 0 {
+		return res
+	}
+
+	return nil
+}
+
+// Expired reports whether a password changed at changedAt must be changed
+// before the account can be used again.
+func (p Policy) Expired(changedAt, now time.Time) bool {
+	return p.MaxAge > 0 && now.Sub(changedAt) > p.MaxAge
+}
+
+// Reused reports whether password matches one of the hashes.
+func Reused(password string, hashes []string) bool {
+	hasher := CurrentHasher()
+	for _, hash := range hashes {
+		if ok, _ := hasher.Verify(password, hash); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasClass(password, class string) bool {
+	for _, r := range password {
+		switch class {
+		case config.PasswordClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case config.PasswordClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case config.PasswordClassLetter:
+			if unicode.IsLetter(r) {
+				return true
+			}
+		case config.PasswordClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case config.PasswordClassSymbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
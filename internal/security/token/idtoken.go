@@ -0,0 +1,133 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"restapi/internal/config"
+	"restapi/internal/logger"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// JWK is the public part of a signing key, RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// IDTokenSigner signs OpenID Connect id_tokens with RS256.
+type IDTokenSigner struct {
+	key *rsa.PrivateKey
+	jwk JWK
+}
+
+var signer struct {
+	sync.Mutex
+	pem    string
+	signer *IDTokenSigner
+}
+
+// CurrentSigner returns the signer of OIDC_SIGNING_KEY. Without a key, only
+// allowed in development, a key is generated once per process.
+func CurrentSigner() (*IDTokenSigner, error) {
+	signer.Lock()
+	defer signer.Unlock()
+
+	pem := config.Cfg().OIDCSigningKeyPEM
+	if signer.signer != nil && signer.pem == pem {
+		return signer.signer, nil
+	}
+
+	key, err := config.Cfg().OIDCSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		logger.Log().Warn().Msg("OIDC_SIGNING_KEY is not set, id_tokens are signed with a generated key")
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signer.pem, signer.signer = pem, NewIDTokenSigner(key)
+	return signer.signer, nil
+}
+
+func NewIDTokenSigner(key *rsa.PrivateKey) *IDTokenSigner {
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+
+	// the key id is the JWK thumbprint of RFC 7638
+	thumbprint, _ := json.Marshal(map[string]string{"e": e, "kty": "RSA", "n": n})
+	sum := sha256.Sum256(thumbprint)
+
+	return &IDTokenSigner{
+		key: key,
+		jwk: JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: base64.RawURLEncoding.EncodeToString(sum[:]),
+			N:   n,
+			E:   e,
+		},
+	}
+}
+
+// Sign signs claims with the kid of the key in the header.
+func (s *IDTokenSigner) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.jwk.Kid
+
+	return token.SignedString(s.key)
+}
+
+// Parse verifies the signature of an id_token issued by s. The expiry is not
+// checked, an id_token_hint may have expired.
+func (s *IDTokenSigner) Parse(idToken string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return &s.key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("token invalid")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public key set served at the jwks_uri.
+func (s *IDTokenSigner) JWKS() JWKSet {
+	return JWKSet{Keys: []JWK{s.jwk}}
+}
+
+// AccessTokenHash returns the at_hash claim of accessToken, the left half
+// of its SHA-256 as RS256 requires.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"restapi/internal/app/model"
 	"restapi/internal/config"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,7 +22,10 @@ func NewToken() *tokenservice {
 
 type TokenInterface interface {
 	CreateToken(data map[string]interface{}) (*model.TokenDetails, error)
+	CreateAccessToken(data map[string]interface{}, ttl time.Duration) (*model.TokenDetails, error)
 	ExtractTokenMetadata(*http.Request) (*model.AccessDetails, error)
+	ParseAccessToken(tokenString string) (*model.AccessDetails, error)
+	ParseRefreshToken(tokenString string) (*model.AccessDetails, error)
 }
 
 //Token implements the TokenInterface
@@ -43,6 +47,10 @@ func (t *tokenservice) CreateToken(data map[string]interface{}) (*model.TokenDet
 	atClaims["user_id"] = data["user_id"]
 	atClaims["username"] = data["username"]
 	atClaims["user_role"] = data["user_role"]
+	if expired, _ := data["password_expired"].(bool); expired {
+		atClaims["password_expired"] = true
+	}
+	copyOAuthClaims(atClaims, data)
 	atClaims["exp"] = td.AtExpires
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 	td.AccessToken, err = at.SignedString([]byte(config.Cfg().JwtSecretKey))
@@ -59,6 +67,7 @@ func (t *tokenservice) CreateToken(data map[string]interface{}) (*model.TokenDet
 	rtClaims["user_id"] = data["user_id"]
 	rtClaims["username"] = data["username"]
 	rtClaims["user_role"] = data["user_role"]
+	copyOAuthClaims(rtClaims, data)
 	rtClaims["exp"] = td.RtExpires
 	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
 
@@ -69,6 +78,31 @@ func (t *tokenservice) CreateToken(data map[string]interface{}) (*model.TokenDet
 	return td, nil
 }
 
+// CreateAccessToken signs an access token without refresh token, valid for
+// ttl. Every entry of data becomes a claim.
+func (t *tokenservice) CreateAccessToken(data map[string]interface{}, ttl time.Duration) (*model.TokenDetails, error) {
+	td := &model.TokenDetails{}
+	td.AtExpires = time.Now().Add(ttl).Unix()
+	tokenUuid, _ := uuid.NewV4()
+	td.TokenUuid = tokenUuid.String()
+
+	atClaims := jwt.MapClaims{}
+	for k, v := range data {
+		atClaims[k] = v
+	}
+	atClaims["access_uuid"] = td.TokenUuid
+	atClaims["exp"] = td.AtExpires
+
+	var err error
+	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
+	td.AccessToken, err = at.SignedString([]byte(config.Cfg().JwtSecretKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return td, nil
+}
+
 func TokenValid(r *http.Request) (*model.AccessDetails, error) {
 	token, err := verifyToken(r)
 	if err != nil {
@@ -89,12 +123,15 @@ func TokenValid(r *http.Request) (*model.AccessDetails, error) {
 }
 
 func verifyToken(r *http.Request) (*jwt.Token, error) {
-	tokenString := extractToken(r)
+	return parse(extractToken(r), config.Cfg().JwtSecretKey)
+}
+
+func parse(tokenString string, key string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(config.Cfg().JwtSecretKey), nil
+		return []byte(key), nil
 	})
 	if err != nil {
 		return nil, err
@@ -119,15 +156,28 @@ func extract(token *jwt.Token) (*model.AccessDetails, error) {
 		userId, userOk := claims["user_id"].(float64)
 		username, usernameOk := claims["username"].(string)
 		role, roleOk := claims["user_role"].(string)
+		passwordExpired, _ := claims["password_expired"].(bool)
+		clientID, _ := claims["client_id"].(string)
+		exp, _ := claims["exp"].(float64)
+		actor := extractActor(claims)
+		var scopes []string
+		if scope, ok := claims["scope"].(string); ok {
+			scopes = strings.Fields(scope)
+		}
 
 		if !ok && !userOk && !usernameOk && !roleOk {
 			return nil, errors.New("unauthorized")
 		} else {
 			return &model.AccessDetails{
-				TokenUuid: accessUuid,
-				UserId:    uint(userId),
-				Username:  username,
-				Role:      role,
+				TokenUuid:       accessUuid,
+				UserId:          uint(userId),
+				Username:        username,
+				Role:            role,
+				PasswordExpired: passwordExpired,
+				ClientID:        clientID,
+				Scopes:          scopes,
+				ExpiresAt:       int64(exp),
+				Actor:           actor,
 			}, nil
 		}
 	}
@@ -145,3 +195,68 @@ func (t *tokenservice) ExtractTokenMetadata(r *http.Request) (*model.AccessDetai
 	}
 	return acc, nil
 }
+
+// ParseAccessToken verifies an access token given as a string, e.g. to an
+// introspection endpoint.
+func (t *tokenservice) ParseAccessToken(tokenString string) (*model.AccessDetails, error) {
+	token, err := parse(tokenString, config.Cfg().JwtSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return extract(token)
+}
+
+// ParseRefreshToken verifies a refresh token, its refresh_uuid is returned
+// in RefreshUuid.
+func (t *tokenservice) ParseRefreshToken(tokenString string) (*model.AccessDetails, error) {
+	token, err := parse(tokenString, config.Cfg().JwtRefreshKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("token invalid")
+	}
+
+	refreshUuid, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		return nil, errors.New("not a refresh token")
+	}
+
+	acc, err := extract(token)
+	if err != nil {
+		return nil, err
+	}
+	acc.RefreshUuid = refreshUuid
+
+	return acc, nil
+}
+
+// copyOAuthClaims adds the claims of tokens issued to an OAuth client.
+func copyOAuthClaims(claims jwt.MapClaims, data map[string]interface{}) {
+	for _, k := range []string{"client_id", "scope"} {
+		if v, ok := data[k]; ok {
+			claims[k] = v
+		}
+	}
+}
+
+// extractActor reads the act claim of RFC 8693 section 4.1, set on the
+// tokens of an admin impersonating a user.
+func extractActor(claims jwt.MapClaims) *model.Actor {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	sub, _ := act["sub"].(string)
+	id, err := strconv.ParseUint(sub, 10, 64)
+	if err != nil || id == 0 {
+		return nil
+	}
+	username, _ := act["username"].(string)
+
+	return &model.Actor{UserId: uint(id), Username: username}
+}
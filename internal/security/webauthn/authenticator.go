@@ -0,0 +1,150 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// SoftAuthenticator is an authenticator in memory for development and
+// tests. It plays the part of the browser too: its credentials are ES256
+// and discoverable, and its signature counters start at 1.
+type SoftAuthenticator struct {
+	// Flags are added to user presence in the authenticator data, e.g.
+	// FlagUserVerified.
+	Flags byte
+
+	mu          sync.Mutex
+	credentials []*softCredential
+}
+
+type softCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// Create answers navigator.credentials.create with opts from origin.
+func (a *SoftAuthenticator) Create(origin string, opts *CreationOptions) (*AttestationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, excluded := range opts.ExcludeCredentials {
+		if a.find(opts.RP.ID, excluded.ID) != nil {
+			return nil, errors.New("webauthn: authenticator already holds an excluded credential")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cred := &softCredential{id: make([]byte, 32), rpID: opts.RP.ID, userHandle: opts.User.ID, key: key}
+	_, err = rand.Read(cred.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// a new credential replaces the one of the same user, as a platform
+	// authenticator does
+	kept := a.credentials[:0]
+	for _, c := range a.credentials {
+		if c.rpID != cred.rpID || string(c.userHandle) != string(cred.userHandle) {
+			kept = append(kept, c)
+		}
+	}
+	a.credentials = append(kept, cred)
+
+	clientData, err := json.Marshal(ClientData{Type: typeCreate, Challenge: opts.Challenge, Origin: origin})
+	if err != nil {
+		return nil, err
+	}
+
+	attested := make([]byte, 18, 18+len(cred.id))
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(cred.id)))
+	attested = append(append(attested, cred.id...), encodeES256(&key.PublicKey)...)
+
+	res := &AttestationResponse{ID: cred.id, Type: "public-key"}
+	res.Response.ClientDataJSON = clientData
+	res.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authenticatorData(cred, FlagAttestedCredentialData, attested),
+	})
+	res.Response.Transports = []string{"internal"}
+
+	return res, nil
+}
+
+// Get answers navigator.credentials.get with opts from origin, with the
+// first allowed credential or, without AllowCredentials, the first
+// discoverable one of the relying party.
+func (a *SoftAuthenticator) Get(origin string, opts *RequestOptions) (*AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *softCredential
+	if len(opts.AllowCredentials) == 0 {
+		for _, c := range a.credentials {
+			if c.rpID == opts.RPID {
+				cred = c
+				break
+			}
+		}
+	}
+	for _, allowed := range opts.AllowCredentials {
+		if cred = a.find(opts.RPID, allowed.ID); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, errors.New("webauthn: authenticator has no matching credential")
+	}
+
+	clientData, err := json.Marshal(ClientData{Type: typeGet, Challenge: opts.Challenge, Origin: origin})
+	if err != nil {
+		return nil, err
+	}
+
+	cred.signCount++
+	authData := a.authenticatorData(cred, 0, nil)
+	clientDataHash := sha256.Sum256(clientData)
+	sum := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	res := &AssertionResponse{ID: cred.id, Type: "public-key"}
+	res.Response.ClientDataJSON = clientData
+	res.Response.AuthenticatorData = authData
+	res.Response.Signature = sig
+	res.Response.UserHandle = cred.userHandle
+
+	return res, nil
+}
+
+func (a *SoftAuthenticator) find(rpID string, id []byte) *softCredential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && string(c.id) == string(id) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (a *SoftAuthenticator) authenticatorData(cred *softCredential, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], FlagUserPresent|a.Flags|flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], cred.signCount)
+
+	return append(data, attested...)
+}
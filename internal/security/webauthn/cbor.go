@@ -0,0 +1,218 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// the subset of CBOR, RFC 8949, used by attestation objects and COSE keys:
+// integers, byte and text strings, arrays, maps, booleans and null. Tags,
+// floats and indefinite lengths are refused.
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborSimple   = 7
+
+	cborMaxDepth = 16
+)
+
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// decodeCBOR decodes the first item of b and returns the bytes after it.
+// Integers decode to int64, maps to map[interface{}]interface{}.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth || len(b) == 0 {
+		return nil, nil, errCBOR
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		default:
+			return nil, nil, fmt.Errorf("webauthn: unsupported CBOR simple value %d", info)
+		}
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, errCBOR
+		}
+		for _, c := range b[:n] {
+			arg = arg<<8 | uint64(c)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, fmt.Errorf("webauthn: unsupported CBOR length %d", info)
+	}
+
+	switch major {
+	case cborUnsigned:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(arg), b, nil
+	case cborNegative:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), b, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBOR
+		}
+		if major == cborText {
+			return string(b[:arg]), b[arg:], nil
+		}
+		return append([]byte(nil), b[:arg]...), b[arg:], nil
+	case cborArray:
+		// every item takes at least a byte
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBOR
+		}
+		res := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			var err error
+			item, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			res = append(res, item)
+		}
+		return res, b, nil
+	case cborMap:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, errCBOR
+		}
+		res := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			var err error
+			key, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("webauthn: CBOR map key must be an integer or a text")
+			}
+			if _, ok := res[key]; ok {
+				return nil, nil, errors.New("webauthn: duplicate CBOR map key")
+			}
+			value, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			res[key] = value
+		}
+		return res, b, nil
+	default:
+		return nil, nil, fmt.Errorf("webauthn: unsupported CBOR major type %d", major)
+	}
+}
+
+// encodeCBOR encodes v in the canonical form of the decoder, for the
+// software authenticator. Map keys are sorted by their encoding.
+func encodeCBOR(v interface{}) []byte {
+	buf := new(bytes.Buffer)
+	encodeItem(buf, v)
+	return buf.Bytes()
+}
+
+func encodeItem(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(cborSimple<<5 | 22)
+	case bool:
+		if value {
+			buf.WriteByte(cborSimple<<5 | 21)
+		} else {
+			buf.WriteByte(cborSimple<<5 | 20)
+		}
+	case int:
+		encodeItem(buf, int64(value))
+	case int64:
+		if value < 0 {
+			encodeHead(buf, cborNegative, uint64(-1-value))
+		} else {
+			encodeHead(buf, cborUnsigned, uint64(value))
+		}
+	case []byte:
+		encodeHead(buf, cborBytes, uint64(len(value)))
+		buf.Write(value)
+	case string:
+		encodeHead(buf, cborText, uint64(len(value)))
+		buf.WriteString(value)
+	case []interface{}:
+		encodeHead(buf, cborArray, uint64(len(value)))
+		for _, item := range value {
+			encodeItem(buf, item)
+		}
+	case map[interface{}]interface{}:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(value))
+		for k, item := range value {
+			entries = append(entries, entry{encodeCBOR(k), encodeCBOR(item)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return bytes.Compare(a, b) < 0
+		})
+
+		encodeHead(buf, cborMap, uint64(len(value)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			buf.Write(e.value)
+		}
+	default:
+		panic(fmt.Sprintf("webauthn: cannot encode %T as CBOR", v))
+	}
+}
+
+func encodeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		_ = binary.Write(buf, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		_ = binary.Write(buf, binary.BigEndian, uint32(arg))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		_ = binary.Write(buf, binary.BigEndian, arg)
+	}
+}
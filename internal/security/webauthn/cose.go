@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms of the credentials we accept, RFC 9053 and RFC 8812
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters, RFC 9052 section 7 and RFC 9053 section 7
+const (
+	coseKty = 1
+	coseAlg = 3
+	// EC2 and OKP keys
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	// RSA keys
+	coseN = -1
+	coseE = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+
+	minRSABits = 2048
+)
+
+// SupportedAlgorithms are offered to authenticators in order of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// publicKey is a credential public key decoded from its COSE form.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, the key is checked so a credential
+// with a key we cannot verify is refused at registration.
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes after the COSE key")
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: COSE key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: ES256 key must be a P-256 point")
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("webauthn: ES256 key is not on the curve")
+		}
+		return &publicKey{alg, key}, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: EdDSA key must be an Ed25519 key")
+		}
+		return &publicKey{alg, ed25519.PublicKey(x)}, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: RS256 key has an invalid exponent")
+		}
+		exponent := 0
+		for _, c := range e {
+			exponent = exponent<<8 | int(c)
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+		if key.N.BitLen() < minRSABits || exponent < 3 || exponent%2 == 0 {
+			return nil, fmt.Errorf("webauthn: RS256 key must have at least %d bits and an odd exponent", minRSABits)
+		}
+		return &publicKey{alg, key}, nil
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported COSE key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verify checks sig over data with the algorithm of the key.
+func (k *publicKey) verify(data, sig []byte) error {
+	ok := false
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("webauthn: invalid signature")
+	}
+
+	return nil
+}
+
+// encodeES256 returns the COSE_Key of an ES256 public key.
+func encodeES256(key *ecdsa.PublicKey) []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return encodeCBOR(map[interface{}]interface{}{
+		int64(coseKty): int64(coseKtyEC2),
+		int64(coseAlg): int64(AlgES256),
+		int64(coseCrv): int64(coseCrvP256),
+		int64(coseX):   x,
+		int64(coseY):   y,
+	})
+}
@@ -0,0 +1,447 @@
+// Package webauthn verifies the registration and authentication ceremonies
+// of Web Authentication level 2 for a relying party. Attestation statements
+// are not checked, the relying party asks for none, so a credential proves
+// possession of its key but not the make of the authenticator. A software
+// authenticator is provided to try the ceremonies without a browser.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// authenticator data flags, section 6.1
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagBackupEligible         = 0x08
+	FlagBackupState            = 0x10
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+)
+
+// client data types of the two ceremonies
+const (
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+// user verification requirements
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+// ErrSignCount is returned for an assertion whose signature counter did not
+// grow, the credential may have been cloned.
+var ErrSignCount = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+
+// Base64URL is binary data carried in JSON as unpadded base64url, the
+// encoding of the WebAuthn JSON serialization.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b Base64URL) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewChallenge returns 32 random bytes, base64url encoded as they come back
+// in the client data.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RelyingParty is the server side of the ceremonies.
+type RelyingParty struct {
+	// ID is the domain the credentials are scoped to.
+	ID   string
+	Name string
+	// Origins are the origins the ceremonies may run in.
+	Origins          []string
+	UserVerification string
+	Timeout          time.Duration
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions of
+// navigator.credentials.create, binary values in base64url.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions of
+// navigator.credentials.get. Without AllowCredentials the authenticator
+// offers its discoverable credentials.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential for the user of
+// userHandle, which must not identify the person outside this server.
+func (rp *RelyingParty) CreationOptions(challenge string, userHandle []byte, name, displayName string, exclude []CredentialDescriptor) *CreationOptions {
+	params := make([]CredentialParameter, len(SupportedAlgorithms))
+	for i, alg := range SupportedAlgorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return &CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:               UserEntity{ID: userHandle, Name: name, DisplayName: displayName},
+		PubKeyCredParams:   params,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   rp.UserVerification,
+		},
+		Attestation: "none",
+	}
+}
+
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: rp.UserVerification,
+	}
+}
+
+// ClientData is the collected client data, section 5.8.1.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData decodes clientDataJSON, its challenge tells which
+// ceremony a response answers.
+func ParseClientData(raw []byte) (*ClientData, error) {
+	cd := new(ClientData)
+	err := json.Unmarshal(raw, cd)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: malformed client data: %w", err)
+	}
+
+	return cd, nil
+}
+
+// AttestationResponse is the PublicKeyCredential returned by
+// navigator.credentials.create.
+type AttestationResponse struct {
+	ID       Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports"`
+	} `json:"response"`
+	ClientExtensionResults struct {
+		CredProps *struct {
+			RK bool `json:"rk"`
+		} `json:"credProps,omitempty"`
+	} `json:"clientExtensionResults"`
+}
+
+// AssertionResponse is the PublicKeyCredential returned by
+// navigator.credentials.get.
+type AssertionResponse struct {
+	ID       Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// AuthenticatorData is the data signed by the authenticator, section 6.1.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// set at registration only
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func parseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+
+	data := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if data.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		data.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, errors.New("webauthn: invalid credential id length")
+		}
+		data.CredentialID = rest[:n]
+		rest = rest[n:]
+
+		// the key is followed by the extensions, its CBOR length says where
+		// it ends
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		data.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if data.Flags&FlagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes after the authenticator data")
+	}
+
+	return data, nil
+}
+
+// Credential is a verified new credential.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+	Flags     byte
+	// Discoverable is set when the client reported a resident key.
+	Discoverable bool
+	Transports   []string
+}
+
+// VerifyRegistration checks the response to CreationOptions with challenge,
+// section 7.1.
+func (rp *RelyingParty) VerifyRegistration(challenge string, res *AttestationResponse) (*Credential, error) {
+	err := rp.checkClientData(res.Response.ClientDataJSON, typeCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(res.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+	if _, ok := obj["fmt"].(string); !ok {
+		return nil, errors.New("webauthn: attestation object has no format")
+	}
+	raw, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	data, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = rp.checkAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+	if data.Flags&FlagAttestedCredentialData == 0 {
+		return nil, errors.New("webauthn: registration has no credential")
+	}
+	if !bytes.Equal(data.CredentialID, res.ID) {
+		return nil, errors.New("webauthn: credential id does not match the authenticator data")
+	}
+
+	_, err = parsePublicKey(data.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &Credential{
+		ID:         data.CredentialID,
+		PublicKey:  data.PublicKey,
+		SignCount:  data.SignCount,
+		AAGUID:     data.AAGUID,
+		Flags:      data.Flags,
+		Transports: res.Response.Transports,
+	}
+	// the credential was required to be discoverable, without credProps
+	// the client did not say otherwise
+	cred.Discoverable = res.ClientExtensionResults.CredProps == nil || res.ClientExtensionResults.CredProps.RK
+
+	return cred, nil
+}
+
+// VerifyAssertion checks the response to RequestOptions with challenge for
+// the credential of publicKey, section 7.2. It returns the authenticator
+// data so the caller can store the new signature counter.
+func (rp *RelyingParty) VerifyAssertion(challenge string, res *AssertionResponse, publicKey []byte, signCount uint32) (*AuthenticatorData, error) {
+	err := rp.checkClientData(res.Response.ClientDataJSON, typeGet, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := parseAuthenticatorData(res.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	err = rp.checkAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(res.Response.ClientDataJSON)
+	signed := append(append([]byte{}, res.Response.AuthenticatorData...), clientDataHash[:]...)
+	err = key.verify(signed, res.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	// authenticators without a counter always send 0
+	if (data.SignCount != 0 || signCount != 0) && data.SignCount <= signCount {
+		return data, ErrSignCount
+	}
+
+	return data, nil
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ, challenge string) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+
+	if cd.Type != typ {
+		return fmt.Errorf("webauthn: client data type is %q, expected %q", cd.Type, typ)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("webauthn: challenge does not match")
+	}
+	if cd.CrossOrigin {
+		return errors.New("webauthn: cross-origin ceremonies are not allowed")
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("webauthn: origin %q is not allowed", cd.Origin)
+}
+
+func (rp *RelyingParty) checkAuthenticatorData(data *AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data.RPIDHash, rpIDHash[:]) != 1 {
+		return errors.New("webauthn: rp id hash does not match")
+	}
+	if data.Flags&FlagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+	if rp.UserVerification == UserVerificationRequired && data.Flags&FlagUserVerified == 0 {
+		return errors.New("webauthn: user was not verified")
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testOrigin = "https://app.example.com"
+
+func testRelyingParty() *RelyingParty {
+	return &RelyingParty{
+		ID:               "example.com",
+		Name:             "restapi",
+		Origins:          []string{testOrigin},
+		UserVerification: UserVerificationPreferred,
+		Timeout:          time.Minute,
+	}
+}
+
+// roundTrip sends v through JSON like the browser and the API do.
+func roundTrip(t *testing.T, v, out interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func challenge(t *testing.T) string {
+	t.Helper()
+
+	c, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// register creates a credential of userHandle on a and verifies it.
+func register(t *testing.T, rp *RelyingParty, a *SoftAuthenticator, userHandle []byte) *Credential {
+	t.Helper()
+
+	c := challenge(t)
+	var opts CreationOptions
+	roundTrip(t, rp.CreationOptions(c, userHandle, "alice", "Alice", nil), &opts)
+
+	res, err := a.Create(testOrigin, &opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var att AttestationResponse
+	roundTrip(t, res, &att)
+
+	cred, err := rp.VerifyRegistration(c, &att)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	return cred
+}
+
+// assert answers new request options allowing allow.
+func assert(t *testing.T, rp *RelyingParty, a *SoftAuthenticator, allow []CredentialDescriptor) (string, *AssertionResponse) {
+	t.Helper()
+
+	c := challenge(t)
+	var opts RequestOptions
+	roundTrip(t, rp.RequestOptions(c, allow), &opts)
+
+	res, err := a.Get(testOrigin, &opts)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var assertion AssertionResponse
+	roundTrip(t, res, &assertion)
+
+	return c, &assertion
+}
+
+func TestRegistrationAssertionRoundTrip(t *testing.T) {
+	rp := testRelyingParty()
+	a := &SoftAuthenticator{}
+
+	cred := register(t, rp, a, []byte("42"))
+	if len(cred.ID) == 0 || len(cred.PublicKey) == 0 || !cred.Discoverable {
+		t.Fatalf("registered %+v, want a discoverable credential with its key", cred)
+	}
+
+	c, res := assert(t, rp, a, []CredentialDescriptor{{Type: "public-key", ID: cred.ID}})
+	if !bytes.Equal(res.ID, cred.ID) {
+		t.Fatal("the assertion is not made with the allowed credential")
+	}
+
+	data, err := rp.VerifyAssertion(c, res, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if data.SignCount != cred.SignCount+1 {
+		t.Errorf("sign count %d, want %d", data.SignCount, cred.SignCount+1)
+	}
+
+	// the assertion is bound to its challenge and origin
+	if _, err := rp.VerifyAssertion(challenge(t), res, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted another challenge")
+	}
+	other := testRelyingParty()
+	other.Origins = []string{"https://other.example.com"}
+	if _, err := other.VerifyAssertion(c, res, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted another origin")
+	}
+
+	// the signature covers the authenticator data
+	tampered := *res
+	tampered.Response.AuthenticatorData = append(Base64URL{}, res.Response.AuthenticatorData...)
+	tampered.Response.AuthenticatorData[32] |= FlagUserVerified
+	if _, err := rp.VerifyAssertion(c, &tampered, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted tampered authenticator data")
+	}
+}
+
+func TestVerifyAssertionSignCount(t *testing.T) {
+	rp := testRelyingParty()
+	a := &SoftAuthenticator{}
+	cred := register(t, rp, a, []byte("42"))
+	allow := []CredentialDescriptor{{Type: "public-key", ID: cred.ID}}
+
+	c, res := assert(t, rp, a, allow)
+	data, err := rp.VerifyAssertion(c, res, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+
+	// a clone answers with a counter the server has already seen
+	if _, err := rp.VerifyAssertion(c, res, cred.PublicKey, data.SignCount); err != ErrSignCount {
+		t.Errorf("VerifyAssertion of a replayed counter = %v, want ErrSignCount", err)
+	}
+
+	c, res = assert(t, rp, a, allow)
+	if _, err := rp.VerifyAssertion(c, res, cred.PublicKey, data.SignCount+10); err != ErrSignCount {
+		t.Errorf("VerifyAssertion of a lower counter = %v, want ErrSignCount", err)
+	}
+	if _, err := rp.VerifyAssertion(c, res, cred.PublicKey, data.SignCount); err != nil {
+		t.Errorf("VerifyAssertion of the next counter: %v", err)
+	}
+}
+
+func TestDiscoverableAssertion(t *testing.T) {
+	rp := testRelyingParty()
+	a := &SoftAuthenticator{}
+	cred := register(t, rp, a, []byte("42"))
+
+	// without allowed credentials the authenticator picks the user
+	c, res := assert(t, rp, a, nil)
+	if !bytes.Equal(res.ID, cred.ID) || string(res.Response.UserHandle) != "42" {
+		t.Fatalf("assertion of %x for user %q, want the registered credential and its user handle", res.ID, res.Response.UserHandle)
+	}
+	if _, err := rp.VerifyAssertion(c, res, cred.PublicKey, cred.SignCount); err != nil {
+		t.Errorf("VerifyAssertion: %v", err)
+	}
+}
+
+func TestUserVerificationRequired(t *testing.T) {
+	rp := testRelyingParty()
+	rp.UserVerification = UserVerificationRequired
+
+	c := challenge(t)
+	res, err := (&SoftAuthenticator{}).Create(testOrigin, rp.CreationOptions(c, []byte("42"), "alice", "Alice", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyRegistration(c, res); err == nil {
+		t.Error("VerifyRegistration accepted a user that was not verified")
+	}
+
+	cred := register(t, rp, &SoftAuthenticator{Flags: FlagUserVerified}, []byte("42"))
+	if cred.Flags&FlagUserVerified == 0 {
+		t.Error("the verified flag was not kept")
+	}
+}
+
+func TestCreateExcludesRegisteredCredentials(t *testing.T) {
+	rp := testRelyingParty()
+	a := &SoftAuthenticator{}
+	cred := register(t, rp, a, []byte("42"))
+
+	exclude := []CredentialDescriptor{{Type: "public-key", ID: cred.ID}}
+	if _, err := a.Create(testOrigin, rp.CreationOptions(challenge(t), []byte("42"), "alice", "Alice", exclude)); err == nil {
+		t.Error("Create registered the authenticator twice")
+	}
+}
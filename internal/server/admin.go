@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+	"restapi/internal/metrics"
+)
+
+// NewAdminHandler serves the operational endpoints that must not be reachable
+// through the public port.
+func NewAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	return mux
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"restapi/internal/constant"
+	"restapi/internal/db/migration"
+	"restapi/internal/db/postgres"
+	"restapi/internal/db/redis"
+	"restapi/internal/logger"
+	"restapi/internal/web"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const checkTimeout = 2 * time.Second
+
+// shuttingDown is set as soon as a shutdown signal is received so the load
+// balancer stops routing traffic before the server stops accepting it.
+var shuttingDown int32
+
+type dependencyStatus struct {
+	Status  string   `json:"status"`
+	Latency string   `json:"latency,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Pending []string `json:"pending,omitempty"`
+}
+
+type healthHandler struct {
+	pg  postgres.Client
+	rds redis.Client
+}
+
+func newHealthHandler(pg postgres.Client, rds redis.Client) *healthHandler {
+	return &healthHandler{pg, rds}
+}
+
+// Live answers as long as the process serves HTTP.
+func (h *healthHandler) Live(c *gin.Context) {
+	web.MarshalPayload(c, http.StatusOK, "alive", nil)
+}
+
+// Ready checks every dependency concurrently and answers 503 when one of
+// them fails or the server is shutting down.
+func (h *healthHandler) Ready(c *gin.Context) {
+	checks := map[string]func(context.Context) dependencyStatus{
+		"postgres":   h.checkPostgres,
+		"redis":      h.checkRedis,
+		"migrations": h.checkMigrations,
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		res = map[string]dependencyStatus{}
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) dependencyStatus) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			status := check(ctx)
+			status.Latency = time.Since(start).String()
+
+			mu.Lock()
+			res[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	ready := atomic.LoadInt32(&shuttingDown) == 0
+	if !ready {
+		res["server"] = dependencyStatus{Status: "down", Error: "shutting down"}
+	}
+	for _, status := range res {
+		if status.Status != "up" {
+			ready = false
+		}
+	}
+
+	if !ready {
+		// the raw errors stay in the log, the probe only learns which
+		// dependency is down
+		var fields []constant.FieldError
+		for name, status := range res {
+			if status.Status == "up" {
+				continue
+			}
+
+			logger.Ctx(c.Request.Context()).Warn().Str("dependency", name).Str("error", status.Error).Strs("pending", status.Pending).Msg("not ready")
+			fields = append(fields, constant.FieldError{Field: name, Rule: "up", Message: name + " is down"})
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+
+		web.MarshalError(c, constant.ErrNotReady, fields...)
+		return
+	}
+
+	web.MarshalPayload(c, http.StatusOK, "ready", res)
+}
+
+func (h *healthHandler) checkPostgres(ctx context.Context) dependencyStatus {
+	db, err := h.pg.Conn().DB()
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
+
+	return statusOf(err)
+}
+
+func (h *healthHandler) checkRedis(ctx context.Context) dependencyStatus {
+	return statusOf(h.rds.Conn().Ping(ctx).Err())
+}
+
+func (h *healthHandler) checkMigrations(ctx context.Context) dependencyStatus {
+	pending, err := migration.Pending(h.pg.Conn().WithContext(ctx))
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	status := statusOf(err)
+	if err == nil && len(pending) > 0 {
+		status.Status = "down"
+		status.Error = "pending migrations"
+		status.Pending = pending
+	}
+
+	return status
+}
+
+func statusOf(err error) dependencyStatus {
+	if err != nil {
+		return dependencyStatus{Status: "down", Error: err.Error()}
+	}
+
+	return dependencyStatus{Status: "up"}
+}
@@ -1,15 +1,23 @@
 package server
 
 import (
+	"fmt"
 	"restapi/internal/app/handler"
+	"restapi/internal/app/model"
 	"restapi/internal/app/repository"
 	"restapi/internal/app/service"
+	"restapi/internal/config"
+	"restapi/internal/constant"
 	"restapi/internal/db/postgres"
 	"restapi/internal/db/redis"
 	"restapi/internal/logger"
+	"restapi/internal/mail"
+	"restapi/internal/metrics"
 	"restapi/internal/security/middleware"
 	"restapi/internal/security/token"
+	"restapi/internal/tracing"
 	"restapi/internal/validation"
+	"restapi/internal/web"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -17,35 +25,117 @@ import (
 )
 
 func NewRouter(pg postgres.Client, rds redis.Client) *gin.Engine {
+	if config.Cfg().AppEnv != config.EnvDevelopment {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
 	router := gin.New()
-	store := cookie.NewStore([]byte("secret"))
-	router.Use(gin.Recovery(), logger.Logger(), middleware.CORSMiddleware(), sessions.Sessions("test", store))
+	store := cookie.NewStore([]byte(config.Cfg().SessionSecret))
+	router.Use(gin.CustomRecovery(recovery), tracing.Middleware(), logger.RequestID(), logger.Logger(), metrics.Middleware(), middleware.Timeout(), middleware.CORSMiddleware(), sessions.Sessions("test", store))
 
 	tk := token.NewToken()
 	authRepo := repository.NewAuthRepo(rds)
 	userRepo := repository.NewUserRepo(pg, rds)
 	customRepo := repository.NewCustom(pg)
 
-	authService := service.NewAuthService(userRepo, authRepo, tk)
-	userService := service.NewUserService(userRepo, customRepo)
+	authenticator := service.NewAuthenticatorChain(userRepo, service.NewLocalAuthenticator(userRepo), service.NewLDAPAuthenticator(userRepo))
+	credentialRepo := repository.NewWebAuthnCredentialRepo(pg)
+	authService := service.NewAuthService(userRepo, authRepo, tk, authenticator, credentialRepo)
+	historyRepo := repository.NewPasswordHistoryRepo(pg)
+	tokenRepo := repository.NewAccessTokenRepo(pg)
+	userService := service.NewUserService(userRepo, customRepo, historyRepo)
+	tokenService := service.NewAccessTokenService(tokenRepo, userRepo)
+	auditService := service.NewAuditService(repository.NewAuditRepo(pg))
+	accountService := service.NewServiceAccountService(repository.NewServiceAccountRepo(pg), authRepo, auditService, tk)
+	oauthService := service.NewOAuthService(repository.NewOAuthClientRepo(pg), userRepo, authRepo, authService, auditService, tk)
+	externalLoginService := service.NewExternalLoginService(userRepo, repository.NewUserIdentityRepo(pg), authRepo, authService, auditService)
+	magicLinkService := service.NewMagicLinkService(userRepo, authRepo, authService, auditService, mail.New())
+	passkeyService := service.NewPasskeyService(credentialRepo, userRepo, authRepo, auditService)
+	impersonationService := service.NewImpersonationService(userRepo, authRepo, auditService, tk)
 
 	authHandler := handler.NewAuthHandler(authService, tk)
 	userHandler := handler.NewUserHandler(userService)
+	tokenHandler := handler.NewAccessTokenHandler(tokenService)
+	oauthHandler := handler.NewOAuthHandler(oauthService, accountService, authService)
+	oidcHandler := handler.NewOIDCHandler(oauthService)
+	externalLoginHandler := handler.NewExternalLoginHandler(externalLoginService)
+	magicLinkHandler := handler.NewMagicLinkHandler(magicLinkService)
+	passkeyHandler := handler.NewPasskeyHandler(passkeyService, authService)
+	impersonationHandler := handler.NewImpersonationHandler(impersonationService)
+
+	limiter := middleware.NewRateLimiter(rds)
+	authenticated := middleware.SetupAuthenticationMiddleware(tokenService, authService, auditService)
+	router.NoRoute(func(c *gin.Context) { web.MarshalError(c, constant.ErrNotFound) })
+
+	healthHandler := newHealthHandler(pg, rds)
+	router.GET("/healthz", healthHandler.Live)
+	router.GET("/readyz", healthHandler.Ready)
 
 	api := router.Group("/api")
-	api.POST("/login", authHandler.Login)
-	api.POST("/register/:role", userHandler.Create)
-	api.GET("/captcha", validation.CaptchaHandler)
-
-	user := router.Group("/user", middleware.SetupAuthenticationMiddleware())
-	user.GET("/:id", userHandler.Get)
-	user.GET("/", userHandler.GetByToken)
-	user.POST("/list", userHandler.List)
-	user.PUT("/:id", middleware.Authorize(), userHandler.Update)
-	user.PUT("/password/:id", middleware.Authorize(), userHandler.UpdatePassword)
-	user.DELETE("/:id", middleware.Authorize(), userHandler.Delete)
+	api.POST("/login", limiter.Limit("login"), authHandler.Login)
+	api.GET("/login/oidc/:provider", limiter.Limit("login"), externalLoginHandler.Start)
+	api.GET("/login/oidc/:provider/callback", limiter.Limit("login"), externalLoginHandler.Callback)
+	api.POST("/login/magic", middleware.RequireFeature(config.FeatureMagicLink), limiter.Limit("magic"), magicLinkHandler.Send)
+	api.GET("/login/magic/callback", middleware.RequireFeature(config.FeatureMagicLink), limiter.Limit("login"), magicLinkHandler.Callback)
+	api.POST("/login/passkey/options", limiter.Limit("login"), passkeyHandler.LoginOptions)
+	api.POST("/login/passkey", limiter.Limit("login"), passkeyHandler.Login)
+	api.POST("/register/:role", middleware.RequireFeature("register"), userHandler.Create)
+	api.GET("/captcha", limiter.Limit("captcha"), validation.CaptchaHandler)
+
+	router.GET("/.well-known/openid-configuration", oidcHandler.Discovery)
+	router.GET("/.well-known/jwks.json", oidcHandler.JWKS)
+
+	oauth := router.Group("/oauth")
+	oauth.GET("/authorize", oauthHandler.Authorize)
+	oauth.POST("/authorize", oauthHandler.Consent)
+	oauth.POST("/login", limiter.Limit("login"), oauthHandler.Login)
+	oauth.POST("/token", limiter.Limit("oauth"), oauthHandler.Token)
+	oauth.POST("/revoke", limiter.Limit("oauth"), oauthHandler.Revoke)
+	oauth.POST("/introspect", limiter.Limit("oauth"), oauthHandler.Introspect)
+	oauth.GET("/logout", oidcHandler.Logout)
+	oauth.POST("/logout", oidcHandler.Logout)
+
+	userinfo := middleware.RequireScope(model.ScopeOpenID)
+	oauth.GET("/userinfo", authenticated, userinfo, oidcHandler.UserInfo)
+	oauth.POST("/userinfo", authenticated, userinfo, oidcHandler.UserInfo)
+
+	read := middleware.RequireScope(model.ScopeUserRead)
+	write := middleware.RequireScope(model.ScopeUserWrite)
+
+	user := router.Group("/user", authenticated, limiter.Limit("user"))
+	user.GET("/:id", read, userHandler.Get)
+	user.GET("/", read, userHandler.GetByToken)
+	user.POST("/list", read, userHandler.List)
+	user.PUT("/:id", write, middleware.Authorize(), userHandler.Update)
+	user.PUT("/password/:id", write, middleware.AuthorizeSelfOrAdmin(), userHandler.UpdatePassword)
+	user.DELETE("/:id", write, middleware.Authorize(), userHandler.Delete)
+
+	tokens := user.Group("/tokens", middleware.RequireScope(model.ScopeTokens))
+	tokens.GET("", tokenHandler.List)
+	tokens.POST("", tokenHandler.Create)
+	tokens.DELETE("/:id", tokenHandler.Revoke)
+
+	session := middleware.RequireSession()
+	passkeys := user.Group("/passkeys", middleware.RequireScope(model.ScopeTokens))
+	passkeys.GET("", passkeyHandler.List)
+	passkeys.POST("/reauth/options", session, passkeyHandler.ReauthOptions)
+	passkeys.POST("/reauth", session, passkeyHandler.Reauth)
+	passkeys.POST("/options", session, passkeyHandler.RegisterOptions)
+	passkeys.POST("", session, passkeyHandler.Register)
+	passkeys.DELETE("/:id", session, passkeyHandler.Delete)
 	user.GET("/logout", authHandler.Logout)
 	user.GET("/refresh", authHandler.Refresh)
 
+	admin := router.Group("/admin", authenticated, limiter.Limit("user"), middleware.Authorize())
+	admin.POST("/impersonate/:id", write, impersonationHandler.Impersonate)
+	admin.DELETE("/impersonate", write, impersonationHandler.Stop)
+
 	return router
 }
+
+// recovery answers a panic as an internal error, the panic is logged by
+// web.MarshalError.
+func recovery(c *gin.Context, recovered interface{}) {
+	web.MarshalError(c, fmt.Errorf("panic: %v", recovered))
+	c.Abort()
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,10 +11,24 @@ import (
 	"restapi/internal/db/postgres"
 	"restapi/internal/db/redis"
 	"restapi/internal/logger"
+	"restapi/internal/tracing"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 func Start() error {
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			logger.Log().Err(err).Msg("failed to flush traces")
+		}
+	}()
+
 	postgresClient, err := postgres.NewClient()
 	if err != nil {
 		return err
@@ -26,11 +41,34 @@ func Start() error {
 	}
 	defer redisClient.Close()
 
+	// requests still running when the shutdown timeout expires are
+	// cancelled through their context
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", config.Cfg().APPPort),
-		Handler: NewRouter(postgresClient, redisClient),
+		Addr:        fmt.Sprintf(":%d", config.Cfg().APPPort),
+		Handler:     NewRouter(postgresClient, redisClient),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	adminServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Cfg().AdminPort),
+		Handler: NewAdminHandler(),
 	}
 
+	config.Watch()
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+
+		for range sighup {
+			err := config.Reload()
+			if err != nil {
+				logger.Log().Err(err).Msg("failed to reload config")
+			}
+		}
+	}()
+
 	idleConnsClosed := make(chan struct{})
 	go func() {
 		defer close(idleConnsClosed)
@@ -41,9 +79,32 @@ func Start() error {
 
 		<-sigint
 
-		err := httpServer.Shutdown(context.Background())
+		// fail readiness first and give the load balancer time to notice
+		atomic.StoreInt32(&shuttingDown, 1)
+		logger.Log().Info().Msgf("shutting down in %s", config.Cfg().ShutdownDelay)
+		time.Sleep(config.Cfg().ShutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Cfg().ShutdownTimeout)
+		defer cancel()
+
+		err := httpServer.Shutdown(ctx)
 		if err != nil {
-			logger.Log().Err(err).Msg("failed to shutdown server")
+			logger.Log().Err(err).Msg("failed to shutdown server, cancelling pending requests")
+			cancelRequests()
+			httpServer.Close()
+		}
+
+		err = adminServer.Shutdown(context.Background())
+		if err != nil {
+			logger.Log().Err(err).Msg("failed to shutdown admin server")
+		}
+	}()
+
+	go func() {
+		logger.Log().Info().Msgf("starting admin server on %s", adminServer.Addr)
+		err := adminServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Log().Err(err).Msg("admin server stopped")
 		}
 	}()
 
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"restapi/internal/constant"
 	"restapi/internal/web"
 	"time"
 
@@ -15,27 +16,30 @@ import (
 var captchaPool = pool.NewCaptchaPool(240, 80, 6, 2, 2, 2)
 var cacheBuffer *pool.CaptchaBody
 
-func CheckCaptchaSolver(valueSolution string, session sessions.Session) (bool, string) {
+func CheckCaptchaSolver(valueSolution string, session sessions.Session) error {
 	v := session.Get("captcha")
 	now := time.Now()
 
-	captchaTime := session.Get("captcha_time")
-	t := time.Unix(captchaTime.(int64), 0)
+	captchaTime, ok := session.Get("captcha_time").(int64)
+	if !ok {
+		return constant.ErrCaptchaEmpty
+	}
+
+	t := time.Unix(captchaTime, 0)
 	if t.Add(time.Minute).Before(now) {
-		return false, "captcha timeout"
+		return constant.ErrCaptchaTimeout
 	}
 
-	flag := false
 	str := fmt.Sprintf("%v", v)
 	if v == nil {
-		return flag, "captcha cannot empty"
+		return constant.ErrCaptchaEmpty
 	}
 
-	if flag = str == valueSolution; !flag {
-		return flag, "captcha not match"
-	} else {
-		return flag, "success"
+	if str != valueSolution {
+		return constant.ErrCaptchaMismatch
 	}
+
+	return nil
 }
 
 func CaptchaHandler(ctx *gin.Context) {
@@ -1,19 +1,227 @@
 package validation
 
 import (
+	"reflect"
+	"regexp"
+	"restapi/internal/config"
 	"restapi/internal/constant"
+	"restapi/internal/security/password"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/id"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	id_translations "github.com/go-playground/validator/v10/translations/id"
 )
 
+const defaultLocale = "en"
+
+var (
+	validate = validator.New()
+	uni      = ut.New(en.New(), en.New(), id.New())
+
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z]{4,10}$`)
+	// directory usernames such as j.doe, alice01 or bob, as wide as the
+	// username column
+	directoryUsernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._@-]{1,20}$`)
+)
+
+// rule is a custom validation tag with its message per locale. params
+// returns the values of the {1}, {2}... placeholders of the message.
+type rule struct {
+	fn       validator.Func
+	messages map[string]string
+	params   func() []string
+}
+
+var rules = map[string]rule{
+	"username": {
+		fn: func(fl validator.FieldLevel) bool {
+			return usernamePattern.MatchString(fl.Field().String())
+		},
+		messages: map[string]string{
+			"en": "{0} must be 4 to 10 letters",
+			"id": "{0} harus terdiri dari 4 sampai 10 huruf",
+		},
+	},
+	// login_username also accepts the usernames of the directory when ldap
+	// is one of AUTH_BACKENDS
+	"login_username": {
+		fn: func(fl validator.FieldLevel) bool {
+			username := fl.Field().String()
+			if usernamePattern.MatchString(username) {
+				return true
+			}
+			return config.Cfg().AuthBackendEnabled(config.AuthBackendLDAP) && directoryUsernamePattern.MatchString(username)
+		},
+		messages: map[string]string{
+			"en": "{0} is not a valid username",
+			"id": "{0} bukan username yang valid",
+		},
+	},
+	"password": {
+		fn: func(fl validator.FieldLevel) bool {
+			return password.CurrentPolicy().Valid(fl.Field().String())
+		},
+		messages: map[string]string{
+			"en": "{0} must be {1} to {2} characters and contain {3}",
+			"id": "{0} harus {1} sampai {2} karakter dan mengandung {3}",
+		},
+		params: func() []string {
+			p := password.CurrentPolicy()
+			return []string{strconv.Itoa(p.MinLength), strconv.Itoa(p.MaxLength), p.Describe()}
+		},
+	},
+}
+
+func init() {
+	validate.RegisterTagNameFunc(fieldName)
+
+	enTrans, _ := uni.GetTranslator("en")
+	idTrans, _ := uni.GetTranslator("id")
+	if err := en_translations.RegisterDefaultTranslations(validate, enTrans); err != nil {
+		panic(err)
+	}
+	if err := id_translations.RegisterDefaultTranslations(validate, idTrans); err != nil {
+		panic(err)
+	}
+
+	for tag, r := range rules {
+		if err := validate.RegisterValidation(tag, r.fn); err != nil {
+			panic(err)
+		}
+
+		for locale, message := range r.messages {
+			trans, _ := uni.GetTranslator(locale)
+			if err := validate.RegisterTranslation(tag, trans, registerMessage(tag, message), translate); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// Struct validates s and reports every failing field with English
+// messages.
 func Struct(s interface{}) error {
-	err := validator.New().Struct(s)
-	if err != nil {
-		errs := err.(validator.ValidationErrors)
-		for _, e := range errs {
-			return constant.NewErrFieldValidation(e)
+	return StructLocale(s, "")
+}
+
+// Request validates s with messages in the language asked by the
+// Accept-Language header of the request.
+func Request(c *gin.Context, s interface{}) error {
+	return StructLocale(s, c.GetHeader("Accept-Language"))
+}
+
+// StructLocale validates s and returns a constant.ValidationError listing
+// every failing field, with messages in the first language of
+// acceptLanguage that has translations, English otherwise.
+func StructLocale(s interface{}, acceptLanguage string) error {
+	err := validate.Struct(s)
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	trans := translator(acceptLanguage)
+	res := &constant.ValidationError{}
+	for _, e := range errs {
+		res.Fields = append(res.Fields, constant.FieldError{
+			Field:   field(e),
+			Rule:    e.Tag(),
+			Param:   e.Param(),
+			Message: e.Translate(trans),
+		})
+	}
+
+	return res
+}
+
+// fieldName names fields by their json, or yaml, key so errors match the
+// request body.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "yaml"} {
+		name := strings.SplitN(f.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+
+	return f.Name
+}
+
+// field returns the path of the field without the struct name, e.g.
+// users[0].password.
+func field(e validator.FieldError) string {
+	ns := e.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+
+	return e.Field()
+}
+
+// translator picks the translator of the preferred language of an
+// Accept-Language header, e.g. "id-ID,id;q=0.9,en;q=0.8".
+func translator(acceptLanguage string) ut.Translator {
+	type tag struct {
+		locale string
+		q      float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		params := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.ToLower(strings.SplitN(params[0], "-", 2)[0])
+		if locale == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range params[1:] {
+			if v := strings.TrimPrefix(strings.TrimSpace(p), "q="); v != p {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		tags = append(tags, tag{locale, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if trans, ok := uni.GetTranslator(t.locale); ok {
+			return trans
 		}
 	}
 
-	return nil
+	trans, _ := uni.GetTranslator(defaultLocale)
+	return trans
+}
+
+func registerMessage(tag, message string) validator.RegisterTranslationsFunc {
+	return func(trans ut.Translator) error {
+		return trans.Add(tag, message, true)
+	}
+}
+
+func translate(trans ut.Translator, fe validator.FieldError) string {
+	params := []string{fe.Field()}
+	if r := rules[fe.Tag()]; r.params != nil {
+		params = append(params, r.params()...)
+	}
+
+	msg, err := trans.T(fe.Tag(), params...)
+	if err != nil {
+		return fe.Error()
+	}
+
+	return msg
 }
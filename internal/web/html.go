@@ -0,0 +1,39 @@
+package web
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// MarshalHTML renders one of the pages in templates, e.g. login.html. The
+// pages must not be framed by other sites.
+func MarshalHTML(c *gin.Context, status int, name string, data interface{}) {
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Render(status, render.HTML{Template: templates, Name: name, Data: data})
+}
+
+// MarshalHTMLError renders err on the error page, errors other than
+// constant.Error are logged and shown as constant.ErrServer.
+func MarshalHTMLError(c *gin.Context, err error) {
+	var appErr *constant.Error
+	if !errors.As(err, &appErr) {
+		logger.Ctx(c.Request.Context()).Err(err).Msg("unexpected error")
+		appErr = constant.ErrServer
+	}
+
+	MarshalHTML(c, appErr.Status, "error.html", gin.H{"Title": http.StatusText(appErr.Status), "Message": appErr.Message})
+}
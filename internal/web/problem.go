@@ -0,0 +1,21 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problemRender writes a Problem with the problem+json content type, the
+// gin JSON renderer always sets application/json.
+type problemRender struct {
+	problem Problem
+}
+
+func (r problemRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	return json.NewEncoder(w).Encode(r.problem)
+}
+
+func (r problemRender) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ProblemContentType+"; charset=utf-8")
+}
@@ -1,19 +1,38 @@
 package web
 
 import (
+	"context"
+	"errors"
+	"restapi/internal/constant"
+	"restapi/internal/logger"
+
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	ProblemContentType = "application/problem+json"
+
+	// problemTypePrefix prefixes the error code to build the problem type,
+	// e.g. urn:restapi:problem:user_not_found.
+	problemTypePrefix = "urn:restapi:problem:"
+)
+
 type Respons struct {
 	Status  int         `json:"success"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
 }
 
-type ResponError struct {
-	Status  int         `json:"error"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type      string                `json:"type"`
+	Title     string                `json:"title"`
+	Status    int                   `json:"status"`
+	Detail    string                `json:"detail,omitempty"`
+	Instance  string                `json:"instance,omitempty"`
+	Code      string                `json:"code"`
+	RequestID string                `json:"request_id,omitempty"`
+	Errors    []constant.FieldError `json:"errors,omitempty"`
 }
 
 func MarshalPayload(c *gin.Context, code int, message string, payload interface{}) {
@@ -26,12 +45,69 @@ func MarshalPayload(c *gin.Context, code int, message string, payload interface{
 	c.JSON(code, res)
 }
 
-func MarshalError(c *gin.Context, code int, message string, payload interface{}) {
-	res := ResponError{
-		Status:  code,
-		Message: message,
-		Data:    payload,
+// MarshalError answers err as problem+json with the status of its
+// constant.Error. Other errors are logged and answered as
+// constant.ErrServer so raw database or library messages never reach the
+// client. Once the request deadline passed it answers
+// constant.ErrRequestTimeout, whatever error the deadline surfaced as in
+// the lower layers.
+func MarshalError(c *gin.Context, err error, fields ...constant.FieldError) {
+	if c.Request.Context().Err() == context.DeadlineExceeded {
+		err = constant.ErrRequestTimeout
 	}
 
-	c.JSON(code, res)
+	var appErr *constant.Error
+	if !errors.As(err, &appErr) {
+		logger.Ctx(c.Request.Context()).Err(err).Msg("unexpected error")
+		err, appErr = constant.ErrServer, constant.ErrServer
+	}
+
+	var validationErr *constant.ValidationError
+	if errors.As(err, &validationErr) {
+		fields = append(validationErr.Fields, fields...)
+	}
+
+	res := Problem{
+		Type:      problemTypePrefix + appErr.Code,
+		Title:     appErr.Message,
+		Status:    appErr.Status,
+		Instance:  c.Request.URL.Path,
+		Code:      appErr.Code,
+		RequestID: c.GetString("request_id"),
+		Errors:    fields,
+	}
+	if detail := err.Error(); detail != appErr.Message {
+		res.Detail = detail
+	}
+
+	c.Render(res.Status, problemRender{res})
+}
+
+// OAuthError is the error body of RFC 6749 section 5.2.
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// MarshalOAuthError answers err in the format OAuth2 clients expect
+// instead of problem+json. Errors other than constant.Error are logged and
+// answered as server_error.
+func MarshalOAuthError(c *gin.Context, err error) {
+	var appErr *constant.Error
+	if !errors.As(err, &appErr) {
+		logger.Ctx(c.Request.Context()).Err(err).Msg("unexpected error")
+		appErr = constant.ErrServer
+	}
+
+	res := OAuthError{Error: appErr.Code, Description: appErr.Message}
+	if appErr == constant.ErrServer {
+		res.Error = "server_error"
+	}
+	if appErr == constant.ErrInvalidClient {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(appErr.Status, res)
 }